@@ -13,14 +13,13 @@
 package artifacts
 
 import (
+	"context"
 	"fmt"
 	"github.com/eclipse/che-plugin-broker/cfg"
 
-	jsonrpc "github.com/eclipse/che-go-jsonrpc"
 	"github.com/eclipse/che-plugin-broker/common"
 	"github.com/eclipse/che-plugin-broker/model"
 	"github.com/eclipse/che-plugin-broker/utils"
-	"github.com/eclipse/che-plugin-broker/utils/mergeplugins"
 )
 
 // Broker is used to process Che plugins
@@ -28,58 +27,87 @@ type Broker struct {
 	common.Broker
 	ioUtils utils.IoUtil
 	rand    common.Random
+	metrics *utils.Metrics
+	// sharedExtensions are extensions installed in this run, by URL, when extensions
+	// are de-duplicated; nil otherwise
+	sharedExtensions map[string]installedExtension
 }
 
 // NewBroker creates Che broker instance
-func NewBroker(localhostSidecar bool) *Broker {
+func NewBroker(localhostSidecar bool, credentials utils.Credentials) *Broker {
+	metrics := utils.NewMetrics("artifacts", common.Redact)
+	metrics.SetWorkspace(cfg.RuntimeID.Workspace)
 	return &Broker{
 		Broker:  common.NewBroker(),
-		ioUtils: utils.New(),
+		ioUtils: utils.NewTimedIoUtil(utils.New(cfg.RequestTimeout, credentials, cfg.BundleDir), metrics),
 		rand:    common.NewRand(),
+		metrics: metrics,
 	}
 }
 
-func (b *Broker) fail(err error) error {
-	b.PubFailed(err.Error())
-	b.PubLog(err.Error())
-	return err
-}
-
-// PushEvents sets given tunnel as consumer of broker events.
-func (b *Broker) PushEvents(tun *jsonrpc.Tunnel) {
-	b.Broker.PushEvents(tun, model.BrokerStatusEventType, model.BrokerResultEventType, model.BrokerLogEventType)
+// PushEvents sets given sink as consumer of broker events.
+func (b *Broker) PushEvents(sink common.EventSink) {
+	b.Broker.PushEvents(sink, model.BrokerStatusEventType, model.BrokerResultEventType, model.BrokerLogEventType, model.BrokerPluginProgressEventType)
 }
 
 // Start downloads metas from plugin registry for specified
 // pluginFQNs and then executes plugins metas processing and sending data to Che master
-func (b *Broker) Start(pluginFQNs []model.PluginFQN, defaultRegistry string) error {
+func (b *Broker) Start(ctx context.Context, pluginFQNs []model.PluginFQN, defaultRegistries []string) error {
 	defer b.CloseConsumers()
 	b.PubStarted()
 	b.PrintInfo("Starting plugin artifacts broker")
+	ioUtils, err := common.ConfigureSignatureVerification(b.ioUtils)
+	if err != nil {
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
+	}
+	b.ioUtils = ioUtils
 
-	pluginMetas, err := utils.GetPluginMetas(pluginFQNs, defaultRegistry, b.ioUtils)
+	pluginMetas, err := common.ResolvePluginMetas(ctx, b.Broker, b.ioUtils, pluginFQNs, defaultRegistries)
 	if err != nil {
-		return b.fail(fmt.Errorf("Failed to download plugin meta: %s", err))
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, fmt.Errorf("Failed to download plugin meta: %w", err))
 	}
+	b.metrics.SetPluginsResolved(len(pluginMetas))
+	b.RegisterSecrets(pluginMetas)
+	common.WarnDependencies(b.Broker, pluginMetas)
 
+	var defaultRegistry string
+	if len(defaultRegistries) > 0 {
+		defaultRegistry = defaultRegistries[0]
+	}
 	err = utils.ResolveRelativeExtensionPaths(pluginMetas, defaultRegistry)
 	if err != nil {
-		return b.fail(err)
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
 	}
-	metasToProcess := pluginMetas
-	if cfg.MergePlugins{
-		var logs []string
-		metasToProcess, logs = mergeplugins.MergePlugins(pluginMetas)
-		b.PrintInfoBuffer(logs)
+	if err := common.CheckPolicy(b.ioUtils, pluginMetas); err != nil {
+		for _, meta := range pluginMetas {
+			b.PubPluginProgress(meta.ProgressID, model.PhaseFailed, 0, 0)
+		}
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
+	}
+	metasToProcess, err := common.MergePlugins(b.Broker, b.ioUtils, b.metrics, pluginMetas)
+	if err != nil {
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
 	}
 
 	requestedPlugins := convertMetasToPlugins(metasToProcess)
+	synced := b.metrics.StartPhase(utils.PhaseCacheSync, "")
 	toInstall := b.syncWithPluginsDir(requestedPlugins)
+	synced(0)
+	b.metrics.AddCacheStats(countCachedExtensions(toInstall))
+	if cfg.DedupeExtensions {
+		b.sharedExtensions = collectInstalledExtensions(toInstall)
+	}
 
+	// installed.json is written only when all plugins are installed. If brokering fails
+	// or is cancelled, the next run finds no installed.json and cleans up /plugins.
 	for _, plugin := range toInstall {
-		err = b.ProcessPlugin(&plugin)
+		err = ctx.Err()
+		if err == nil {
+			err = b.ProcessPlugin(ctx, &plugin)
+		}
 		if err != nil {
-			return b.fail(err)
+			b.pubProgress(&plugin, model.PhaseFailed, 0, 0)
+			return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
 		}
 	}
 
@@ -88,11 +116,37 @@ func (b *Broker) Start(pluginFQNs []model.PluginFQN, defaultRegistry string) err
 		b.PrintInfo("WARN: Failed to log installed plugins: %s", err)
 	}
 
+	for _, meta := range pluginMetas {
+		b.PubPluginProgress(meta.ProgressID, model.PhaseDone, 0, 0)
+	}
 	b.PrintInfo("All plugin artifacts have been successfully downloaded")
+	common.ReportMetrics(b.Broker, b.ioUtils, b.metrics, model.StatusDone)
 	b.PubDone("")
 	return nil
 }
 
+// pubProgress publishes progress of plugin as progress of each of its progress IDs
+func (b *Broker) pubProgress(plugin *model.CachedPlugin, phase model.PluginPhase, bytesDownloaded int64, bytesTotal int64) {
+	for _, progressID := range plugin.ProgressIDs {
+		b.PubPluginProgress(progressID, phase, bytesDownloaded, bytesTotal)
+	}
+}
+
+// countCachedExtensions returns the number of extensions of plugins that are already
+// present in the plugins cache and the number of extensions that need to be downloaded
+func countCachedExtensions(plugins []model.CachedPlugin) (hits int, misses int) {
+	for _, plugin := range plugins {
+		for _, path := range plugin.CachedExtensions {
+			if path != "" {
+				hits++
+			} else {
+				misses++
+			}
+		}
+	}
+	return hits, misses
+}
+
 func convertMetasToPlugins(metas []model.PluginMeta) []model.CachedPlugin {
 	plugins := make([]model.CachedPlugin, 0)
 
@@ -102,6 +156,14 @@ func convertMetasToPlugins(metas []model.PluginMeta) []model.CachedPlugin {
 		}
 		plugin := model.CachedPlugin{}
 		plugin.ID = meta.ID
+		plugin.RequestedID = meta.RequestedID
+		if len(meta.MergedPlugins) > 0 {
+			for _, merged := range meta.MergedPlugins {
+				plugin.ProgressIDs = append(plugin.ProgressIDs, merged.ProgressID)
+			}
+		} else {
+			plugin.ProgressIDs = []string{meta.ProgressID}
+		}
 		if len(meta.Spec.Containers) > 0 {
 			plugin.IsRemote = true
 		}
@@ -109,6 +171,10 @@ func convertMetasToPlugins(metas []model.PluginMeta) []model.CachedPlugin {
 		for _, ext := range meta.Spec.Extensions {
 			plugin.CachedExtensions[ext] = ""
 		}
+		if meta.Signature != "" {
+			plugin.Signature = meta.Signature
+			plugin.ExtensionSignatures = make(map[string]model.SignatureStatus)
+		}
 		plugins = append(plugins, plugin)
 	}
 
@@ -13,14 +13,18 @@
 package artifacts
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"testing"
 
+	"github.com/eclipse/che-plugin-broker/cfg"
 	commonMock "github.com/eclipse/che-plugin-broker/common/mocks"
 	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
 	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -45,12 +49,15 @@ func initMocks() *mocks {
 	commonBroker.On("PrintInfo", mock.AnythingOfType("string"), mock.Anything)
 	commonBroker.On("PrintInfo", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything)
 	commonBroker.On("PrintInfoBuffer", mock.Anything)
+	commonBroker.On("PrintPluginInfoBuffer", mock.AnythingOfType("string"), mock.Anything)
 	commonBroker.On("PrintDebug", mock.AnythingOfType("string"))
 	commonBroker.On("PrintDebug", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything)
-	commonBroker.On("PubFailed", mock.AnythingOfType("string"))
+	commonBroker.On("PubFailed", mock.AnythingOfType("string"), mock.Anything)
 	commonBroker.On("PubLog", mock.AnythingOfType("string"))
+	commonBroker.On("PubPluginProgress", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything)
 	commonBroker.On("PubStarted")
 	commonBroker.On("PrintPlan", mock.AnythingOfType("[]model.PluginMeta"))
+	commonBroker.On("RegisterSecrets", mock.AnythingOfType("[]model.PluginMeta"))
 	commonBroker.On("CloseConsumers")
 	commonBroker.On("PubDone", mock.AnythingOfType("string"))
 
@@ -62,6 +69,7 @@ func initMocks() *mocks {
 			Broker:  commonBroker,
 			ioUtils: ioUtils,
 			rand:    rand,
+			metrics: utils.NewMetrics("artifacts", nil),
 		},
 	}
 }
@@ -75,36 +83,39 @@ func TestStartFailsIfFetchFails(t *testing.T) {
 
 	m := initMocks()
 	m.ioUtils.On("GetFilesByGlob", mock.AnythingOfType("string")).Return([]string{}, nil)
-	m.ioUtils.On("Fetch", mock.AnythingOfType("string")).Return(nil, expectedError)
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).Return(nil, expectedError)
 
-	err := m.broker.Start(pluginFQNs, "default.io")
+	err := m.broker.Start(context.Background(), pluginFQNs, []string{"default.io"})
 	assert.EqualError(t, err, expectedErrorString)
-	m.commonBroker.AssertCalled(t, "PubFailed", expectedErrorString)
+	m.commonBroker.AssertCalled(t, "PubFailed", expectedErrorString, mock.Anything)
 	m.commonBroker.AssertCalled(t, "PubLog", expectedErrorString)
 }
 
-func TestFailureResolvingRelativeExtensionPaths(t *testing.T) {
+func TestStartResolvesRelativeExtensionPathsAgainstReference(t *testing.T) {
 	_, pluginMetaBytes := loadPluginMetaFromFile(t, "vscode-java-0.50.0-relative.yaml")
-	defaultRegistry := ""
 	pluginFQNs := []model.PluginFQN{
-		generatePluginFQN("testRegistry", "testID", ""),
+		generatePluginFQN("", "", "http://reference.io/java/meta.yaml"),
 	}
-	expectedErrorString := "cannot resolve relative extension path without default registry"
+	expectedURL := "http://reference.io/java/path/to/test.vsix"
+	expectedErrorString := "failed to download plugin from " + expectedURL + ": test error"
 
 	m := initMocks()
+	m.ioUtils.On("ReadFile", mock.AnythingOfType("string")).Return(nil, fmt.Errorf("Disabled for tests"))
+	m.ioUtils.On("WriteFile", mock.AnythingOfType("string"), mock.Anything).Return(nil)
+	m.ioUtils.On("RemoveFile", mock.AnythingOfType("string")).Return(nil)
 	m.ioUtils.On("GetFilesByGlob", mock.AnythingOfType("string")).Return([]string{}, nil)
-	m.ioUtils.On("Fetch", mock.AnythingOfType("string")).Return(pluginMetaBytes, nil)
+	m.ioUtils.On("Fetch", mock.Anything, "http://reference.io/java/meta.yaml").Return(pluginMetaBytes, nil)
+	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("/tmp/test", nil)
+	m.ioUtils.On("ResolveDestPathFromURL", expectedURL, "/tmp/test").Return("/tmp/test/test.vsix")
+	m.ioUtils.On("Download", mock.Anything, expectedURL, "/tmp/test/test.vsix", true, mock.Anything).Return("", fmt.Errorf("test error"))
 
-	err := m.broker.Start(pluginFQNs, defaultRegistry)
+	err := m.broker.Start(context.Background(), pluginFQNs, []string{"default.io"})
 	assert.EqualError(t, err, expectedErrorString)
-	m.commonBroker.AssertCalled(t, "PubFailed", expectedErrorString)
-	m.commonBroker.AssertCalled(t, "PubLog", expectedErrorString)
-	m.commonBroker.AssertCalled(t, "CloseConsumers")
+	m.ioUtils.AssertCalled(t, "Download", mock.Anything, expectedURL, "/tmp/test/test.vsix", true, mock.Anything)
 }
 
 func TestStartPropagatesErrorOnPluginProcessing(t *testing.T) {
 	_, pluginMetaBytes := loadPluginMetaFromFile(t, "vscode-java-0.50.0.yaml")
-	defaultRegistry := ""
 
 	pluginFQNs := []model.PluginFQN{
 		generatePluginFQN("testRegistry", "testID", ""),
@@ -116,13 +127,14 @@ func TestStartPropagatesErrorOnPluginProcessing(t *testing.T) {
 	m.ioUtils.On("WriteFile", mock.AnythingOfType("string"), mock.Anything).Return(nil)
 	m.ioUtils.On("RemoveFile", mock.AnythingOfType("string")).Return(nil)
 	m.ioUtils.On("GetFilesByGlob", mock.AnythingOfType("string")).Return([]string{}, nil)
-	m.ioUtils.On("Fetch", mock.AnythingOfType("string")).Return(pluginMetaBytes, nil)
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).Return(pluginMetaBytes, nil)
 	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("", fmt.Errorf(expectedErrorString))
 
-	err := m.broker.Start(pluginFQNs, defaultRegistry)
+	err := m.broker.Start(context.Background(), pluginFQNs, nil)
 	assert.EqualError(t, err, expectedErrorString)
-	m.commonBroker.AssertCalled(t, "PubFailed", expectedErrorString)
+	m.commonBroker.AssertCalled(t, "PubFailed", expectedErrorString, mock.Anything)
 	m.commonBroker.AssertCalled(t, "PubLog", expectedErrorString)
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "testID", model.PhaseFailed, int64(0), int64(0))
 	m.commonBroker.AssertCalled(t, "CloseConsumers")
 	// Make sure cached plugins file is not written
 	m.ioUtils.AssertNotCalled(t, "WriteFile", mock.Anything, mock.Anything)
@@ -134,23 +146,57 @@ func TestStartSuccessfulFlow(t *testing.T) {
 	m.ioUtils.On("WriteFile", mock.AnythingOfType("string"), mock.Anything).Return(nil)
 	m.ioUtils.On("RemoveFile", mock.AnythingOfType("string")).Return(nil)
 	m.ioUtils.On("GetFilesByGlob", mock.AnythingOfType("string")).Return([]string{}, nil)
-	m.ioUtils.On("Fetch", "testRegistry/plugins/testID/meta.yaml").Return([]byte{}, nil)
+	m.ioUtils.On("Fetch", mock.Anything, "testRegistry/plugins/testID/meta.yaml").Return([]byte{}, nil)
 
 	defaultRegistry := "default.io"
 	pluginFQNs := []model.PluginFQN{
 		generatePluginFQN("testRegistry", "testID", ""),
 	}
 
-	err := m.broker.Start(pluginFQNs, defaultRegistry)
+	err := m.broker.Start(context.Background(), pluginFQNs, []string{defaultRegistry})
 	assert.Nil(t, err)
 
 	m.commonBroker.AssertCalled(t, "PubStarted")
 	m.commonBroker.AssertCalled(t, "PrintInfo", "Starting plugin artifacts broker")
 	m.commonBroker.AssertCalled(t, "PrintInfo", "All plugin artifacts have been successfully downloaded")
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "testID", model.PhaseResolving, int64(0), int64(0))
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "testID", model.PhaseDone, int64(0), int64(0))
 	m.commonBroker.AssertCalled(t, "PubDone", "")
 	m.commonBroker.AssertCalled(t, "CloseConsumers")
 }
 
+func TestStartWritesMetricsReport(t *testing.T) {
+	cfg.MetricsReportPath = "/tmp/report.json"
+	defer func() { cfg.MetricsReportPath = "" }()
+	m := initMocks()
+	m.ioUtils.On("ReadFile", mock.AnythingOfType("string")).Return(nil, fmt.Errorf("Disabled for tests"))
+	m.ioUtils.On("WriteFile", mock.AnythingOfType("string"), mock.Anything).Return(nil)
+	m.ioUtils.On("RemoveFile", mock.AnythingOfType("string")).Return(nil)
+	m.ioUtils.On("GetFilesByGlob", mock.AnythingOfType("string")).Return([]string{}, nil)
+	m.ioUtils.On("Fetch", mock.Anything, "testRegistry/plugins/testID/meta.yaml").Return([]byte{}, nil)
+
+	err := m.broker.Start(context.Background(), []model.PluginFQN{generatePluginFQN("testRegistry", "testID", "")}, []string{"default.io"})
+	assert.Nil(t, err)
+
+	m.ioUtils.AssertCalled(t, "WriteFile", "/tmp/report.json", mock.MatchedBy(func(data []byte) bool {
+		var report utils.RunReport
+		return json.Unmarshal(data, &report) == nil && report.Broker == "artifacts" && report.Status == model.StatusDone
+	}))
+	m.commonBroker.AssertCalled(t, "PrintInfoBuffer", mock.MatchedBy(func(summary []string) bool {
+		return len(summary) > 0 && summary[0] == "Plugin artifacts broker run summary"
+	}))
+}
+
+func TestCountCachedExtensions(t *testing.T) {
+	plugins := []model.CachedPlugin{
+		{ID: "a", CachedExtensions: map[string]string{"ext1": "/plugins/ext1.vsix", "ext2": ""}},
+		{ID: "b", CachedExtensions: map[string]string{"ext3": ""}},
+	}
+	hits, misses := countCachedExtensions(plugins)
+	assert.Equal(t, 1, hits)
+	assert.Equal(t, 2, misses)
+}
+
 func TestConvertMetasToPluginsExcludesChePlugins(t *testing.T) {
 	meta, _ := loadPluginMetaFromFile(t, "machine-exec-7.4.0.yaml")
 	metas := []model.PluginMeta{meta}
@@ -186,6 +232,22 @@ func TestConvertMetasToPluginsConvertsNonRemoteVSCodePlugin(t *testing.T) {
 	}
 }
 
+func TestConvertMetasToPluginsKeepsProgressIDs(t *testing.T) {
+	meta, _ := loadPluginMetaFromFile(t, "remote-vscode-ext.yaml")
+	meta.ProgressID = "https://reference.io/plugins/ext/meta.yaml"
+	merged := meta
+	merged.ID = "merged/plugin/latest"
+	merged.MergedPlugins = []model.MergedPlugin{
+		{ID: "pub/first/1.0.0", Version: "1.0.0", ProgressID: "pub/first/latest"},
+		{ID: "pub/second/1.0.0", Version: "1.0.0", ProgressID: "pub/second/1.0.0"},
+	}
+
+	output := convertMetasToPlugins([]model.PluginMeta{meta, merged})
+
+	assert.Equal(t, []string{"https://reference.io/plugins/ext/meta.yaml"}, output[0].ProgressIDs)
+	assert.Equal(t, []string{"pub/first/latest", "pub/second/1.0.0"}, output[1].ProgressIDs)
+}
+
 func loadPluginMetaFromFile(t *testing.T, filename string) (model.PluginMeta, []byte) {
 	path := filepath.Join("../testdata", filename)
 	bytes, err := ioutil.ReadFile(path)
@@ -206,3 +268,23 @@ func generatePluginFQN(registry, id, reference string) model.PluginFQN {
 		Reference: reference,
 	}
 }
+
+func TestStartReportsCancellation(t *testing.T) {
+	_, pluginMetaBytes := loadPluginMetaFromFile(t, "vscode-java-0.50.0.yaml")
+	m := initMocks()
+	m.ioUtils.On("ReadFile", mock.AnythingOfType("string")).Return(nil, fmt.Errorf("Disabled for tests"))
+	m.ioUtils.On("RemoveFile", mock.AnythingOfType("string")).Return(nil)
+	m.ioUtils.On("GetFilesByGlob", mock.AnythingOfType("string")).Return([]string{}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { cancel() }).
+		Return(pluginMetaBytes, nil)
+
+	err := m.broker.Start(ctx, []model.PluginFQN{generatePluginFQN("testRegistry", "testID", "")}, nil)
+
+	assert.EqualError(t, err, "plugin brokering was cancelled")
+	m.commonBroker.AssertCalled(t, "PubFailed", "plugin brokering was cancelled", &model.ErrorDetails{Code: model.ErrorCodeCancelled})
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "testID", model.PhaseFailed, int64(0), int64(0))
+	m.ioUtils.AssertNotCalled(t, "TempDir", mock.Anything, mock.Anything)
+	m.ioUtils.AssertNotCalled(t, "WriteFile", mock.Anything, mock.Anything)
+}
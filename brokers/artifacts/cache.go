@@ -18,8 +18,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 
+	"github.com/eclipse/che-plugin-broker/cfg"
 	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
 )
 
 const installedPluginsJSONFile = "/plugins/installed.json"
@@ -52,21 +55,31 @@ func (b *Broker) syncWithPluginsDir(requested []model.CachedPlugin) (toInstall [
 // be removed from the filesystem.
 func (b *Broker) preparePluginsToInstall(requested, installed []model.CachedPlugin) (toInstall []model.CachedPlugin) {
 	toInstall = requested
+	kept := keptExtensionPaths(requested, installed)
 	for _, plugin := range installed {
 		match := findPlugin(plugin, requested)
 		if match == nil {
 			// Plugin has been uninstalled since last start
 			b.PrintInfo("Uninstalling plugin: %s", plugin.ID)
-			if err := b.removePlugin(plugin); err != nil {
+			if err := b.removePlugin(plugin, kept); err != nil {
 				b.PrintInfo("WARN: failed to remove plugin artifacts: %s", err)
 			}
 			continue
 		}
 		for ext, path := range plugin.CachedExtensions {
-			if _, ok := match.CachedExtensions[ext]; ok {
+			_, requested := match.CachedExtensions[ext]
+			signature := plugin.ExtensionSignatures[ext]
+			if requested && !isSignatureAccepted(signature) {
+				b.PrintInfo("Extension %s of plugin %s was installed without verified signature and is downloaded again", ext, plugin.ID)
+				requested = false
+			}
+			if requested {
 				// Extension is already downloaded, fill path in struct to avoid downloading later.
 				match.CachedExtensions[ext] = path
-			} else {
+				if match.ExtensionSignatures != nil && signature != "" {
+					match.ExtensionSignatures[ext] = signature
+				}
+			} else if !kept[path] {
 				// Downloaded plugin is not used in current workspace and must be removed.
 				err := b.ioUtils.RemoveFile(path)
 				if err != nil {
@@ -78,6 +91,31 @@ func (b *Broker) preparePluginsToInstall(requested, installed []model.CachedPlug
 	return toInstall
 }
 
+// keptExtensionPaths returns paths of installed extensions that are still requested. With
+// de-duplicated extensions, plugins can share an extension file, which must be kept as long
+// as any plugin still references it.
+func keptExtensionPaths(requested, installed []model.CachedPlugin) map[string]bool {
+	kept := make(map[string]bool)
+	for _, plugin := range installed {
+		match := findPlugin(plugin, requested)
+		if match == nil {
+			continue
+		}
+		for ext, path := range plugin.CachedExtensions {
+			if _, requested := match.CachedExtensions[ext]; requested && isSignatureAccepted(plugin.ExtensionSignatures[ext]) {
+				kept[path] = true
+			}
+		}
+	}
+	return kept
+}
+
+// isSignatureAccepted returns false if signature of an installed extension is not verified
+// although signature policy is enforced
+func isSignatureAccepted(signature model.SignatureStatus) bool {
+	return utils.SignaturePolicy(cfg.SignaturePolicy) != utils.SignaturePolicyEnforce || signature == model.SignatureVerified
+}
+
 func (b *Broker) readInstalledPlugins() ([]model.CachedPlugin, error) {
 	bytes, err := b.ioUtils.ReadFile(installedPluginsJSONFile)
 	if err != nil {
@@ -122,6 +160,9 @@ func (b *Broker) writeInstalledPlugins(cached []model.CachedPlugin) error {
 		Version: installedPluginsJSONVersion,
 		Plugins: cached,
 	}
+	if cfg.DedupeExtensions {
+		cachedJSON.SharedExtensions = findSharedExtensions(cached)
+	}
 	bytes, err := json.MarshalIndent(cachedJSON, "", "  ")
 	if err != nil {
 		return err
@@ -130,7 +171,29 @@ func (b *Broker) writeInstalledPlugins(cached []model.CachedPlugin) error {
 	return err
 }
 
-func (b *Broker) removePlugin(plugin model.CachedPlugin) error {
+// findSharedExtensions returns extensions referenced by more than one of plugins, sorted by URL
+func findSharedExtensions(plugins []model.CachedPlugin) []model.SharedExtension {
+	references := make(map[string][]string)
+	for _, plugin := range plugins {
+		for URL := range plugin.CachedExtensions {
+			references[URL] = append(references[URL], plugin.ID)
+		}
+	}
+	var shared []model.SharedExtension
+	for URL, pluginIDs := range references {
+		if len(pluginIDs) > 1 {
+			shared = append(shared, model.SharedExtension{URL: URL, Plugins: pluginIDs})
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		return shared[i].URL < shared[j].URL
+	})
+	return shared
+}
+
+// removePlugin removes installed extensions of plugin, except for extension files in
+// kept, which are shared with plugins that are still installed
+func (b *Broker) removePlugin(plugin model.CachedPlugin, kept map[string]bool) error {
 	if plugin.IsRemote {
 		// remote plugins have a subdirectory in /plugins/sidecars
 		for _, extPath := range plugin.CachedExtensions {
@@ -140,6 +203,9 @@ func (b *Broker) removePlugin(plugin model.CachedPlugin) error {
 	} else {
 		// non-remote plugins are stored in /plugins as single files
 		for _, extPath := range plugin.CachedExtensions {
+			if kept[extPath] {
+				continue
+			}
 			return b.ioUtils.RemoveFile(extPath)
 		}
 	}
@@ -19,7 +19,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/eclipse/che-plugin-broker/cfg"
 	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -76,6 +78,35 @@ func TestPreparePluginsToInstall(t *testing.T) {
 	m.ioUtils.AssertCalled(t, "RemoveAll", "/3-dir")
 }
 
+func TestPreparePluginsToInstallDownloadsUnverifiedExtensionsWhenSignaturesAreEnforced(t *testing.T) {
+	defer func(policy string) { cfg.SignaturePolicy = policy }(cfg.SignaturePolicy)
+	cfg.SignaturePolicy = string(utils.SignaturePolicyEnforce)
+	requested := []model.CachedPlugin{
+		generateCachedPlugin(t, "testPlugin", false, "verifiedUrl", "", "unsignedUrl", "", "uncheckedUrl", ""),
+	}
+	requested[0].ExtensionSignatures = map[string]model.SignatureStatus{}
+	installed := []model.CachedPlugin{
+		generateCachedPlugin(t, "testPlugin", false, "verifiedUrl", "/plugins/verified", "unsignedUrl", "/plugins/unsigned", "uncheckedUrl", "/plugins/unchecked"),
+	}
+	installed[0].ExtensionSignatures = map[string]model.SignatureStatus{
+		"verifiedUrl": model.SignatureVerified,
+		"unsignedUrl": model.SignatureUnsigned,
+	}
+	expected := generateCachedPlugin(t, "testPlugin", false, "verifiedUrl", "/plugins/verified", "unsignedUrl", "", "uncheckedUrl", "")
+	expected.ExtensionSignatures = map[string]model.SignatureStatus{"verifiedUrl": model.SignatureVerified}
+
+	m := initMocks()
+	m.commonBroker.On("PrintInfo", mock.AnythingOfType("string"), mock.Anything, mock.Anything)
+	m.ioUtils.On("RemoveFile", mock.AnythingOfType("string")).Return(nil)
+
+	output := m.broker.preparePluginsToInstall(requested, installed)
+
+	assert.Equal(t, []model.CachedPlugin{expected}, output)
+	m.ioUtils.AssertCalled(t, "RemoveFile", "/plugins/unsigned")
+	m.ioUtils.AssertCalled(t, "RemoveFile", "/plugins/unchecked")
+	m.ioUtils.AssertNotCalled(t, "RemoveFile", "/plugins/verified")
+}
+
 func TestPreparePluginsToInstallIsRemoteMustMatchLocal(t *testing.T) {
 	requested := []model.CachedPlugin{
 		generateCachedPlugin(t, "testPlugin", true, "testUrl", ""),
@@ -446,3 +477,54 @@ func generateInstalledPluginJSON(t *testing.T, version string, plugins ...model.
 	}
 	return pluginsJSON, pluginsJSONBytes
 }
+
+func TestPreparePluginsToInstallKeepsSharedExtensions(t *testing.T) {
+	shared := generateCachedPlugin(t, "test/shared", false,
+		"test.shared.url", "/plugins/shared.vsix")
+	removed := generateCachedPlugin(t, "test/removed", false,
+		"test.shared.url", "/plugins/shared.vsix")
+	changed := generateCachedPlugin(t, "test/changed", false,
+		"test.shared.url", "/plugins/shared.vsix")
+	requestedChanged := generateCachedPlugin(t, "test/changed", false,
+		"test.other.url", "")
+
+	m := initMocks()
+	m.ioUtils.On("RemoveFile", mock.Anything).Return(nil)
+
+	m.broker.preparePluginsToInstall(
+		[]model.CachedPlugin{shared, requestedChanged},
+		[]model.CachedPlugin{shared, removed, changed})
+
+	m.ioUtils.AssertNotCalled(t, "RemoveFile", "/plugins/shared.vsix")
+}
+
+func TestWriteInstalledPluginsListsSharedExtensions(t *testing.T) {
+	cfg.DedupeExtensions = true
+	defer func() { cfg.DedupeExtensions = false }()
+	plugins := []model.CachedPlugin{
+		generateCachedPlugin(t, "test/first", false,
+			"b.url", "/plugins/b.vsix",
+			"a.url", "/plugins/a.vsix"),
+		generateCachedPlugin(t, "test/second", true,
+			"a.url", "/plugins/sidecars/test_second/a.vsix",
+			"b.url", "/plugins/sidecars/test_second/b.vsix",
+			"c.url", "/plugins/sidecars/test_second/c.vsix"),
+	}
+
+	m := initMocks()
+	m.ioUtils.On("WriteFile", mock.Anything, mock.Anything).Return(nil)
+
+	err := m.broker.writeInstalledPlugins(plugins)
+
+	assert.Nil(t, err)
+	m.ioUtils.AssertCalled(t, "WriteFile", installedPluginsJSONFile, mock.MatchedBy(func(bytes []byte) bool {
+		var installedJSON model.InstalledPluginJSON
+		if err := json.Unmarshal(bytes, &installedJSON); err != nil {
+			t.Fatal("Failed to unmarshal json")
+		}
+		return assert.Equal(t, []model.SharedExtension{
+			{URL: "a.url", Plugins: []string{"test/first", "test/second"}},
+			{URL: "b.url", Plugins: []string{"test/first", "test/second"}},
+		}, installedJSON.SharedExtensions)
+	}))
+}
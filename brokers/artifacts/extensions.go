@@ -13,19 +13,26 @@
 package artifacts
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/eclipse/che-plugin-broker/model"
 	"github.com/eclipse/che-plugin-broker/utils"
 )
 
+// progressInterval is the minimal interval between two download progress events of a plugin
+const progressInterval = time.Second
+
 // ProcessPlugin downloads all undownloaded plugin extensions and places the
 // relevant artifacts in their appropriate location in the /plugins directory.
 // If a plugin already has artifacts downloaded for a given extension, that
-// extension is skipped.
-func (b *Broker) ProcessPlugin(plugin *model.CachedPlugin) error {
+// extension is skipped. Processing stops when ctx is done; extensions installed
+// until then are recorded in plugin.
+func (b *Broker) ProcessPlugin(ctx context.Context, plugin *model.CachedPlugin) error {
 	workDir, err := b.ioUtils.TempDir("", "artifacts-broker")
 	if err != nil {
 		return err
@@ -34,7 +41,7 @@ func (b *Broker) ProcessPlugin(plugin *model.CachedPlugin) error {
 	// Workaround: messages can be displayed out of order in the workspace loading page
 	// Collect messages in a buffer and print them as a single string when needed.
 	logBuf := make([]string, 0)
-	defer b.flushLog(&logBuf)
+	defer b.flushLog(plugin.ID, &logBuf)
 
 	logBuf = append(logBuf, fmt.Sprintf("Processing plugin %s", plugin.ID))
 	numExtensions := len(plugin.CachedExtensions)
@@ -46,31 +53,171 @@ func (b *Broker) ProcessPlugin(plugin *model.CachedPlugin) error {
 			logBuf = append(logBuf, "    Plugin already downloaded")
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if shared, ok := b.sharedExtensions[URL]; ok {
+			logBuf = append(logBuf, fmt.Sprintf("    Sharing plugin installed for %s", shared.pluginID))
+			pluginPath, err := b.shareExtension(plugin, shared)
+			if err != nil {
+				return err
+			}
+			plugin.CachedExtensions[URL] = pluginPath
+			if shared.signature != "" {
+				if plugin.ExtensionSignatures == nil {
+					plugin.ExtensionSignatures = make(map[string]model.SignatureStatus)
+				}
+				plugin.ExtensionSignatures[URL] = shared.signature
+			}
+			continue
+		}
 		logBuf = append(logBuf, fmt.Sprintf("    Downloading plugin from %s", URL))
-		logBuf = b.flushLog(&logBuf)
-		archivePath, err := b.downloadArchive(URL, plugin.ID, workDir)
+		logBuf = b.flushLog(plugin.ID, &logBuf)
+		archivePath, err := b.downloadArchive(ctx, URL, plugin, workDir)
 		if err != nil {
 			return err
 		}
+		b.pubProgress(plugin, model.PhaseInstalling, 0, 0)
 		pluginPath, err := b.injectPlugin(plugin, archivePath)
 		if err != nil {
 			return err
 		}
 		plugin.CachedExtensions[URL] = pluginPath
+		var signature model.SignatureStatus
+		if result, ok := utils.GetSignatureResult(b.ioUtils, URL); ok {
+			signature = result.Status
+			if plugin.ExtensionSignatures == nil {
+				plugin.ExtensionSignatures = make(map[string]model.SignatureStatus)
+			}
+			plugin.ExtensionSignatures[URL] = result.Status
+			if result.Status == model.SignatureVerified {
+				logBuf = append(logBuf, fmt.Sprintf("    Signature verified with key %s", result.KeyID))
+			} else {
+				logBuf = append(logBuf, fmt.Sprintf("    WARN: Extension is %s", result))
+			}
+		}
+		if b.sharedExtensions != nil {
+			b.sharedExtensions[URL] = installedExtension{
+				pluginID:    plugin.ID,
+				isRemote:    plugin.IsRemote,
+				path:        pluginPath,
+				archiveName: filepath.Base(archivePath),
+				signature:   signature,
+			}
+		}
 	}
 	return nil
 }
 
-func (b *Broker) downloadArchive(URL string, pluginID string, workDir string) (string, error) {
+// installedExtension is an extension installed for a plugin, which other plugins embedding
+// the same extension can share
+type installedExtension struct {
+	pluginID    string
+	isRemote    bool
+	path        string
+	archiveName string
+	signature   model.SignatureStatus
+}
+
+// collectInstalledExtensions returns extensions of plugins that are already installed, by URL
+func collectInstalledExtensions(plugins []model.CachedPlugin) map[string]installedExtension {
+	installed := make(map[string]installedExtension)
+	for _, plugin := range plugins {
+		for URL, path := range plugin.CachedExtensions {
+			if _, ok := installed[URL]; ok || path == "" {
+				continue
+			}
+			installed[URL] = installedExtension{
+				pluginID:    plugin.ID,
+				isRemote:    plugin.IsRemote,
+				path:        path,
+				archiveName: installedArchiveName(plugin.ID, path),
+				signature:   plugin.ExtensionSignatures[URL],
+			}
+		}
+	}
+	return installed
+}
+
+// installedArchiveName returns the name of the downloaded archive of an extension installed
+// for a plugin to path, see generatePluginArchiveName
+func installedArchiveName(pluginID string, path string) string {
+	name := filepath.Base(path)
+	prefix := strings.ReplaceAll(pluginID, "/", ".") + "."
+	if parts := strings.SplitN(strings.TrimPrefix(name, prefix), ".", 2); strings.HasPrefix(name, prefix) && len(parts) == 2 {
+		return parts[1]
+	}
+	return name
+}
+
+// shareExtension installs an extension installed for another plugin for plugin without
+// downloading it again. Plugins that run in Theia share the same file, so that the extension
+// is loaded only once; plugins that run in sidecars get a hardlink in their directory.
+func (b *Broker) shareExtension(plugin *model.CachedPlugin, shared installedExtension) (string, error) {
+	if !plugin.IsRemote && !shared.isRemote {
+		return shared.path, nil
+	}
+	pluginPath, err := b.pluginDir(plugin)
+	if err != nil {
+		return "", err
+	}
+	pluginArchivePath := filepath.Join(pluginPath, b.generatePluginArchiveName(plugin, shared.archiveName))
+	if err := b.ioUtils.LinkFile(shared.path, pluginArchivePath); err != nil {
+		// e.g. sidecar directories are on different file systems
+		b.PrintDebug("Failed to link %s to %s, copying it: %s", shared.path, pluginArchivePath, err)
+		if err := b.ioUtils.CopyFile(shared.path, pluginArchivePath); err != nil {
+			return "", err
+		}
+	}
+	return pluginArchivePath, nil
+}
+
+func (b *Broker) downloadArchive(ctx context.Context, URL string, plugin *model.CachedPlugin, workDir string) (string, error) {
 	archivePath := b.ioUtils.ResolveDestPathFromURL(URL, workDir)
-	archivePath, err := b.ioUtils.Download(URL, archivePath, true)
+	archivePath, err := b.ioUtils.Download(ctx, URL, archivePath, true, b.downloadProgress(plugin))
 	if err != nil {
-		return "", fmt.Errorf("failed to download plugin from %s: %s", URL, err)
+		var brokerErr *utils.BrokerError
+		if errors.As(err, &brokerErr) {
+			// e.g. failed signature verification
+			brokerErr.Details.PluginID = plugin.ID
+			return "", brokerErr
+		}
+		return "", utils.NewRequestError(model.ErrorCodeDownloadFailed, plugin.ID, URL, err,
+			fmt.Sprintf("failed to download plugin from %s: %s", URL, err))
 	}
 	return archivePath, nil
 }
 
+// downloadProgress returns a callback that publishes download progress of a plugin,
+// at most once per progressInterval and once the download completes.
+func (b *Broker) downloadProgress(plugin *model.CachedPlugin) func(downloaded int64, total int64) {
+	var lastPublished time.Time
+	return func(downloaded int64, total int64) {
+		if downloaded == total || time.Since(lastPublished) >= progressInterval {
+			lastPublished = time.Now()
+			b.pubProgress(plugin, model.PhaseDownloading, downloaded, total)
+		}
+	}
+}
+
 func (b *Broker) injectPlugin(plugin *model.CachedPlugin, archivePath string) (string, error) {
+	pluginPath, err := b.pluginDir(plugin)
+	if err != nil {
+		return "", err
+	}
+	pluginArchiveName := b.generatePluginArchiveName(plugin, archivePath)
+	pluginArchivePath := filepath.Join(pluginPath, pluginArchiveName)
+	err = b.ioUtils.CopyFile(archivePath, pluginArchivePath)
+	if err != nil {
+		return "", err
+	}
+
+	return pluginArchivePath, nil
+}
+
+// pluginDir returns the directory extensions of plugin are installed to, creating the
+// sidecar directory of remote plugins
+func (b *Broker) pluginDir(plugin *model.CachedPlugin) (string, error) {
 	pluginPath := "/plugins"
 
 	if plugin.IsRemote {
@@ -81,19 +228,12 @@ func (b *Broker) injectPlugin(plugin *model.CachedPlugin, archivePath string) (s
 			return "", err
 		}
 	}
-	pluginArchiveName := b.generatePluginArchiveName(plugin, archivePath)
-	pluginArchivePath := filepath.Join(pluginPath, pluginArchiveName)
-	err := b.ioUtils.CopyFile(archivePath, pluginArchivePath)
-	if err != nil {
-		return "", err
-	}
-
-	return pluginArchivePath, nil
+	return pluginPath, nil
 }
 
-func (b *Broker) flushLog(bufferRef *[]string) []string {
+func (b *Broker) flushLog(pluginID string, bufferRef *[]string) []string {
 	buffer := *bufferRef
-	b.PrintInfoBuffer(buffer)
+	b.PrintPluginInfoBuffer(pluginID, buffer)
 	return buffer[:0]
 }
 
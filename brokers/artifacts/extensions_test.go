@@ -13,10 +13,12 @@
 package artifacts
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -31,17 +33,17 @@ func TestProcessPluginDoesNothingWhenNoExtensions(t *testing.T) {
 		CachedExtensions: map[string]string{},
 	}
 
-	output := m.broker.ProcessPlugin(&plugin)
+	output := m.broker.ProcessPlugin(context.Background(), &plugin)
 
 	assert.Nil(t, output)
-	m.ioUtils.AssertNotCalled(t, "Download", mock.Anything, mock.Anything, mock.Anything)
+	m.ioUtils.AssertNotCalled(t, "Download", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestProcessPluginSuccessfulCase(t *testing.T) {
 	m := initMocks()
 	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
 	m.ioUtils.On("ResolveDestPathFromURL", "testUrl", "testDir").Return("testDestPath")
-	m.ioUtils.On("Download", "testUrl", "testDestPath", mock.AnythingOfType("bool")).Return("testArchivePath", nil)
+	m.ioUtils.On("Download", mock.Anything, "testUrl", "testDestPath", mock.AnythingOfType("bool"), mock.Anything).Return("testArchivePath", nil)
 	m.ioUtils.On("MkDir", mock.AnythingOfType("string")).Return(nil)
 	m.ioUtils.On("CopyFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 	m.rand.On("String", mock.AnythingOfType("int")).Return("randstr")
@@ -52,18 +54,21 @@ func TestProcessPluginSuccessfulCase(t *testing.T) {
 		CachedExtensions: map[string]string{
 			"testUrl": "",
 		},
+		ProgressIDs: []string{"testPlugin/latest"},
 	}
 
-	err := m.broker.ProcessPlugin(&plugin)
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
 
 	assert.Nil(t, err)
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "testPlugin/latest", model.PhaseInstalling, int64(0), int64(0))
+	m.commonBroker.AssertNotCalled(t, "PubPluginProgress", "testPlugin/latest", model.PhaseDownloading, int64(0), int64(-1))
 }
 
 func TestProcessPluginHandlesPartiallyCachedPlugin(t *testing.T) {
 	m := initMocks()
 	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
 	m.ioUtils.On("ResolveDestPathFromURL", "testUrl", "testDir").Return("testDestPath")
-	m.ioUtils.On("Download", "testUrl", "testDestPath", mock.AnythingOfType("bool")).Return("testArchivePath", nil)
+	m.ioUtils.On("Download", mock.Anything, "testUrl", "testDestPath", mock.AnythingOfType("bool"), mock.Anything).Return("testArchivePath", nil)
 	m.ioUtils.On("MkDir", mock.AnythingOfType("string")).Return(nil)
 	m.ioUtils.On("CopyFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 	m.rand.On("String", mock.AnythingOfType("int")).Return("randstr")
@@ -77,17 +82,17 @@ func TestProcessPluginHandlesPartiallyCachedPlugin(t *testing.T) {
 		},
 	}
 
-	err := m.broker.ProcessPlugin(&plugin)
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
 
 	assert.Nil(t, err)
-	m.ioUtils.AssertNotCalled(t, "Download", "alreadyCached", mock.Anything, mock.Anything)
+	m.ioUtils.AssertNotCalled(t, "Download", "alreadyCached", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestProcessPluginIgnoresCachedPlugins(t *testing.T) {
 	m := initMocks()
 	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
 	m.ioUtils.On("ResolveDestPathFromURL", "testUrl", "testDir").Return("testDestPath")
-	m.ioUtils.On("Download", "testUrl", "testDestPath", mock.AnythingOfType("bool")).Return("testArchivePath", nil)
+	m.ioUtils.On("Download", mock.Anything, "testUrl", "testDestPath", mock.AnythingOfType("bool"), mock.Anything).Return("testArchivePath", nil)
 	m.ioUtils.On("MkDir", mock.AnythingOfType("string")).Return(nil)
 	m.ioUtils.On("CopyFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 	m.rand.On("String", mock.AnythingOfType("int")).Return("randstr")
@@ -100,10 +105,10 @@ func TestProcessPluginIgnoresCachedPlugins(t *testing.T) {
 		},
 	}
 
-	err := m.broker.ProcessPlugin(&plugin)
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
 
 	assert.Nil(t, err)
-	m.ioUtils.AssertNotCalled(t, "Download", mock.Anything, mock.Anything, mock.Anything)
+	m.ioUtils.AssertNotCalled(t, "Download", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	m.ioUtils.AssertNotCalled(t, "MkDir", mock.Anything)
 	m.ioUtils.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
 }
@@ -114,7 +119,7 @@ func TestProcessPluginFailureOnDownload(t *testing.T) {
 	m := initMocks()
 	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
 	m.ioUtils.On("ResolveDestPathFromURL", "testUrl", "testDir").Return("testDestPath")
-	m.ioUtils.On("Download", "testUrl", "testDestPath", mock.AnythingOfType("bool")).Return("", testError)
+	m.ioUtils.On("Download", mock.Anything, "testUrl", "testDestPath", mock.AnythingOfType("bool"), mock.Anything).Return("", testError)
 
 	plugin := model.CachedPlugin{
 		ID:       "testPlugin",
@@ -124,10 +129,16 @@ func TestProcessPluginFailureOnDownload(t *testing.T) {
 		},
 	}
 
-	err := m.broker.ProcessPlugin(&plugin)
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
 
 	assert.NotNil(t, err)
 	assert.EqualError(t, err, "failed to download plugin from testUrl: test error")
+	assert.Equal(t, &model.ErrorDetails{
+		Code:      model.ErrorCodeDownloadFailed,
+		PluginID:  "testPlugin",
+		URL:       "testUrl",
+		Retryable: true,
+	}, utils.GetErrorDetails(err))
 }
 
 func TestProcessPluginFailureOnMkdir(t *testing.T) {
@@ -136,7 +147,7 @@ func TestProcessPluginFailureOnMkdir(t *testing.T) {
 	m := initMocks()
 	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
 	m.ioUtils.On("ResolveDestPathFromURL", "testUrl", "testDir").Return("testDestPath")
-	m.ioUtils.On("Download", "testUrl", "testDestPath", mock.AnythingOfType("bool")).Return("testArchivePath", nil)
+	m.ioUtils.On("Download", mock.Anything, "testUrl", "testDestPath", mock.AnythingOfType("bool"), mock.Anything).Return("testArchivePath", nil)
 	m.ioUtils.On("MkDir", mock.AnythingOfType("string")).Return(testError)
 
 	plugin := model.CachedPlugin{
@@ -147,7 +158,7 @@ func TestProcessPluginFailureOnMkdir(t *testing.T) {
 		},
 	}
 
-	err := m.broker.ProcessPlugin(&plugin)
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
 
 	assert.NotNil(t, err)
 	assert.EqualError(t, err, "test error")
@@ -159,7 +170,7 @@ func TestProcessPluginFailureOnCopyFile(t *testing.T) {
 	m := initMocks()
 	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
 	m.ioUtils.On("ResolveDestPathFromURL", "testUrl", "testDir").Return("testDestPath")
-	m.ioUtils.On("Download", "testUrl", "testDestPath", mock.AnythingOfType("bool")).Return("testArchivePath", nil)
+	m.ioUtils.On("Download", mock.Anything, "testUrl", "testDestPath", mock.AnythingOfType("bool"), mock.Anything).Return("testArchivePath", nil)
 	m.ioUtils.On("MkDir", mock.AnythingOfType("string")).Return(nil)
 	m.rand.On("String", mock.AnythingOfType("int")).Return("randstr")
 	m.ioUtils.On("CopyFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(testError)
@@ -172,8 +183,150 @@ func TestProcessPluginFailureOnCopyFile(t *testing.T) {
 		},
 	}
 
-	err := m.broker.ProcessPlugin(&plugin)
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
 
 	assert.NotNil(t, err)
 	assert.EqualError(t, err, "test error")
 }
+
+func TestProcessPluginSharesExtensionBetweenLocalPlugins(t *testing.T) {
+	m := initMocks()
+	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
+	m.broker.sharedExtensions = map[string]installedExtension{
+		"testUrl": {pluginID: "otherPlugin", path: "/plugins/otherPlugin.randstr.ext.vsix", archiveName: "ext.vsix", signature: model.SignatureVerified},
+	}
+
+	plugin := model.CachedPlugin{
+		ID: "testPlugin",
+		CachedExtensions: map[string]string{
+			"testUrl": "",
+		},
+	}
+
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/plugins/otherPlugin.randstr.ext.vsix", plugin.CachedExtensions["testUrl"])
+	assert.Equal(t, model.SignatureVerified, plugin.ExtensionSignatures["testUrl"])
+	m.ioUtils.AssertNotCalled(t, "Download", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	m.ioUtils.AssertNotCalled(t, "LinkFile", mock.Anything, mock.Anything)
+	m.ioUtils.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+}
+
+func TestProcessPluginLinksSharedExtensionOfRemotePlugin(t *testing.T) {
+	m := initMocks()
+	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
+	m.ioUtils.On("MkDir", mock.AnythingOfType("string")).Return(nil)
+	m.ioUtils.On("LinkFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+	m.rand.On("String", mock.AnythingOfType("int")).Return("randstr")
+	m.broker.sharedExtensions = map[string]installedExtension{
+		"testUrl": {pluginID: "otherPlugin", path: "/plugins/otherPlugin.other.ext.vsix", archiveName: "ext.vsix"},
+	}
+
+	plugin := model.CachedPlugin{
+		ID:       "test/plugin",
+		IsRemote: true,
+		CachedExtensions: map[string]string{
+			"testUrl": "",
+		},
+	}
+
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
+
+	assert.Nil(t, err)
+	expectedPath := "/plugins/sidecars/test_plugin/test.plugin.randstr.ext.vsix"
+	assert.Equal(t, expectedPath, plugin.CachedExtensions["testUrl"])
+	assert.Nil(t, plugin.ExtensionSignatures)
+	m.ioUtils.AssertCalled(t, "LinkFile", "/plugins/otherPlugin.other.ext.vsix", expectedPath)
+	m.ioUtils.AssertNotCalled(t, "Download", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	m.ioUtils.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+}
+
+func TestProcessPluginCopiesSharedExtensionWhenLinkFails(t *testing.T) {
+	m := initMocks()
+	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
+	m.ioUtils.On("MkDir", mock.AnythingOfType("string")).Return(nil)
+	m.ioUtils.On("LinkFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(fmt.Errorf("test error"))
+	m.ioUtils.On("CopyFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+	m.rand.On("String", mock.AnythingOfType("int")).Return("randstr")
+	m.broker.sharedExtensions = map[string]installedExtension{
+		"testUrl": {pluginID: "otherPlugin", isRemote: true, path: "/plugins/sidecars/other/other.ext.vsix", archiveName: "ext.vsix"},
+	}
+
+	plugin := model.CachedPlugin{
+		ID: "testPlugin",
+		CachedExtensions: map[string]string{
+			"testUrl": "",
+		},
+	}
+
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "/plugins/testPlugin.randstr.ext.vsix", plugin.CachedExtensions["testUrl"])
+	m.ioUtils.AssertCalled(t, "CopyFile", "/plugins/sidecars/other/other.ext.vsix", "/plugins/testPlugin.randstr.ext.vsix")
+}
+
+func TestProcessPluginRecordsDownloadedExtensionsForSharing(t *testing.T) {
+	m := initMocks()
+	m.ioUtils.On("TempDir", mock.Anything, mock.Anything).Return("testDir", nil)
+	m.ioUtils.On("ResolveDestPathFromURL", "testUrl", "testDir").Return("testDestPath")
+	m.ioUtils.On("Download", mock.Anything, "testUrl", "testDestPath", mock.AnythingOfType("bool"), mock.Anything).Return("testDir/ext.vsix", nil)
+	m.ioUtils.On("CopyFile", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+	m.rand.On("String", mock.AnythingOfType("int")).Return("randstr")
+	m.broker.sharedExtensions = map[string]installedExtension{}
+
+	plugin := model.CachedPlugin{
+		ID: "testPlugin",
+		CachedExtensions: map[string]string{
+			"testUrl": "",
+		},
+	}
+
+	err := m.broker.ProcessPlugin(context.Background(), &plugin)
+
+	assert.Nil(t, err)
+	assert.Equal(t, installedExtension{
+		pluginID:    "testPlugin",
+		path:        "/plugins/testPlugin.randstr.ext.vsix",
+		archiveName: "ext.vsix",
+	}, m.broker.sharedExtensions["testUrl"])
+}
+
+func TestCollectInstalledExtensions(t *testing.T) {
+	plugins := []model.CachedPlugin{
+		{
+			ID: "test/first",
+			CachedExtensions: map[string]string{
+				"sharedUrl":   "/plugins/test.first.randstr.ext.vsix",
+				"notYetThere": "",
+			},
+			ExtensionSignatures: map[string]model.SignatureStatus{
+				"sharedUrl": model.SignatureVerified,
+			},
+		},
+		{
+			ID:       "test/second",
+			IsRemote: true,
+			CachedExtensions: map[string]string{
+				"sharedUrl": "/plugins/sidecars/test_second/test.second.randstr.ext.vsix",
+			},
+		},
+	}
+
+	installed := collectInstalledExtensions(plugins)
+
+	assert.Equal(t, map[string]installedExtension{
+		"sharedUrl": {
+			pluginID:    "test/first",
+			path:        "/plugins/test.first.randstr.ext.vsix",
+			archiveName: "ext.vsix",
+			signature:   model.SignatureVerified,
+		},
+	}, installed)
+}
+
+func TestInstalledArchiveName(t *testing.T) {
+	assert.Equal(t, "ext.vsix", installedArchiveName("test/plugin", "/plugins/test.plugin.randstr.ext.vsix"))
+	assert.Equal(t, "other.vsix", installedArchiveName("test/plugin", "/plugins/other.vsix"))
+}
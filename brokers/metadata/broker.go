@@ -13,13 +13,12 @@
 package metadata
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"github.com/eclipse/che-plugin-broker/cfg"
 
-	"github.com/eclipse/che-plugin-broker/utils/mergeplugins"
-
-	jsonrpc "github.com/eclipse/che-go-jsonrpc"
 	"github.com/eclipse/che-plugin-broker/common"
 	"github.com/eclipse/che-plugin-broker/model"
 	"github.com/eclipse/che-plugin-broker/utils"
@@ -35,41 +34,62 @@ type Broker struct {
 	ioUtils          utils.IoUtil
 	rand             common.Random
 	localhostSidecar bool
+	metrics          *utils.Metrics
 }
 
 // NewBroker creates Che broker instance
-func NewBroker(localhostSidecar bool) *Broker {
+func NewBroker(localhostSidecar bool, credentials utils.Credentials) *Broker {
+	metrics := utils.NewMetrics("metadata", common.Redact)
+	metrics.SetWorkspace(cfg.RuntimeID.Workspace)
 	return &Broker{
 		Broker:           common.NewBroker(),
-		ioUtils:          utils.New(),
+		ioUtils:          utils.NewTimedIoUtil(utils.New(cfg.RequestTimeout, credentials, cfg.BundleDir), metrics),
 		rand:             common.NewRand(),
 		localhostSidecar: localhostSidecar,
+		metrics:          metrics,
 	}
 }
 
-func (b *Broker) fail(err error) error {
-	b.PubFailed(err.Error())
-	b.PubLog(err.Error())
-	return err
-}
-
-// PushEvents sets given tunnel as consumer of broker events.
-func (b *Broker) PushEvents(tun *jsonrpc.Tunnel) {
-	b.Broker.PushEvents(tun, model.BrokerStatusEventType, model.BrokerResultEventType, model.BrokerLogEventType)
+// PushEvents sets given sink as consumer of broker events.
+func (b *Broker) PushEvents(sink common.EventSink) {
+	b.Broker.PushEvents(sink, model.BrokerStatusEventType, model.BrokerResultEventType, model.BrokerLogEventType, model.BrokerPluginProgressEventType)
 }
 
-// Start the plugin brokering process for given plugin FQNs. Default registry is required
-// only if not all plugins specify a registry.
-func (b *Broker) Start(pluginFQNs []model.PluginFQN, defaultRegistry string) error {
+// Start the plugin brokering process for given plugin FQNs. Default registries are required
+// only if not all plugins specify a registry; they are tried in order.
+func (b *Broker) Start(ctx context.Context, pluginFQNs []model.PluginFQN, defaultRegistries []string) error {
 	defer b.CloseConsumers()
 	b.PubStarted()
 	b.PrintInfo("Starting plugin metadata broker")
+	ioUtils, err := common.ConfigureSignatureVerification(b.ioUtils)
+	if err != nil {
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
+	}
+	b.ioUtils = ioUtils
 
-	pluginMetas, err := utils.GetPluginMetas(pluginFQNs, defaultRegistry, b.ioUtils)
+	pluginMetas, err := common.ResolvePluginMetas(ctx, b.Broker, b.ioUtils, pluginFQNs, defaultRegistries)
 	if err != nil {
-		return b.fail(fmt.Errorf("Failed to download plugin meta: %s", err))
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, fmt.Errorf("Failed to download plugin meta: %w", err))
 	}
+	b.metrics.SetPluginsResolved(len(pluginMetas))
+	b.RegisterSecrets(pluginMetas)
 	b.PrintPlan(pluginMetas)
+	b.warnUnverifiedSignatures(pluginMetas)
+	common.WarnDependencies(b.Broker, pluginMetas)
+
+	if err := common.CheckPolicy(b.ioUtils, pluginMetas); err != nil {
+		for _, meta := range pluginMetas {
+			b.PubPluginProgress(meta.ProgressID, model.PhaseFailed, 0, 0)
+		}
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
+	}
+
+	if err := b.checkConflicts(pluginMetas); err != nil {
+		for _, meta := range pluginMetas {
+			b.PubPluginProgress(meta.ProgressID, model.PhaseFailed, 0, 0)
+		}
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
+	}
 
 	if collisions := utils.GetExtensionCollisions(pluginMetas); len(collisions) > 0 {
 		collisionLog := []string{"WARNING: multiple instances of the same extension will be included in this workspace:"}
@@ -79,43 +99,94 @@ func (b *Broker) Start(pluginFQNs []model.PluginFQN, defaultRegistry string) err
 	}
 
 	// Process plugins into ChePlugins
+	for _, meta := range pluginMetas {
+		b.PubPluginProgress(meta.ProgressID, model.PhaseValidating, 0, 0)
+	}
 	plugins, err := b.ProcessPlugins(pluginMetas)
 	if err != nil {
-		return b.fail(err)
+		for _, meta := range pluginMetas {
+			b.PubPluginProgress(meta.ProgressID, model.PhaseFailed, 0, 0)
+		}
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
 	}
 
 	// Serialize ChePlugins and return to Che server
 	result, err := b.serializeTooling(plugins)
 	if err != nil {
-		return b.fail(err)
+		return common.Fail(ctx, b.Broker, b.ioUtils, b.metrics, err)
 	}
 
+	for _, meta := range pluginMetas {
+		b.PubPluginProgress(meta.ProgressID, model.PhaseDone, 0, 0)
+	}
 	b.PrintInfo("All plugin metadata has been successfully processed")
 	b.PrintDebug(result)
+	common.ReportMetrics(b.Broker, b.ioUtils, b.metrics, model.StatusDone)
 	b.PubDone(result)
 	return nil
 }
 
+// warnUnverifiedSignatures reports plugins whose meta.yaml is not signed by a trusted key.
+// With enforced signature policy, such plugins fail earlier.
+func (b *Broker) warnUnverifiedSignatures(metas []model.PluginMeta) {
+	var unverified []string
+	for _, meta := range metas {
+		if meta.Signature != "" && meta.Signature != model.SignatureVerified {
+			unverified = append(unverified, fmt.Sprintf("%s (%s)", meta.ID, meta.Signature))
+		}
+	}
+	if len(unverified) > 0 {
+		b.PrintInfo("WARN: Plugins without valid signature: %s", strings.Join(unverified, ", "))
+	}
+}
+
+// checkConflicts reports conflicts between plugins of metas according to the configured
+// severities and fails if any of them has error severity
+func (b *Broker) checkConflicts(metas []model.PluginMeta) error {
+	severities, err := ParseConflictSeverities(cfg.ConflictSeverity)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	var warnings []string
+	for _, conflict := range FindConflicts(metas) {
+		switch severities[conflict.Kind] {
+		case SeverityError:
+			errs = append(errs, conflict.String())
+		case SeverityWarn:
+			warnings = append(warnings, fmt.Sprintf("  %s conflict: %s", conflict.Kind, conflict))
+		}
+	}
+	if len(warnings) > 0 {
+		b.PrintInfoBuffer(append([]string{"WARNING: plugins of this workspace conflict:"}, warnings...))
+	}
+	if len(errs) > 0 {
+		return utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeWorkspaceConflict},
+			"plugins of the workspace conflict: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 // ProcessPlugins converts a list of Plugin Metas into Che Plugins to be understood
 // by the Che server. Additionally, ProcessPlugins performs minimal validation.
 // See also: ProcessPlugin
 func (b *Broker) ProcessPlugins(metas []model.PluginMeta) ([]model.ChePlugin, error) {
+	validated := b.metrics.StartPhase(utils.PhaseValidation, "")
 	err := utils.ValidateMetas(metas...)
+	validated(0)
 	if err != nil {
 		return nil, err
 	}
 
 	remoteInjection, err := GetRuntimeInjection(metas)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get remote runtime injection: %s", err)
+		return nil, fmt.Errorf("failed to get remote runtime injection: %w", err)
 	}
 
 	plugins := make([]model.ChePlugin, 0)
-	metasToProcess := metas
-	if cfg.MergePlugins{
-		var logs []string
-		metasToProcess, logs = mergeplugins.MergePlugins(metas)
-		b.PrintInfoBuffer(logs)
+	metasToProcess, err := common.MergePlugins(b.Broker, b.ioUtils, b.metrics, metas)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, meta := range metasToProcess {
@@ -132,7 +203,11 @@ func (b *Broker) ProcessPlugins(metas []model.PluginMeta) ([]model.ChePlugin, er
 // runtime (see: GetRuntimeInjection)
 func (b *Broker) ProcessPlugin(meta model.PluginMeta, remoteInjection *RemotePluginInjection) model.ChePlugin {
 	if utils.IsTheiaOrVscodePlugin(meta) && len(meta.Spec.Containers) > 0 {
-		AddPluginRunnerRequirements(meta, b.rand, b.localhostSidecar)
+		withRequirements := AddPluginRunnerRequirements(meta, b.rand, b.localhostSidecar)
+		if len(meta.MergedPlugins) > 0 {
+			// Endpoint and workspace env of the sidecar are needed by every merged plugin
+			meta = withRequirements
+		}
 		InjectRemoteRuntime(&meta, remoteInjection)
 	}
 
@@ -152,6 +227,7 @@ func ConvertMetaToPlugin(meta model.PluginMeta) model.ChePlugin {
 		Endpoints:      meta.Spec.Endpoints,
 		WorkspaceEnv:   meta.Spec.WorkspaceEnv,
 		Type:           meta.Type,
+		MergedPlugins:  meta.MergedPlugins,
 	}
 }
 
@@ -13,14 +13,18 @@
 package metadata
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"regexp"
 	"testing"
 
+	"github.com/eclipse/che-plugin-broker/cfg"
 	commonMock "github.com/eclipse/che-plugin-broker/common/mocks"
 	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
 	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -40,12 +44,15 @@ func initMocks() *mocks {
 	rand := &commonMock.Random{}
 
 	commonBroker.On("PrintInfo", mock.AnythingOfType("string"))
+	commonBroker.On("PrintInfo", mock.AnythingOfType("string"), mock.Anything)
 	commonBroker.On("PrintInfoBuffer", mock.Anything)
 	commonBroker.On("PrintDebug", mock.AnythingOfType("string"))
-	commonBroker.On("PubFailed", mock.AnythingOfType("string"))
+	commonBroker.On("PubFailed", mock.AnythingOfType("string"), mock.Anything)
 	commonBroker.On("PubLog", mock.AnythingOfType("string"))
+	commonBroker.On("PubPluginProgress", mock.AnythingOfType("string"), mock.Anything, mock.Anything, mock.Anything)
 	commonBroker.On("PubStarted")
 	commonBroker.On("PrintPlan", mock.AnythingOfType("[]model.PluginMeta"))
+	commonBroker.On("RegisterSecrets", mock.AnythingOfType("[]model.PluginMeta"))
 	commonBroker.On("CloseConsumers")
 	commonBroker.On("PubDone", mock.AnythingOfType("string"))
 
@@ -58,32 +65,84 @@ func initMocks() *mocks {
 			ioUtils:          ioUtils,
 			rand:             rand,
 			localhostSidecar: false,
+			metrics:          utils.NewMetrics("metadata", nil),
 		},
 	}
 }
 
 func TestBroker_StartPublishesErrorOnFetchError(t *testing.T) {
 	m := initMocks()
-	m.ioUtils.On("Fetch", mock.AnythingOfType("string")).Return(nil, errors.New("Test error"))
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).Return(nil, errors.New("Test error"))
 
-	err := m.broker.Start([]model.PluginFQN{pluginFQNWithoutRegistry}, "http://defaultRegistry.com")
+	err := m.broker.Start(context.Background(), []model.PluginFQN{pluginFQNWithoutRegistry}, []string{"http://defaultRegistry.com"})
 
 	expectedMessage := "Failed to download plugin meta: failed to fetch plugin meta.yaml from URL 'http://defaultRegistry.com/plugins/test-no-registry/1.0/meta.yaml': Test error"
 	assert.EqualError(t, err, expectedMessage)
-	m.commonBroker.AssertCalled(t, "PubFailed", expectedMessage)
+	m.commonBroker.AssertCalled(t, "PubFailed", expectedMessage, &model.ErrorDetails{
+		Code:      model.ErrorCodeRegistryUnreachable,
+		PluginID:  "test-no-registry/1.0",
+		URL:       "http://defaultRegistry.com/plugins/test-no-registry/1.0/meta.yaml",
+		Retryable: true,
+	})
 	m.commonBroker.AssertCalled(t, "PubLog", expectedMessage)
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "test-no-registry/1.0", model.PhaseFailed, int64(0), int64(0))
+	m.commonBroker.AssertNotCalled(t, "PubDone", mock.AnythingOfType("string"))
+}
+
+func TestBroker_StartPublishesPolicyViolation(t *testing.T) {
+	cfg.PolicyFilePath = "/policy.yaml"
+	defer func() { cfg.PolicyFilePath = "" }()
+	m := initMocks()
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).Return([]byte("apiVersion: v2\npublisher: other"), nil)
+	m.ioUtils.On("ReadFile", "/policy.yaml").Return([]byte("publishers:\n  allow: [redhat, eclipse]"), nil)
+
+	err := m.broker.Start(context.Background(), []model.PluginFQN{pluginFQNWithoutRegistry}, []string{"http://defaultRegistry.com"})
+
+	expectedMessage := "plugin 'test-no-registry/1.0' is not allowed by policy: publisher 'other' does not match any pattern of rule publishers.allow"
+	assert.EqualError(t, err, expectedMessage)
+	m.commonBroker.AssertCalled(t, "PubFailed", expectedMessage, &model.ErrorDetails{
+		Code:     model.ErrorCodePolicyViolation,
+		PluginID: "test-no-registry/1.0",
+		Rule:     "publishers.allow",
+	})
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "test-no-registry/1.0", model.PhaseFailed, int64(0), int64(0))
+	m.commonBroker.AssertNotCalled(t, "PubDone", mock.AnythingOfType("string"))
+}
+
+func TestBroker_StartPublishesWorkspaceConflicts(t *testing.T) {
+	cfg.ConflictSeverity = "port=error,endpoint=ignore"
+	defer func() { cfg.ConflictSeverity = "" }()
+	m := initMocks()
+	pluginMeta := "apiVersion: v2\nspec:\n  endpoints: [{name: theia}]\n  workspaceEnv: [{name: THEIA_PORT, value: \"%s\"}]\n" +
+		"  containers: [{ports: [{exposedPort: 3100}]}]"
+	m.ioUtils.On("Fetch", mock.Anything, "http://defaultRegistry.com/plugins/test-no-registry/1.0/meta.yaml").Return([]byte(fmt.Sprintf(pluginMeta, "3100")), nil)
+	m.ioUtils.On("Fetch", mock.Anything, "http://defaultRegistry.com/plugins/test-no-registry/2.0/meta.yaml").Return([]byte(fmt.Sprintf(pluginMeta, "3130")), nil)
+
+	err := m.broker.Start(context.Background(), []model.PluginFQN{pluginFQNWithoutRegistry, {ID: "test-no-registry/2.0"}}, []string{"http://defaultRegistry.com"})
+
+	expectedMessage := "plugins of the workspace conflict: port 3100 is exposed by plugins test-no-registry/1.0, test-no-registry/2.0"
+	assert.EqualError(t, err, expectedMessage)
+	m.commonBroker.AssertCalled(t, "PubFailed", expectedMessage, &model.ErrorDetails{Code: model.ErrorCodeWorkspaceConflict})
+	m.commonBroker.AssertCalled(t, "PrintInfoBuffer", []string{
+		"WARNING: plugins of this workspace conflict:",
+		"  workspace-env conflict: workspace env var THEIA_PORT has different values in plugins test-no-registry/1.0, test-no-registry/2.0",
+	})
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "test-no-registry/2.0", model.PhaseFailed, int64(0), int64(0))
 	m.commonBroker.AssertNotCalled(t, "PubDone", mock.AnythingOfType("string"))
 }
 
 func TestBroker_StartPublishesErrorOnProcessError(t *testing.T) {
 	m := initMocks()
-	m.ioUtils.On("Fetch", mock.AnythingOfType("string")).Return([]byte(""), nil)
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).Return([]byte(""), nil)
 
-	err := m.broker.Start([]model.PluginFQN{pluginFQNWithoutRegistry}, "http://defaultRegistry.com")
+	err := m.broker.Start(context.Background(), []model.PluginFQN{pluginFQNWithoutRegistry}, []string{"http://defaultRegistry.com"})
 
 	expectedMessage := "Plugin 'test-no-registry/1.0' is invalid. Field 'apiVersion' must be present"
 	assert.EqualError(t, err, expectedMessage)
-	m.commonBroker.AssertCalled(t, "PubFailed", expectedMessage)
+	m.commonBroker.AssertCalled(t, "PubFailed", expectedMessage, &model.ErrorDetails{
+		Code:     model.ErrorCodeInvalidMeta,
+		PluginID: "test-no-registry/1.0",
+	})
 	m.commonBroker.AssertCalled(t, "PubLog", expectedMessage)
 	m.commonBroker.AssertNotCalled(t, "PubDone", mock.AnythingOfType("string"))
 	m.commonBroker.AssertCalled(t, "CloseConsumers")
@@ -99,17 +158,63 @@ spec:
       image: "docker.io/eclipse/che-machine-exec:next"
 `
 	m := initMocks()
-	m.ioUtils.On("Fetch", mock.AnythingOfType("string")).Return([]byte(pluginMetaContent), nil)
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).Return([]byte(pluginMetaContent), nil)
 
-	err := m.broker.Start([]model.PluginFQN{pluginFQNWithoutRegistry}, "http://defaultRegistry.com")
+	err := m.broker.Start(context.Background(), []model.PluginFQN{pluginFQNWithoutRegistry}, []string{"http://defaultRegistry.com"})
 
 	assert.Nil(t, err)
-	m.commonBroker.AssertNotCalled(t, "PubFailed", mock.AnythingOfType("string"))
+	m.commonBroker.AssertNotCalled(t, "PubFailed", mock.AnythingOfType("string"), mock.Anything)
 	m.commonBroker.AssertNotCalled(t, "PubLog", mock.AnythingOfType("string"))
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "test-no-registry/1.0", model.PhaseResolving, int64(0), int64(0))
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "test-no-registry/1.0", model.PhaseValidating, int64(0), int64(0))
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "test-no-registry/1.0", model.PhaseDone, int64(0), int64(0))
 	m.commonBroker.AssertCalled(t, "PubDone", mock.AnythingOfType("string"))
 	m.commonBroker.AssertCalled(t, "CloseConsumers")
 }
 
+func TestBroker_StartReportsDependencyCycles(t *testing.T) {
+	pluginMetaContent := `
+type: Che Plugin
+apiVersion: v2
+dependencies:
+  - test-no-registry/1.0
+spec:
+  containers:
+    - name: che-machine-exec
+      image: "docker.io/eclipse/che-machine-exec:next"
+`
+	m := initMocks()
+	m.ioUtils.On("Fetch", mock.Anything, mock.AnythingOfType("string")).Return([]byte(pluginMetaContent), nil)
+
+	err := m.broker.Start(context.Background(), []model.PluginFQN{pluginFQNWithoutRegistry}, []string{"http://defaultRegistry.com"})
+
+	assert.Nil(t, err)
+	m.commonBroker.AssertCalled(t, "PrintInfo", "WARN: %s",
+		"Plugin dependency cycle detected: test-no-registry/1.0 -> test-no-registry/1.0")
+}
+
+func TestBroker_StartPublishesProgressOfDependencies(t *testing.T) {
+	pluginMetaContent := `
+type: Che Plugin
+apiVersion: v2
+spec:
+  containers:
+    - name: che-machine-exec
+      image: "docker.io/eclipse/che-machine-exec:next"
+`
+	m := initMocks()
+	m.ioUtils.On("Fetch", mock.Anything, "http://defaultRegistry.com/plugins/test-no-registry/1.0/meta.yaml").
+		Return([]byte(pluginMetaContent+"dependencies:\n  - pub/dependency/1.0\n"), nil)
+	m.ioUtils.On("Fetch", mock.Anything, "http://defaultRegistry.com/plugins/pub/dependency/1.0/meta.yaml").
+		Return([]byte(pluginMetaContent), nil)
+
+	err := m.broker.Start(context.Background(), []model.PluginFQN{pluginFQNWithoutRegistry}, []string{"http://defaultRegistry.com"})
+
+	assert.Nil(t, err)
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "pub/dependency/1.0", model.PhaseResolving, int64(0), int64(0))
+	m.commonBroker.AssertCalled(t, "PubPluginProgress", "pub/dependency/1.0", model.PhaseDone, int64(0), int64(0))
+}
+
 func TestBroker_ProcessPluginsValidatesPlugins(t *testing.T) {
 	// Plugin should always fail to validate
 	metas := []model.PluginMeta{
@@ -171,6 +276,66 @@ func TestBroker_ProcessPluginAddsPluginRunnerRequirementsForVsCodePlugin(t *test
 	}, "AddPlugin should add plugin runner requirements for plugin runner")
 }
 
+// TestBroker_ProcessPluginDoesNotChangeEndpointsOfUnmergedPlugins ensures that only merged
+// plugins get the sidecar endpoint and workspace env in the metadata output
+func TestBroker_ProcessPluginDoesNotChangeEndpointsOfUnmergedPlugins(t *testing.T) {
+	javaMeta := loadPluginMetaFromFile(t, "vscode-java-0.50.0.yaml")
+
+	m := initMocks()
+	m.rand.On("IntFromRange", 4000, 10000).Return(4242)
+	m.rand.On("String", 10).Return("randomString")
+
+	plugin := m.broker.ProcessPlugin(*javaMeta, nil)
+
+	assert.Empty(t, plugin.Endpoints)
+	assert.Empty(t, plugin.WorkspaceEnv)
+	assert.Contains(t, plugin.Containers[0].Ports, model.ExposedPort{ExposedPort: 4242})
+}
+
+func TestBroker_ProcessPluginsKeepsIdentitiesOfMergedPlugins(t *testing.T) {
+	defer func(mergePlugins bool) { cfg.MergePlugins = mergePlugins }(cfg.MergePlugins)
+	cfg.MergePlugins = true
+	sidecarPlugin := func(name string, version string) model.PluginMeta {
+		return model.PluginMeta{
+			APIVersion: "v2",
+			ID:         "redhat/" + name + "/" + version,
+			Publisher:  "redhat",
+			Name:       name,
+			Version:    version,
+			Type:       model.VscodePluginType,
+			Spec: model.PluginMetaSpec{
+				Containers: []model.Container{{Name: name, Image: "quay.io/eclipse/che-sidecar-java:8"}},
+				Extensions: []string{"https://extensions.io/" + name + ".vsix"},
+			},
+		}
+	}
+	metas := []model.PluginMeta{sidecarPlugin("java", "0.63.0"), sidecarPlugin("quarkus-java8", "1.5.0")}
+
+	m := initMocks()
+	m.rand.On("IntFromRange", 4000, 10000).Return(4242)
+	m.rand.On("String", 10).Return("randomString")
+
+	plugins, err := m.broker.ProcessPlugins(metas)
+
+	assert.NoError(t, err)
+	if assert.Len(t, plugins, 1) {
+		plugin := plugins[0]
+		assert.Equal(t, "redhat/java/0.63.0", plugin.ID)
+		assert.Equal(t, []model.MergedPlugin{
+			{ID: "redhat/java/0.63.0", Version: "0.63.0"},
+			{ID: "redhat/quarkus-java8/1.5.0", Version: "1.5.0"},
+		}, plugin.MergedPlugins)
+		// The artifacts broker installs extensions of all merged plugins to the sidecar directory of the merged plugin
+		uniqueName := utils.ConvertIDToUniqueName(plugin.ID)
+		assert.Equal(t, []model.EnvVar{
+			{Name: theiaEndpointPortEnvVar, Value: "4242"},
+			{Name: theiaPluginsEnvVar, Value: pluginsPathBase + uniqueName},
+		}, plugin.Containers[0].Env)
+		assert.Equal(t, []model.Endpoint{{Name: "randomString", TargetPort: 4242}}, plugin.Endpoints)
+		assert.Equal(t, []model.EnvVar{{Name: remoteEndpointBase + uniqueName, Value: "ws://randomString:4242"}}, plugin.WorkspaceEnv)
+	}
+}
+
 // TestBroker_ProcessPluginDoesNotAddRunnerRequirementsForChePluginType is a high-level
 // test to ensure broker does not add sidecar plugin runner requirements for Che plugins.
 // Full testing of plugin runner provisioning is in corresponding file.
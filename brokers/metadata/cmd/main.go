@@ -20,31 +20,56 @@ import (
 	"github.com/eclipse/che-plugin-broker/brokers/metadata"
 	"github.com/eclipse/che-plugin-broker/cfg"
 	"github.com/eclipse/che-plugin-broker/common"
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
 )
 
 func main() {
 	log.SetOutput(os.Stdout)
 
 	cfg.Parse()
+	common.ConfigureLogging(os.Stdout)
 	cfg.Print()
 
-	broker := metadata.NewBroker(cfg.UseLocalhostInPluginUrls)
+	credentials, err := utils.LoadCredentials(cfg.RegistryCredentialsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	broker := metadata.NewBroker(cfg.UseLocalhostInPluginUrls, credentials)
 
 	common.ConfigureCertPool(cfg.SelfSignedCertificateFilePath, cfg.CABundleDirPath)
+	common.ConfigureClientCertificate(cfg.ClientCertificateFilePath, cfg.ClientKeyFilePath)
+	common.ConfigureProxy(cfg.Proxy)
 
+	ctx, cancel := common.NewRunContext(cfg.RunTimeout)
+	defer cancel()
 	if !cfg.DisablePushingToEndpoint {
-		statusTun := common.ConnectOrFail(cfg.PushStatusesEndpoint, cfg.Token)
+		statusTun := common.NewPushTunnel(cfg.PushStatusesEndpoint, cfg.Token, cfg.PushBufferSize, cfg.PushReconnectTimeout,
+			func(err error) {
+				log.Printf("ERROR: %s", err)
+				common.AbortRun(ctx, err)
+			})
 		broker.PushEvents(statusTun)
 	}
+	if cfg.EventsFilePath != "" {
+		fileSink, err := common.NewFileSink(cfg.EventsFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		broker.PushEvents(fileSink)
+	}
+	if cfg.WebhookURL != "" {
+		broker.PushEvents(common.NewWebhookSink(cfg.WebhookURL, cfg.WebhookRetries))
+	}
 
 	pluginFQNs, err := cfg.ParsePluginFQNs()
 	if err != nil {
 		message := fmt.Sprintf("Failed to process plugin fully qualified names from config: %s", err)
-		broker.PubFailed(message)
+		broker.PubFailed(message, &model.ErrorDetails{Code: model.ErrorCodeInvalidConfig})
 		broker.PubLog(message)
 		log.Fatal(err)
 	}
-	err = broker.Start(pluginFQNs, cfg.RegistryAddress)
+	err = broker.Start(ctx, pluginFQNs, cfg.Registries)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,206 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+)
+
+// ConflictKind is a kind of clash between plugins of a workspace
+type ConflictKind string
+
+const (
+	// ConflictWorkspaceEnv plugins set the same workspace env var to different values
+	ConflictWorkspaceEnv ConflictKind = "workspace-env"
+	// ConflictEndpoint plugins declare endpoints with the same name
+	ConflictEndpoint ConflictKind = "endpoint"
+	// ConflictPort containers of plugins expose the same port
+	ConflictPort ConflictKind = "port"
+	// ConflictVolume containers of plugins mount volumes with the same name to different paths
+	ConflictVolume ConflictKind = "volume"
+	// ConflictCommand containers of plugins declare commands with the same name
+	ConflictCommand ConflictKind = "command"
+)
+
+var conflictKinds = []ConflictKind{ConflictWorkspaceEnv, ConflictEndpoint, ConflictPort, ConflictVolume, ConflictCommand}
+
+// ConflictSeverity determines how the broker reacts to conflicts of a kind
+type ConflictSeverity string
+
+const (
+	// SeverityError conflicts fail the broker
+	SeverityError ConflictSeverity = "error"
+	// SeverityWarn conflicts are reported in the broker log
+	SeverityWarn ConflictSeverity = "warn"
+	// SeverityIgnore conflicts are not reported
+	SeverityIgnore ConflictSeverity = "ignore"
+)
+
+// Conflict is a clash between plugins of a workspace
+type Conflict struct {
+	Kind ConflictKind
+	// Name of the clashing item, e.g. the name of the workspace env var or the exposed port
+	Name string
+	// Plugins are IDs of the clashing plugins
+	Plugins []string
+	// Values are the different values of the item, e.g. mount paths of a volume. Values of
+	// workspace env vars are omitted, as they can be secret.
+	Values []string
+}
+
+func (c Conflict) String() string {
+	plugins := strings.Join(c.Plugins, ", ")
+	switch c.Kind {
+	case ConflictWorkspaceEnv:
+		return fmt.Sprintf("workspace env var %s has different values in plugins %s", c.Name, plugins)
+	case ConflictEndpoint:
+		return fmt.Sprintf("endpoint %s is declared by plugins %s", c.Name, plugins)
+	case ConflictPort:
+		return fmt.Sprintf("port %s is exposed by plugins %s", c.Name, plugins)
+	case ConflictVolume:
+		return fmt.Sprintf("volume %s is mounted to different paths %s by plugins %s", c.Name, strings.Join(c.Values, ", "), plugins)
+	default:
+		return fmt.Sprintf("command %s is declared by plugins %s", c.Name, plugins)
+	}
+}
+
+// ParseConflictSeverities parses comma-separated severities of conflict kinds, e.g.
+// 'endpoint=error,port=error,command=ignore'. Kinds that are not listed are SeverityWarn.
+func ParseConflictSeverities(value string) (map[ConflictKind]ConflictSeverity, error) {
+	severities := make(map[ConflictKind]ConflictSeverity)
+	for _, kind := range conflictKinds {
+		severities[kind] = SeverityWarn
+	}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		kind := ConflictKind(strings.TrimSpace(parts[0]))
+		if _, ok := severities[kind]; !ok {
+			return nil, utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+				"unknown conflict kind '%s' in '%s', expected one of %s", kind, pair, formatConflictKinds())
+		}
+		if len(parts) != 2 {
+			return nil, utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+				"conflict severity '%s' is not in format 'kind=severity'", pair)
+		}
+		switch severity := ConflictSeverity(strings.TrimSpace(parts[1])); severity {
+		case SeverityError, SeverityWarn, SeverityIgnore:
+			severities[kind] = severity
+		default:
+			return nil, utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+				"unknown conflict severity '%s' in '%s', expected '%s', '%s' or '%s'", severity, pair, SeverityError, SeverityWarn, SeverityIgnore)
+		}
+	}
+	return severities, nil
+}
+
+func formatConflictKinds() string {
+	var kinds []string
+	for _, kind := range conflictKinds {
+		kinds = append(kinds, "'"+string(kind)+"'")
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// conflictCandidates collects items of a kind by name, in order of their first occurrence
+type conflictCandidates struct {
+	kind  ConflictKind
+	names []string
+	items map[string]*Conflict
+}
+
+func newConflictCandidates(kind ConflictKind) *conflictCandidates {
+	return &conflictCandidates{kind: kind, items: make(map[string]*Conflict)}
+}
+
+// add records that plugin declares item name with value
+func (c *conflictCandidates) add(name string, pluginID string, value string) {
+	item, ok := c.items[name]
+	if !ok {
+		item = &Conflict{Kind: c.kind, Name: name}
+		c.items[name] = item
+		c.names = append(c.names, name)
+	}
+	if !utils.ListContains(pluginID, item.Plugins) {
+		item.Plugins = append(item.Plugins, pluginID)
+	}
+	if !utils.ListContains(value, item.Values) {
+		item.Values = append(item.Values, value)
+	}
+}
+
+// conflicts returns items declared by more than one plugin and, if differentValues is
+// set, only those with different values
+func (c *conflictCandidates) conflicts(differentValues bool) []Conflict {
+	var conflicts []Conflict
+	for _, name := range c.names {
+		item := c.items[name]
+		if len(item.Plugins) < 2 || (differentValues && len(item.Values) < 2) {
+			continue
+		}
+		conflict := *item
+		if !differentValues || c.kind == ConflictWorkspaceEnv {
+			conflict.Values = nil
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// FindConflicts returns clashes between metas that would break or confuse the workspace.
+// Conflicts are ordered by kind, then by the first occurrence of the clashing item.
+func FindConflicts(metas []model.PluginMeta) []Conflict {
+	workspaceEnv := newConflictCandidates(ConflictWorkspaceEnv)
+	endpoints := newConflictCandidates(ConflictEndpoint)
+	ports := newConflictCandidates(ConflictPort)
+	volumes := newConflictCandidates(ConflictVolume)
+	commands := newConflictCandidates(ConflictCommand)
+	for _, meta := range metas {
+		for _, env := range meta.Spec.WorkspaceEnv {
+			workspaceEnv.add(env.Name, meta.ID, env.Value)
+		}
+		for _, endpoint := range meta.Spec.Endpoints {
+			endpoints.add(endpoint.Name, meta.ID, "")
+		}
+		for _, containers := range [][]model.Container{meta.Spec.Containers, meta.Spec.InitContainers} {
+			for _, container := range containers {
+				for _, volume := range container.Volumes {
+					volumes.add(volume.Name, meta.ID, volume.MountPath)
+				}
+			}
+		}
+		for _, container := range meta.Spec.Containers {
+			for _, port := range container.Ports {
+				ports.add(strconv.Itoa(port.ExposedPort), meta.ID, "")
+			}
+			for _, command := range container.Commands {
+				commands.add(command.Name, meta.ID, "")
+			}
+		}
+	}
+
+	var conflicts []Conflict
+	conflicts = append(conflicts, workspaceEnv.conflicts(true)...)
+	conflicts = append(conflicts, endpoints.conflicts(false)...)
+	conflicts = append(conflicts, ports.conflicts(false)...)
+	conflicts = append(conflicts, volumes.conflicts(true)...)
+	conflicts = append(conflicts, commands.conflicts(false)...)
+	return conflicts
+}
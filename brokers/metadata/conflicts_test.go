@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package metadata
+
+import (
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func conflictingMetas() []model.PluginMeta {
+	return []model.PluginMeta{
+		{
+			ID: "redhat/java/0.63.0",
+			Spec: model.PluginMetaSpec{
+				WorkspaceEnv: []model.EnvVar{{Name: "JAVA_HOME", Value: "/jdk8"}, {Name: "SHARED", Value: "same"}},
+				Endpoints:    []model.Endpoint{{Name: "debug", TargetPort: 5005}},
+				Containers: []model.Container{{
+					Ports:    []model.ExposedPort{{ExposedPort: 5005}},
+					Volumes:  []model.Volume{{Name: "m2", MountPath: "/home/user/.m2"}},
+					Commands: []model.Command{{Name: "build", Command: []string{"mvn", "package"}}},
+				}},
+			},
+		},
+		{
+			ID: "redhat/java11/0.63.0",
+			Spec: model.PluginMetaSpec{
+				WorkspaceEnv: []model.EnvVar{{Name: "JAVA_HOME", Value: "/jdk11"}, {Name: "SHARED", Value: "same"}},
+				Endpoints:    []model.Endpoint{{Name: "debug", TargetPort: 5006}},
+				Containers: []model.Container{{
+					Ports:    []model.ExposedPort{{ExposedPort: 5005}},
+					Volumes:  []model.Volume{{Name: "m2", MountPath: "/root/.m2"}},
+					Commands: []model.Command{{Name: "build", Command: []string{"gradle", "build"}}},
+				}},
+				InitContainers: []model.Container{{
+					Volumes: []model.Volume{{Name: "m2", MountPath: "/root/.m2"}},
+				}},
+			},
+		},
+		{
+			ID: "redhat/maven/0.21.0",
+			Spec: model.PluginMetaSpec{
+				Containers: []model.Container{{
+					Volumes: []model.Volume{{Name: "m2", MountPath: "/home/user/.m2"}},
+				}},
+			},
+		},
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	conflicts := FindConflicts(conflictingMetas())
+
+	assert.Equal(t, []Conflict{
+		{Kind: ConflictWorkspaceEnv, Name: "JAVA_HOME", Plugins: []string{"redhat/java/0.63.0", "redhat/java11/0.63.0"}},
+		{Kind: ConflictEndpoint, Name: "debug", Plugins: []string{"redhat/java/0.63.0", "redhat/java11/0.63.0"}},
+		{Kind: ConflictPort, Name: "5005", Plugins: []string{"redhat/java/0.63.0", "redhat/java11/0.63.0"}},
+		{Kind: ConflictVolume, Name: "m2", Plugins: []string{"redhat/java/0.63.0", "redhat/java11/0.63.0", "redhat/maven/0.21.0"},
+			Values: []string{"/home/user/.m2", "/root/.m2"}},
+		{Kind: ConflictCommand, Name: "build", Plugins: []string{"redhat/java/0.63.0", "redhat/java11/0.63.0"}},
+	}, conflicts)
+	var messages []string
+	for _, conflict := range conflicts {
+		messages = append(messages, conflict.String())
+	}
+	assert.Equal(t, []string{
+		"workspace env var JAVA_HOME has different values in plugins redhat/java/0.63.0, redhat/java11/0.63.0",
+		"endpoint debug is declared by plugins redhat/java/0.63.0, redhat/java11/0.63.0",
+		"port 5005 is exposed by plugins redhat/java/0.63.0, redhat/java11/0.63.0",
+		"volume m2 is mounted to different paths /home/user/.m2, /root/.m2 by plugins redhat/java/0.63.0, redhat/java11/0.63.0, redhat/maven/0.21.0",
+		"command build is declared by plugins redhat/java/0.63.0, redhat/java11/0.63.0",
+	}, messages)
+}
+
+func TestFindConflictsIgnoresItemsOfSinglePlugin(t *testing.T) {
+	meta := model.PluginMeta{
+		ID: "eclipse/che-theia/next",
+		Spec: model.PluginMetaSpec{
+			WorkspaceEnv: []model.EnvVar{{Name: "THEIA", Value: "1"}, {Name: "THEIA", Value: "2"}},
+			Containers: []model.Container{
+				{Ports: []model.ExposedPort{{ExposedPort: 3100}}, Volumes: []model.Volume{{Name: "plugins", MountPath: "/plugins"}}},
+				{Ports: []model.ExposedPort{{ExposedPort: 3100}}, Volumes: []model.Volume{{Name: "plugins", MountPath: "/theia/plugins"}}},
+			},
+		},
+	}
+
+	assert.Empty(t, FindConflicts([]model.PluginMeta{meta}))
+}
+
+func TestParseConflictSeverities(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[ConflictKind]ConflictSeverity
+		wantErr string
+	}{
+		{
+			name: "Defaults to warnings",
+			want: map[ConflictKind]ConflictSeverity{
+				ConflictWorkspaceEnv: SeverityWarn, ConflictEndpoint: SeverityWarn, ConflictPort: SeverityWarn,
+				ConflictVolume: SeverityWarn, ConflictCommand: SeverityWarn,
+			},
+		},
+		{
+			name:  "Overrides listed kinds",
+			value: "endpoint=error, port=error,command=ignore",
+			want: map[ConflictKind]ConflictSeverity{
+				ConflictWorkspaceEnv: SeverityWarn, ConflictEndpoint: SeverityError, ConflictPort: SeverityError,
+				ConflictVolume: SeverityWarn, ConflictCommand: SeverityIgnore,
+			},
+		},
+		{
+			name:    "Unknown kind",
+			value:   "extension=error",
+			wantErr: "unknown conflict kind 'extension' in 'extension=error', expected one of 'workspace-env', 'endpoint', 'port', 'volume', 'command'",
+		},
+		{
+			name:    "Unknown severity",
+			value:   "port=fatal",
+			wantErr: "unknown conflict severity 'fatal' in 'port=fatal', expected 'error', 'warn' or 'ignore'",
+		},
+		{
+			name:    "Missing severity",
+			value:   "port",
+			wantErr: "conflict severity 'port' is not in format 'kind=severity'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseConflictSeverities(tt.value)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"strings"
 
 	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
 )
 
 const (
@@ -52,17 +53,17 @@ func GetRuntimeInjection(metas []model.PluginMeta) (*RemotePluginInjection, erro
 
 	runtimeBinaryPathEnv, err := findEnv(remoteEndpointExecutableEnvVar, containerInjector.Env)
 	if err != nil {
-		return nil, err
+		return nil, invalidEditor(editorMeta, err)
 	}
 
 	volumeName, err := findEnv(volumeNameEnvVar, containerInjector.Env)
 	if err != nil {
-		return nil, err
+		return nil, invalidEditor(editorMeta, err)
 	}
 
 	volume, err := findVolume(volumeName.Value, containerInjector.Volumes)
 	if err != nil {
-		return nil, err
+		return nil, invalidEditor(editorMeta, err)
 	}
 
 	return &RemotePluginInjection{
@@ -84,6 +85,10 @@ func InjectRemoteRuntime(meta *model.PluginMeta, injection *RemotePluginInjectio
 	container.Volumes = append(container.Volumes, injection.Volume)
 }
 
+func invalidEditor(editorMeta *model.PluginMeta, err error) error {
+	return utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidMeta, PluginID: editorMeta.ID}, "%s", err)
+}
+
 func findCheTheiaEditor(metas []model.PluginMeta) *model.PluginMeta {
 	for _, meta := range metas {
 		if strings.ToLower(meta.Type) == model.EditorPluginType &&
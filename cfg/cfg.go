@@ -18,11 +18,21 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+)
+
+// Supported log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
 )
 
 var (
@@ -45,11 +55,46 @@ var (
 	// DisablePushingToEndpoint disables pushing anything to the endpoint
 	DisablePushingToEndpoint bool
 
+	// PushBufferSize is the maximum number of events that are kept in memory
+	// while the push endpoint is unreachable
+	PushBufferSize int
+
+	// PushReconnectTimeout is how long the broker tries to reconnect to the push endpoint
+	// before giving up
+	PushReconnectTimeout time.Duration
+
+	// EventsFilePath path to a file where events are written, one JSON object per line
+	EventsFilePath string
+
+	// WebhookURL URL to which events are sent as JSON objects in POST requests
+	WebhookURL string
+
+	// WebhookRetries number of times a failed webhook request is retried
+	WebhookRetries int
+
+	// RequestTimeout is how long to wait for a response from a plugin registry or an
+	// extension host, and for each chunk of a download. Downloads that keep progressing
+	// are limited only by RunTimeout.
+	RequestTimeout time.Duration
+
+	// RunTimeout is the deadline for the whole broker run
+	RunTimeout time.Duration
+
+	// MetricsReportPath path to a file where the summary of the broker run is written as JSON
+	MetricsReportPath string
+
+	// MetricsTextfilePath path to a file where metrics of the broker run are written
+	// in Prometheus text format
+	MetricsTextfilePath string
+
 	// PrintEventsOnly disable output of broker logs and instead prints events that are supposed
 	// to be sent to endpoint. This helps imitate what info about plugin brokering
 	// a user would see
 	PrintEventsOnly bool
 
+	// LogFormat is the format of broker log output, either LogFormatText or LogFormatJSON
+	LogFormat string
+
 	// UseLocalhostInPluginUrls configures the broker to use the `localhost` name
 	// instead of the Kubernetes service name to build Theia or VSCode plugin
 	// endpoint URL
@@ -63,8 +108,20 @@ var (
 
 	// RegistryAddress address of the plugin registry, if plugin IDs are specified in config instead of metas.
 	// Used as a default registry if a plugin fully-qualified name does not specify a registry.
+	// Can be a comma-separated list of registries, which are tried in order.
 	RegistryAddress string
 
+	// Registries ordered list of default registries parsed from RegistryAddress
+	Registries []string
+
+	// RegistryCredentialsPath path to a YAML file with credentials for plugin registries
+	// and extension hosts
+	RegistryCredentialsPath string
+
+	// BundleDir absolute path to a directory with local plugin bundles. Plugins can be
+	// requested by path of a meta.yaml in this directory; other local files are not read.
+	BundleDir string
+
 	// SelfSignedCertificateFilePath path to certificate file that should be used while connection establishing to Che server.
 	// Usually it contains Che server self-signed certificate.
 	SelfSignedCertificateFilePath string
@@ -73,9 +130,81 @@ var (
 	// Usually they contain all the trusted CA in the cluster.
 	CABundleDirPath string
 
+	// PolicyFilePath path to a YAML file with allow and deny rules for plugins, publishers,
+	// registries, extension hosts and container images
+	PolicyFilePath string
+
+	// SignaturePolicy determines whether unsigned and badly signed plugin metas and extensions
+	// are rejected ('enforce'), reported ('warn') or not verified at all ('off')
+	SignaturePolicy string
+
+	// TrustedKeysPath path to a file with PEM encoded ed25519 public keys that plugin metas
+	// and extensions can be signed with
+	TrustedKeysPath string
+
+	// ConflictSeverity comma-separated severities of conflicts between plugins of a workspace,
+	// as 'kind=severity', e.g. 'endpoint=error,command=ignore'
+	ConflictSeverity string
+
+	// ClientCertificateFilePath path to PEM encoded client certificate used to authenticate
+	// with mutual TLS to plugin registries and to the push endpoint
+	ClientCertificateFilePath string
+
+	// ClientKeyFilePath path to PEM encoded private key of the client certificate
+	ClientKeyFilePath string
+
+	// SecretEnvNames comma-separated list of patterns of names of env vars, e.g. '*TOKEN*',
+	// whose values from plugin metas are masked in logs and events
+	SecretEnvNames string
+
+	// SecretQueryParams comma-separated list of names of URL query parameters whose
+	// values are masked in logs and events
+	SecretQueryParams string
+
+	// HTTPProxy proxy for plugin registries, extension hosts and push endpoint accessed
+	// over http. Defaults to HTTP_PROXY environment variable.
+	HTTPProxy string
+
+	// HTTPSProxy proxy for plugin registries, extension hosts and push endpoint accessed
+	// over https. Defaults to HTTPS_PROXY environment variable.
+	HTTPSProxy string
+
+	// NoProxy comma-separated list of hosts that are accessed without proxy.
+	// Defaults to NO_PROXY environment variable.
+	NoProxy string
+
+	// ProxyOverrides comma-separated list of 'host=proxy' pairs that set the proxy
+	// for particular hosts, where proxy is either URL of proxy or 'direct'
+	ProxyOverrides string
+
+	// Proxy effective proxy configuration parsed from proxy settings
+	Proxy utils.ProxyConfig
+
 	// MergePlugins determines whether the brokers should attempt to merge plugins
 	// when they run in the same sidecar image
 	MergePlugins bool
+
+	// MergeImageMatching comma-separated list of strategies that decide whether plugins
+	// with different images can be merged: 'normalized' and 'digest'
+	MergeImageMatching string
+
+	// MergeCompatibilityFilePath path to a YAML file with registry mirrors and groups of
+	// images that are compatible for merging plugins
+	MergeCompatibilityFilePath string
+
+	// MergeResourcePolicy determines resources of merged containers: 'sum', 'max',
+	// 'sum-capped:memory=quantity,cpu=quantity' or 'max-plus-overhead:memory=quantity,cpu=quantity'
+	MergeResourcePolicy string
+
+	// MergeResourceCeiling largest resources of merged containers, as 'memory=quantity,cpu=quantity'
+	MergeResourceCeiling string
+
+	// MergeReportPath path to a file where the report of merging plugins is written as JSON
+	MergeReportPath string
+
+	// DedupeExtensions determines whether the artifacts broker downloads extensions embedded
+	// by several plugins only once and shares them between the plugins
+	DedupeExtensions bool
 )
 
 func init() {
@@ -122,6 +251,62 @@ func init() {
 		"Whether pushing of data and logs to endpoint should be disabled. "+
 			"`false` by default. Needed for testing and debugging purposes",
 	)
+	flag.IntVar(
+		&PushBufferSize,
+		"push-buffer-size",
+		1000,
+		"Maximum number of events kept in memory while push endpoint is unreachable",
+	)
+	flag.DurationVar(
+		&PushReconnectTimeout,
+		"push-reconnect-timeout",
+		time.Minute,
+		"How long to keep reconnecting to push endpoint before giving up, e.g. '30s' or '2m'",
+	)
+	flag.StringVar(
+		&EventsFilePath,
+		"events-file",
+		"",
+		"Path to file where events are written, one JSON object per line. Can be used along with push endpoint",
+	)
+	flag.StringVar(
+		&WebhookURL,
+		"webhook-url",
+		"",
+		"URL to which each event is sent as JSON in a POST request. Can be used along with push endpoint",
+	)
+	flag.IntVar(
+		&WebhookRetries,
+		"webhook-retries",
+		3,
+		"Number of times a failed webhook request is retried before the event is dropped",
+	)
+	flag.DurationVar(
+		&RequestTimeout,
+		"request-timeout",
+		2*time.Minute,
+		"How long to wait for a response from plugin registry or extension host, and for each chunk of a download, e.g. '30s'. "+
+			"Downloads that keep progressing are not interrupted. Zero means no timeout",
+	)
+	flag.DurationVar(
+		&RunTimeout,
+		"run-timeout",
+		0,
+		"Deadline for the whole broker run, e.g. '10m'. Zero means no deadline",
+	)
+	flag.StringVar(
+		&MetricsReportPath,
+		"metrics-report",
+		"",
+		"Path to file where timings and statistics of the broker run are written as JSON",
+	)
+	flag.StringVar(
+		&MetricsTextfilePath,
+		"metrics-textfile",
+		"",
+		"Path to file where metrics of the broker run are written in Prometheus text format, e.g. for node-exporter textfile collector. "+
+			"The file is replaced by each run, so brokers running concurrently on the same node need different files",
+	)
 	flag.BoolVar(
 		&PrintEventsOnly,
 		"print-events-only",
@@ -129,6 +314,13 @@ func init() {
 		"Output events that are usually sent Che master instead of regular logs to imitate what a user can see."+
 			"`false` by default. Needed for testing and debugging purposes",
 	)
+	flag.StringVar(
+		&LogFormat,
+		"log-format",
+		LogFormatText,
+		"Format of log output, either 'text' or 'json'. In 'json' format each line is a JSON object, "+
+			"and with -print-events-only each event is printed as a JSON object",
+	)
 	flag.BoolVar(
 		&UseLocalhostInPluginUrls,
 		"use-localhost-in-plugin-urls",
@@ -140,7 +332,23 @@ func init() {
 		&RegistryAddress,
 		"registry-address",
 		"",
-		"Default address of registry from which to retrieve meta.yaml's when plugin FQNs do not specify a registry",
+		"Default address of registry from which to retrieve meta.yaml's when plugin FQNs do not specify a registry. "+
+			"Can be a comma-separated list of registries that are tried in order until one serves the meta.yaml",
+	)
+	flag.StringVar(
+		&RegistryCredentialsPath,
+		"registry-credentials",
+		"",
+		"Path to YAML file with a list of credentials for plugin registries and extension hosts. Each entry has a 'host' pattern, "+
+			"e.g. '*.registry.io', and either a bearer 'token', 'username' and 'password' for basic auth, or path to a 'netrc' file. "+
+			"Credentials are only sent over https unless the entry sets 'insecure: true'",
+	)
+	flag.StringVar(
+		&BundleDir,
+		"bundle-dir",
+		"",
+		"Absolute path to directory with local plugin bundles. Plugins can be requested by absolute path or file:// URL "+
+			"of a meta.yaml in this directory. Local files are not read if not set",
 	)
 	flag.StringVar(
 		&SelfSignedCertificateFilePath,
@@ -154,12 +362,138 @@ func init() {
 		"",
 		"Path to directory with trusted CA certificates",
 	)
+	flag.StringVar(
+		&PolicyFilePath,
+		"policy",
+		"",
+		"Path to YAML file with 'allow' and 'deny' rules for 'plugins', 'publishers', 'registries', 'extensionHosts' and 'images'. "+
+			"Plugins that are not allowed by the policy fail brokering",
+	)
+	flag.StringVar(
+		&ConflictSeverity,
+		"conflict-severity",
+		"",
+		"Comma-separated severities of conflicts between plugins of a workspace as 'kind=severity', "+
+			"e.g. 'endpoint=error,port=error,command=ignore'. Kinds are 'workspace-env', 'endpoint', 'port', "+
+			"'volume' and 'command', severities are 'error', 'warn' and 'ignore'. Conflicts not listed are reported as warnings",
+	)
+	flag.StringVar(
+		&SignaturePolicy,
+		"signature-policy",
+		string(utils.SignaturePolicyOff),
+		"Handling of plugin metas and extensions without a valid detached signature published at '<URL>.sig'. "+
+			"The signature covers '<URL>\\nsha256:<hex digest of content>\\n'. "+
+			"'enforce' fails brokering, 'warn' only reports them and 'off' disables verification",
+	)
+	flag.StringVar(
+		&TrustedKeysPath,
+		"trusted-keys",
+		"",
+		"Path to file with PEM encoded ed25519 public keys that plugin metas and extensions can be signed with",
+	)
+	flag.StringVar(
+		&ClientCertificateFilePath,
+		"client-cert",
+		"",
+		"Path to PEM encoded client certificate used for mutual TLS with plugin registries and push endpoint. "+
+			"The certificate is reloaded when the file changes",
+	)
+	flag.StringVar(
+		&ClientKeyFilePath,
+		"client-key",
+		"",
+		"Path to PEM encoded private key of the client certificate",
+	)
+	flag.StringVar(
+		&SecretEnvNames,
+		"secret-env-names",
+		"*TOKEN*,*SECRET*,*PASSWORD*,*PASSWD*,*CREDENTIALS*,*API_KEY*,*PRIVATE_KEY*",
+		"Comma-separated list of patterns of names of env vars whose values are masked in logs and events. "+
+			"Patterns are case-insensitive and can contain '*' wildcards",
+	)
+	flag.StringVar(
+		&SecretQueryParams,
+		"secret-query-params",
+		"token,access_token,id_token,auth,api_key,apikey,key,secret,password,sig,signature,"+
+			"x-amz-signature,x-amz-credential,x-amz-security-token",
+		"Comma-separated list of names of URL query parameters whose values are masked in logs and events",
+	)
+	flag.StringVar(
+		&HTTPProxy,
+		"http-proxy",
+		"",
+		"Proxy for requests over http, e.g. 'http://proxy.io:3128'. Defaults to HTTP_PROXY environment variable",
+	)
+	flag.StringVar(
+		&HTTPSProxy,
+		"https-proxy",
+		"",
+		"Proxy for requests over https. Defaults to HTTPS_PROXY environment variable",
+	)
+	flag.StringVar(
+		&NoProxy,
+		"no-proxy",
+		"",
+		"Comma-separated list of hosts, domains, IP addresses and CIDR ranges accessed without proxy. "+
+			"Defaults to NO_PROXY environment variable",
+	)
+	flag.StringVar(
+		&ProxyOverrides,
+		"proxy-overrides",
+		"",
+		"Comma-separated list of 'host=proxy' pairs that override proxy settings for particular hosts, "+
+			"e.g. '*.internal.io=direct,registry.io=http://other-proxy:3128'",
+	)
 	flag.BoolVar(
 		&MergePlugins,
 		"merge-plugins",
 		false,
 		"Configures the broker to attempt to merge plugins that run in the same sidecar during brokering",
 	)
+	flag.StringVar(
+		&MergeImageMatching,
+		"merge-image-matching",
+		"normalized,digest",
+		"Comma-separated list of strategies that decide whether plugins with different images can be merged: "+
+			"'normalized' merges image references that are the same after normalization, e.g. 'eclipse/theia' and "+
+			"'docker.io/eclipse/theia:latest', 'digest' merges images with the same digest or tag pinned to a digest. "+
+			"Empty value merges only plugins with identical images",
+	)
+	flag.StringVar(
+		&MergeCompatibilityFilePath,
+		"merge-compatibility",
+		"",
+		"Path to YAML file with 'mirrors' of registries and groups of compatible 'images' used when merging plugins",
+	)
+	flag.StringVar(
+		&MergeResourcePolicy,
+		"merge-resources",
+		"sum",
+		"Resources of merged containers: 'sum' or 'max' of resources of merged plugins, "+
+			"'sum-capped:memory=2Gi,cpu=1' to cap the sum, or 'max-plus-overhead:memory=128Mi,cpu=100m' "+
+			"to add the overhead to the maximum for each other merged plugin",
+	)
+	flag.StringVar(
+		&MergeResourceCeiling,
+		"merge-resource-ceiling",
+		"",
+		"Largest limits and requests of merged containers, e.g. 'memory=4Gi,cpu=2'. "+
+			"Plugins whose merged container would exceed them are not merged",
+	)
+	flag.StringVar(
+		&MergeReportPath,
+		"merge-report",
+		"",
+		"Path to file where the report of merging plugins, with merged groups, what they take from each plugin "+
+			"and conflicts of groups that could not be merged, is written as JSON",
+	)
+	flag.BoolVar(
+		&DedupeExtensions,
+		"dedupe-extensions",
+		false,
+		"Configures the artifacts broker to download extensions embedded by several plugins only once. "+
+			"Plugins running in Theia share the single file, plugins running in sidecars get hardlinks of it",
+	)
 }
 
 // Parse parses configuration.
@@ -174,6 +508,56 @@ func Parse() {
 		if !strings.HasPrefix(PushStatusesEndpoint, "ws") {
 			log.Fatal("Push endpoint protocol must be either ws or wss")
 		}
+		if PushBufferSize <= 0 {
+			log.Fatal("Push buffer size must be positive")
+		}
+	}
+
+	if WebhookURL != "" && !strings.HasPrefix(WebhookURL, "http") {
+		log.Fatal("Webhook URL protocol must be either http or https")
+	}
+
+	if LogFormat != LogFormatText && LogFormat != LogFormatJSON {
+		log.Fatalf("Log format must be either '%s' or '%s'", LogFormatText, LogFormatJSON)
+	}
+
+	if BundleDir != "" && !filepath.IsAbs(BundleDir) {
+		log.Fatal("Bundle directory must be an absolute path")
+	}
+
+	if (ClientCertificateFilePath == "") != (ClientKeyFilePath == "") {
+		log.Fatal("Client certificate and key must be specified together")
+	}
+	switch utils.SignaturePolicy(SignaturePolicy) {
+	case utils.SignaturePolicyOff:
+	case utils.SignaturePolicyWarn, utils.SignaturePolicyEnforce:
+		if TrustedKeysPath == "" {
+			log.Fatal("Trusted keys required for signature verification(set them with -trusted-keys argument)")
+		}
+	default:
+		log.Fatalf("Signature policy must be one of '%s', '%s' or '%s'",
+			utils.SignaturePolicyOff, utils.SignaturePolicyWarn, utils.SignaturePolicyEnforce)
+	}
+
+	overrides, err := utils.ParseProxyOverrides(ProxyOverrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Proxy = utils.ProxyConfig{
+		HTTPProxy:  valueOrEnv(HTTPProxy, "HTTP_PROXY", "http_proxy"),
+		HTTPSProxy: valueOrEnv(HTTPSProxy, "HTTPS_PROXY", "https_proxy"),
+		NoProxy:    valueOrEnv(NoProxy, "NO_PROXY", "no_proxy"),
+		Overrides:  overrides,
+	}.WithLogging()
+	if err := Proxy.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	Registries = nil
+	for _, registry := range strings.Split(RegistryAddress, ",") {
+		if registry = strings.TrimSpace(registry); registry != "" {
+			Registries = append(Registries, registry)
+		}
 	}
 
 	// auth-enabled - fetch CHE_MACHINE_TOKEN
@@ -192,6 +576,37 @@ func Parse() {
 	RuntimeID = model.RuntimeID{Workspace: parts[0], Environment: parts[1], OwnerId: parts[2]}
 }
 
+// valueOrEnv returns value if it is set and the first non-empty environment variable otherwise
+func valueOrEnv(value string, envs ...string) string {
+	for _, env := range envs {
+		if value != "" {
+			break
+		}
+		value = os.Getenv(env)
+	}
+	return value
+}
+
+// printProxies logs the effective proxy of the push endpoint, webhook and registries.
+// Proxies of other hosts, e.g. extension hosts, are logged when the hosts are first used.
+func printProxies() {
+	var URLs []string
+	if !DisablePushingToEndpoint {
+		URLs = append(URLs, PushStatusesEndpoint)
+	}
+	if WebhookURL != "" {
+		URLs = append(URLs, WebhookURL)
+	}
+	URLs = append(URLs, Registries...)
+	for _, rawURL := range URLs {
+		URL, err := url.Parse(rawURL)
+		if err != nil || URL.Host == "" {
+			continue
+		}
+		Proxy.LogProxy(URL)
+	}
+}
+
 // Print prints configuration.
 func Print() {
 	if PrintEventsOnly {
@@ -201,6 +616,40 @@ func Print() {
 	if !DisablePushingToEndpoint {
 		log.Printf("  Push endpoint: %s", PushStatusesEndpoint)
 		log.Printf("  Auth enabled: %t", AuthEnabled)
+		log.Printf("  Push buffer size: %d", PushBufferSize)
+		log.Printf("  Push reconnect timeout: %s", PushReconnectTimeout)
+	}
+	if EventsFilePath != "" {
+		log.Printf("  Events file: %s", EventsFilePath)
+	}
+	if WebhookURL != "" {
+		log.Printf("  Webhook URL: %s", WebhookURL)
+		log.Printf("  Webhook retries: %d", WebhookRetries)
+	}
+	log.Printf("  Request timeout: %s", RequestTimeout)
+	if RunTimeout > 0 {
+		log.Printf("  Run timeout: %s", RunTimeout)
+	}
+	if MetricsReportPath != "" {
+		log.Printf("  Metrics report: %s", MetricsReportPath)
+	}
+	if MetricsTextfilePath != "" {
+		log.Printf("  Metrics textfile: %s", MetricsTextfilePath)
+	}
+	if MergePlugins && MergeReportPath != "" {
+		log.Printf("  Merge report: %s", MergeReportPath)
+	}
+	if DedupeExtensions {
+		log.Print("  De-duplicating extensions")
+	}
+	if len(Registries) > 0 {
+		log.Printf("  Registries: %s", strings.Join(Registries, ", "))
+	}
+	if RegistryCredentialsPath != "" {
+		log.Printf("  Registry credentials: %s", RegistryCredentialsPath)
+	}
+	if BundleDir != "" {
+		log.Printf("  Bundle directory: %s", BundleDir)
 	}
 	log.Print("  Runtime ID:")
 	log.Printf("    Workspace: %s", RuntimeID.Workspace)
@@ -212,6 +661,19 @@ func Print() {
 	if CABundleDirPath != "" {
 		log.Printf("  CA bundle certificates path %s", CABundleDirPath)
 	}
+	if ClientCertificateFilePath != "" {
+		log.Printf("  Client certificate %s", ClientCertificateFilePath)
+	}
+	if PolicyFilePath != "" {
+		log.Printf("  Policy %s", PolicyFilePath)
+	}
+	if ConflictSeverity != "" {
+		log.Printf("  Conflict severity %s", ConflictSeverity)
+	}
+	if utils.SignaturePolicy(SignaturePolicy) != utils.SignaturePolicyOff {
+		log.Printf("  Signature policy %s, trusted keys %s", SignaturePolicy, TrustedKeysPath)
+	}
+	printProxies()
 }
 
 // ParsePluginFQNs reads content of file at path cfg.Filepath and parses its
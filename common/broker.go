@@ -13,8 +13,8 @@
 package common
 
 import (
-	jsonrpc "github.com/eclipse/che-go-jsonrpc"
 	"github.com/eclipse/che-go-jsonrpc/event"
+	"github.com/eclipse/che-plugin-broker/cfg"
 	"github.com/eclipse/che-plugin-broker/model"
 )
 
@@ -22,7 +22,7 @@ import (
 // plugins of a specific type
 type BrokerImpl interface {
 	Start([]model.PluginMeta)
-	PushEvents(tun *jsonrpc.Tunnel)
+	PushEvents(sink EventSink)
 	ProcessPlugin(meta model.PluginMeta) error
 }
 
@@ -30,15 +30,20 @@ type BrokerImpl interface {
 type Broker interface {
 	CloseConsumers()
 	Bus() *event.Bus
-	PushEvents(tun *jsonrpc.Tunnel, types ...string)
+	PushEvents(sink EventSink, types ...string)
 	PubStarted()
-	PubFailed(err string)
+	PubFailed(err string, details *model.ErrorDetails)
 	PubDone(tooling string)
 	PubLog(text string)
+	PubPluginProgress(pluginID string, phase model.PluginPhase, bytesDownloaded int64, bytesTotal int64)
 	PrintPlan(metas []model.PluginMeta)
+	// RegisterSecrets masks values of secret env vars of metas in all subsequent logs and events
+	RegisterSecrets(metas []model.PluginMeta)
 	PrintDebug(format string, v ...interface{})
 	PrintInfo(format string, v ...interface{})
 	PrintInfoBuffer(info []string)
+	// PrintPluginInfoBuffer is the same as PrintInfoBuffer for messages related to a single plugin
+	PrintPluginInfoBuffer(pluginID string, info []string)
 	// It is not convenient in tests with mocks.
 	// It should exit current context but when mocked it does not exit.
 	// Instead use: PubLog, log.Fatal
@@ -50,12 +55,20 @@ type brokerImpl struct {
 }
 
 func NewBroker() Broker {
-	return &brokerImpl{event.NewBus()}
+	broker := &brokerImpl{event.NewBus()}
+	if cfg.PrintEventsOnly && jsonOutput != nil {
+		broker.bus.SubAny(jsonOutput,
+			model.BrokerStatusEventType,
+			model.BrokerResultEventType,
+			model.BrokerLogEventType,
+			model.BrokerPluginProgressEventType)
+	}
+	return broker
 }
 
-// PushEvents sets given tunnel as consumer of broker events.
-func (broker brokerImpl) PushEvents(tun *jsonrpc.Tunnel, types ...string) {
-	broker.Bus().SubAny(&tunnelBroadcaster{tunnel: tun}, types...)
+// PushEvents sets given sink as consumer of broker events.
+func (broker brokerImpl) PushEvents(sink EventSink, types ...string) {
+	broker.Bus().SubAny(sink, types...)
 }
 
 func (broker brokerImpl) Bus() *event.Bus {
@@ -63,10 +76,13 @@ func (broker brokerImpl) Bus() *event.Bus {
 }
 
 func (broker brokerImpl) CloseConsumers() {
+	// The same sink is subscribed to multiple event types
+	closed := map[EventSink]bool{}
 	for _, candidates := range broker.bus.Clear() {
 		for _, candidate := range candidates {
-			if broadcaster, ok := candidate.(*tunnelBroadcaster); ok {
-				broadcaster.Close()
+			if sink, ok := candidate.(EventSink); ok && !closed[sink] {
+				sink.Close()
+				closed[sink] = true
 			}
 		}
 	}
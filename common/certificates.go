@@ -0,0 +1,125 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eclipse/che-go-jsonrpc/jsonrpcws"
+)
+
+// CertificateReloader provides a client certificate for TLS handshakes. The certificate
+// and key files are checked on each handshake and the certificate is reloaded when
+// they change, e.g. when a certificate is rotated during a long download.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	certStat    fileStat
+	keyStat     fileStat
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertificateReloader loads the client certificate and key from PEM files
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	certStat, keyStat, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(certStat, keyStat); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) stat() (certStat fileStat, keyStat fileStat, err error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fileStat{}, fileStat{}, fmt.Errorf("failed to read client certificate %q: %s", r.certFile, err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fileStat{}, fileStat{}, fmt.Errorf("failed to read client key %q: %s", r.keyFile, err)
+	}
+	return fileStat{certInfo.ModTime(), certInfo.Size()}, fileStat{keyInfo.ModTime(), keyInfo.Size()}, nil
+}
+
+func (r *CertificateReloader) load(certStat fileStat, keyStat fileStat) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate %q with key %q: %s", r.certFile, r.keyFile, err)
+	}
+	r.certificate = &certificate
+	r.certStat, r.keyStat = certStat, keyStat
+	return nil
+}
+
+// GetClientCertificate returns the current client certificate, reloading it if its files
+// have changed. If reloading fails, e.g. because only one of the files has been replaced
+// so far, the previously loaded certificate is used.
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certStat, keyStat, err := r.stat()
+	if err == nil && (certStat != r.certStat || keyStat != r.keyStat) {
+		err = r.load(certStat, keyStat)
+		if err == nil {
+			log.Printf("Reloaded client certificate %q", r.certFile)
+		}
+	}
+	if err != nil {
+		log.Printf("Using previously loaded client certificate. Error: %v", err)
+	}
+	return r.certificate, nil
+}
+
+// ConfigureClientCertificate makes connections to plugin registries and to the push
+// endpoint authenticate with the client certificate, for servers that require mutual TLS.
+// It should be called after ConfigureCertPool, since that replaces TLS configuration.
+func ConfigureClientCertificate(certFile string, keyFile string) {
+	if certFile == "" && keyFile == "" {
+		// Do nothing
+		return
+	}
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport)
+	transport.TLSClientConfig = withClientCertificate(transport.TLSClientConfig, reloader)
+	jsonrpcws.DefaultDialer.TLSClientConfig = withClientCertificate(jsonrpcws.DefaultDialer.TLSClientConfig, reloader)
+}
+
+func withClientCertificate(config *tls.Config, reloader *CertificateReloader) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	config.GetClientCertificate = reloader.GetClientCertificate
+	return config
+}
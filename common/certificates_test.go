@@ -0,0 +1,222 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns PEM encoded certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// newMutualTLSServer starts a server that requires client certificates signed by ca
+// and responds with the common name of the client certificate
+func newMutualTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	certPEM, keyPEM := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	server.StartTLS()
+	return server
+}
+
+func writeClientCertificate(t *testing.T, ca *testCA, dir string, commonName string, modTime time.Time) (string, string) {
+	certPEM, keyPEM := ca.issue(t, commonName, x509.ExtKeyUsageClientAuth)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Ensure that the change is detected even on filesystems with coarse timestamps
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func getWithClientCertificate(t *testing.T, server *httptest.Server, ca *testCA, reloader *CertificateReloader) (string, error) {
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:              ca.pool,
+			GetClientCertificate: reloader.GetClientCertificate,
+		},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestCertificateReloaderAuthenticatesWithMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	server := newMutualTLSServer(t, ca)
+	defer server.Close()
+	certFile, keyFile := writeClientCertificate(t, ca, dir, "client", time.Now())
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	got, err := getWithClientCertificate(t, server, ca, reloader)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "client", got)
+}
+
+func TestCertificateReloaderReloadsChangedCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	server := newMutualTLSServer(t, ca)
+	defer server.Close()
+	certFile, keyFile := writeClientCertificate(t, ca, dir, "first", time.Now().Add(-time.Minute))
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeClientCertificate(t, ca, dir, "second", time.Now())
+	got, err := getWithClientCertificate(t, server, ca, reloader)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "second", got)
+}
+
+func TestCertificateReloaderKeepsCertificateWhenReloadFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	server := newMutualTLSServer(t, ca)
+	defer server.Close()
+	certFile, keyFile := writeClientCertificate(t, ca, dir, "client", time.Now().Add(-time.Minute))
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the certificate is replaced, so it does not match the key
+	otherCert, _ := ca.issue(t, "other", x509.ExtKeyUsageClientAuth)
+	if err := ioutil.WriteFile(certFile, otherCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := getWithClientCertificate(t, server, ca, reloader)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "client", got)
+}
+
+func TestNewCertificateReloaderFailsOnMissingFiles(t *testing.T) {
+	_, err := NewCertificateReloader("/missing/tls.crt", "/missing/tls.key")
+
+	assert.EqualError(t, err, `failed to read client certificate "/missing/tls.crt": stat /missing/tls.crt: no such file or directory`)
+}
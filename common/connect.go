@@ -22,22 +22,10 @@ import (
 	"io/ioutil"
 
 	jsonrpc "github.com/eclipse/che-go-jsonrpc"
-	"github.com/eclipse/che-go-jsonrpc/event"
 	"github.com/eclipse/che-go-jsonrpc/jsonrpcws"
+	"github.com/eclipse/che-plugin-broker/utils"
 )
 
-type tunnelBroadcaster struct {
-	tunnel *jsonrpc.Tunnel
-}
-
-func (tb *tunnelBroadcaster) Close() { tb.tunnel.Close() }
-
-func (tb *tunnelBroadcaster) Accept(e event.E) {
-	if err := tb.tunnel.Notify(e.Type(), e); err != nil {
-		log.Fatalf("Trying to send event of type '%s' to closed tunnel '%s'", e.Type(), tb.tunnel.ID())
-	}
-}
-
 // ConfigureCertPool trusts given certificates
 // CAFilePath is a path to file which contains CA certificates.
 //   Usually it contains Che server self-signed certificate.
@@ -107,12 +95,11 @@ func ConfigureCertPool(CAFilePath string, CADirPath string) {
 	}
 }
 
-func ConnectOrFail(endpoint string, token string) *jsonrpc.Tunnel {
-	tunnel, err := Connect(endpoint, token)
-	if err != nil {
-		log.Fatalf("Couldn't connect to endpoint '%s', due to error '%s'", endpoint, err)
-	}
-	return tunnel
+// ConfigureProxy makes connections to plugin registries, extension hosts and to the
+// push endpoint use proxies according to proxy configuration
+func ConfigureProxy(proxy utils.ProxyConfig) {
+	http.DefaultTransport.(*http.Transport).Proxy = proxy.Proxy
+	jsonrpcws.DefaultDialer.Proxy = proxy.Proxy
 }
 
 func Connect(endpoint string, token string) (*jsonrpc.Tunnel, error) {
@@ -0,0 +1,55 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/eclipse/che-go-jsonrpc/jsonrpcws"
+	"github.com/eclipse/che-plugin-broker/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureProxyAppliesToRegistriesAndPushEndpoint(t *testing.T) {
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedURL = r.URL.String()
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+	transport := http.DefaultTransport.(*http.Transport)
+	defer func(transportProxy, dialerProxy func(*http.Request) (*url.URL, error)) {
+		transport.Proxy = transportProxy
+		jsonrpcws.DefaultDialer.Proxy = dialerProxy
+	}(transport.Proxy, jsonrpcws.DefaultDialer.Proxy)
+
+	ConfigureProxy(utils.ProxyConfig{HTTPProxy: proxy.URL, NoProxy: "direct.io"})
+
+	data, err := utils.New(0, nil, "").Fetch(context.Background(), "http://registry.io/plugins/meta.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "proxied", string(data))
+	assert.Equal(t, "http://registry.io/plugins/meta.yaml", proxiedURL)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://che.io/api/websocket", nil)
+	dialerProxy, err := jsonrpcws.DefaultDialer.Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, proxy.URL, dialerProxy.String())
+	req, _ = http.NewRequest(http.MethodGet, "http://direct.io/api/websocket", nil)
+	dialerProxy, err = jsonrpcws.DefaultDialer.Proxy(req)
+	assert.NoError(t, err)
+	assert.Nil(t, dialerProxy)
+}
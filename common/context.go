@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+)
+
+type runInfoKey struct{}
+
+// runInfo records why the context of a broker run was cancelled
+type runInfo struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	mutex   sync.Mutex
+	signal  os.Signal
+	// abortErr is the reason of aborting the run, see AbortRun
+	abortErr error
+}
+
+// NewRunContext creates the context of a broker run. The context is cancelled when
+// the process receives SIGTERM or SIGINT, or when timeout elapses. Zero timeout means
+// that the run has no deadline.
+func NewRunContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	info := &runInfo{timeout: timeout}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), runInfoKey{}, info))
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancelParent := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelParent()
+		}
+	}
+	info.cancel = cancel
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Printf("Received signal %s, cancelling plugin brokering", sig)
+			info.mutex.Lock()
+			info.signal = sig
+			info.mutex.Unlock()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// AbortRun cancels the broker run of ctx because of err, e.g. when broker events can no
+// longer be delivered to Che master. Does nothing if ctx is not a run context.
+func AbortRun(ctx context.Context, err error) {
+	info, ok := ctx.Value(runInfoKey{}).(*runInfo)
+	if !ok {
+		return
+	}
+	info.mutex.Lock()
+	if info.abortErr == nil {
+		info.abortErr = err
+	}
+	info.mutex.Unlock()
+	info.cancel()
+}
+
+// CancellationError returns an error that explains why ctx is done, or nil if ctx
+// is not done. The message of cause, usually the error of the interrupted operation,
+// is appended to the explanation.
+func CancellationError(ctx context.Context, cause error) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	details := model.ErrorDetails{Code: model.ErrorCodeCancelled}
+	reason := "plugin brokering was cancelled"
+	info, _ := ctx.Value(runInfoKey{}).(*runInfo)
+	if ctx.Err() == context.DeadlineExceeded {
+		details = model.ErrorDetails{Code: model.ErrorCodeTimeout, Retryable: true}
+		reason = "plugin brokering did not complete before deadline"
+		if info != nil && info.timeout > 0 {
+			reason = "plugin brokering did not complete within " + info.timeout.String()
+		}
+	} else if info != nil {
+		info.mutex.Lock()
+		if info.abortErr != nil {
+			details = model.ErrorDetails{Code: model.ErrorCodeCancelled, Retryable: true}
+			reason = "plugin brokering was aborted: " + info.abortErr.Error()
+		} else if info.signal != nil {
+			reason = "plugin brokering was cancelled by signal " + info.signal.String()
+		}
+		info.mutex.Unlock()
+	}
+	if cause == nil || cause == ctx.Err() {
+		return utils.NewBrokerError(details, "%s", reason)
+	}
+	return utils.NewBrokerError(details, "%s: %s", reason, cause)
+}
@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancellationErrorIsNilWhileContextIsActive(t *testing.T) {
+	ctx, cancel := NewRunContext(0)
+	defer cancel()
+
+	assert.NoError(t, CancellationError(ctx, errors.New("test error")))
+}
+
+func TestCancellationErrorReportsDeadline(t *testing.T) {
+	ctx, cancel := NewRunContext(10 * time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := CancellationError(ctx, errors.New("test error"))
+
+	assert.EqualError(t, err, "plugin brokering did not complete within 10ms: test error")
+	assert.Equal(t, &model.ErrorDetails{Code: model.ErrorCodeTimeout, Retryable: true}, utils.GetErrorDetails(err))
+}
+
+func TestCancellationErrorReportsSignal(t *testing.T) {
+	ctx, cancel := NewRunContext(0)
+	defer cancel()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Context was not cancelled by SIGTERM")
+	}
+
+	err := CancellationError(ctx, ctx.Err())
+	assert.EqualError(t, err, "plugin brokering was cancelled by signal terminated")
+	assert.Equal(t, &model.ErrorDetails{Code: model.ErrorCodeCancelled}, utils.GetErrorDetails(err))
+}
+
+func TestCancellationErrorReportsAbort(t *testing.T) {
+	ctx, cancel := NewRunContext(0)
+	defer cancel()
+
+	AbortRun(ctx, errors.New("endpoint is unreachable"))
+
+	assert.Error(t, ctx.Err())
+	err := CancellationError(ctx, errors.New("test error"))
+	assert.EqualError(t, err, "plugin brokering was aborted: endpoint is unreachable: test error")
+	assert.Equal(t, &model.ErrorDetails{Code: model.ErrorCodeCancelled, Retryable: true}, utils.GetErrorDetails(err))
+}
+
+func TestCancellationErrorWithoutRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := CancellationError(ctx, nil)
+
+	assert.EqualError(t, err, "plugin brokering was cancelled")
+}
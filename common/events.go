@@ -15,7 +15,6 @@ package common
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -30,14 +29,24 @@ func (broker *brokerImpl) PubStarted() {
 	})
 }
 
-func (broker *brokerImpl) PubFailed(err string) {
+func (broker *brokerImpl) PubFailed(err string, details *model.ErrorDetails) {
+	if details != nil {
+		redacted := *details
+		redacted.PluginID = redactor.Redact(redacted.PluginID)
+		redacted.URL = redactor.Redact(redacted.URL)
+		details = &redacted
+	}
 	broker.bus.Pub(&model.ErrorEvent{
 		Status:    model.StatusFailed,
-		Error:     err,
+		Error:     redactor.Redact(err),
+		Details:   details,
 		RuntimeID: cfg.RuntimeID,
 	})
 }
 
+// PubDone publishes tooling as is, since Che master needs the actual values of env vars
+// to start the workspace. Secrets are masked when tooling is logged or written by any
+// sink other than the push tunnel.
 func (broker *brokerImpl) PubDone(tooling string) {
 	broker.bus.Pub(&model.SuccessEvent{
 		Status:    model.StatusDone,
@@ -49,44 +58,78 @@ func (broker *brokerImpl) PubDone(tooling string) {
 func (broker *brokerImpl) PubLog(text string) {
 	broker.bus.Pub(&model.PluginBrokerLogEvent{
 		RuntimeID: cfg.RuntimeID,
-		Text:      text,
+		Text:      redactor.Redact(text),
 		Time:      time.Now(),
 	})
 }
 
+func (broker *brokerImpl) PubPluginProgress(pluginID string, phase model.PluginPhase, bytesDownloaded int64, bytesTotal int64) {
+	broker.bus.Pub(&model.PluginProgressEvent{
+		RuntimeID:       cfg.RuntimeID,
+		PluginID:        redactor.Redact(pluginID),
+		Phase:           phase,
+		BytesDownloaded: bytesDownloaded,
+		BytesTotal:      bytesTotal,
+		Time:            time.Now(),
+	})
+}
+
 func (broker *brokerImpl) PrintPlan(metas []model.PluginMeta) {
 	var buffer bytes.Buffer
 
 	buffer.WriteString("List of plugins and editors to install\n")
 	for _, plugin := range metas {
-		buffer.WriteString(fmt.Sprintf("- %s/%s/%s - %s\n", plugin.Publisher, plugin.Name, plugin.Version, plugin.Description))
+		buffer.WriteString(fmt.Sprintf("- %s/%s/%s - %s", plugin.Publisher, plugin.Name, plugin.Version, plugin.Description))
+		if plugin.RequestedID != "" {
+			buffer.WriteString(fmt.Sprintf(" (resolved from %s)", plugin.RequestedID))
+		}
+		if len(plugin.RequiredBy) > 0 {
+			buffer.WriteString(fmt.Sprintf(" (added as dependency of %s)", strings.Join(plugin.RequiredBy, ", ")))
+		}
+		switch plugin.Signature {
+		case model.SignatureVerified:
+			buffer.WriteString(" (signature verified)")
+		case model.SignatureUnsigned:
+			buffer.WriteString(" (unsigned)")
+		case model.SignatureInvalid:
+			buffer.WriteString(" (signature invalid)")
+		}
+		buffer.WriteString("\n")
 	}
 
 	broker.PrintInfo(buffer.String())
 }
 
+func (broker *brokerImpl) RegisterSecrets(metas []model.PluginMeta) {
+	redactor.AddMetaSecrets(metas)
+}
+
 func (broker *brokerImpl) PrintDebug(format string, v ...interface{}) {
 	if !cfg.PrintEventsOnly {
-		log.Printf(format, v...)
+		printLog(levelDebug, "", fmt.Sprintf(format, v...))
 	}
 }
 
 func (broker *brokerImpl) PrintInfo(format string, v ...interface{}) {
 	message := fmt.Sprintf(format, v...)
 	broker.PubLog(message)
-	log.Print(message)
+	printLog(levelInfo, "", message)
 }
 
 func (broker *brokerImpl) PrintInfoBuffer(buffer []string) {
+	broker.PrintPluginInfoBuffer("", buffer)
+}
+
+func (broker *brokerImpl) PrintPluginInfoBuffer(pluginID string, buffer []string) {
 	message := strings.Join(buffer, "\n")
 	broker.PubLog(message)
 	for _, line := range buffer {
-		log.Print(line)
+		printLog(levelInfo, pluginID, line)
 	}
 }
 
 func (broker *brokerImpl) PrintFatal(format string, v ...interface{}) {
 	message := fmt.Sprintf(format, v...)
 	broker.PubLog(message)
-	log.Fatal(message)
+	printLog(levelFatal, "", message)
 }
@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintPlanExplainsResolvedAndImplicitPlugins(t *testing.T) {
+	out := setupJSONLogging(t, false)
+	broker := NewBroker()
+
+	broker.PrintPlan([]model.PluginMeta{
+		{Publisher: "pub", Name: "debugger", Version: "1.0", Description: "Debugger", RequestedID: "pub/debugger/latest"},
+		{Publisher: "pub", Name: "java", Version: "1.0", Description: "Java", RequiredBy: []string{"pub/debugger/1.0", "pub/other/1.0"}},
+	})
+
+	entries := parseJSONLines(t, out)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "List of plugins and editors to install\n"+
+			"- pub/debugger/1.0 - Debugger (resolved from pub/debugger/latest)\n"+
+			"- pub/java/1.0 - Java (added as dependency of pub/debugger/1.0, pub/other/1.0)\n",
+			entries[0]["message"])
+	}
+}
+
+func TestPrintPlanShowsSignatureStatus(t *testing.T) {
+	out := setupJSONLogging(t, false)
+	broker := NewBroker()
+
+	broker.PrintPlan([]model.PluginMeta{
+		{Publisher: "pub", Name: "java", Version: "1.0", Description: "Java", Signature: model.SignatureVerified},
+		{Publisher: "pub", Name: "go", Version: "1.0", Description: "Go", Signature: model.SignatureUnsigned},
+		{Publisher: "pub", Name: "xml", Version: "1.0", Description: "XML", Signature: model.SignatureInvalid},
+	})
+
+	entries := parseJSONLines(t, out)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "List of plugins and editors to install\n"+
+			"- pub/java/1.0 - Java (signature verified)\n"+
+			"- pub/go/1.0 - Go (unsigned)\n"+
+			"- pub/xml/1.0 - XML (signature invalid)\n",
+			entries[0]["message"])
+	}
+}
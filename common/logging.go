@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/che-go-jsonrpc/event"
+	"github.com/eclipse/che-plugin-broker/cfg"
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+// Log levels used in JSON log output
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelFatal = "fatal"
+)
+
+// jsonOutput is the writer for JSON log output. It is nil when logs are printed as text.
+var jsonOutput *jsonLogWriter
+
+// logEntry is a single line of JSON log output
+type logEntry struct {
+	Level     string          `json:"level"`
+	Timestamp time.Time       `json:"timestamp"`
+	RuntimeID model.RuntimeID `json:"runtimeId"`
+	PluginID  string          `json:"pluginId,omitempty"`
+	Message   string          `json:"message"`
+}
+
+// jsonLogWriter writes each message it receives as a JSON object on a separate line
+type jsonLogWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+// Write implements io.Writer, so that messages printed by the standard logger
+// are written as JSON objects with info level.
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	w.writeEntry(levelInfo, "", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func (w *jsonLogWriter) writeEntry(level string, pluginID string, message string) {
+	w.writeJSON(&logEntry{
+		Level:     level,
+		Timestamp: time.Now().UTC(),
+		RuntimeID: cfg.RuntimeID,
+		PluginID:  redactor.Redact(pluginID),
+		Message:   redactor.Redact(message),
+	})
+}
+
+func (w *jsonLogWriter) writeJSON(v interface{}) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":"%s","message":"Failed to marshal log entry: %s"}`, levelInfo, err))
+	}
+	w.writeLine(line)
+}
+
+func (w *jsonLogWriter) writeLine(line []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.out.Write(append(line, '\n'))
+}
+
+// Accept prints event as a JSON object
+func (w *jsonLogWriter) Accept(e event.E) {
+	line, err := marshalEvent(e)
+	if err != nil {
+		w.writeEntry(levelInfo, "", fmt.Sprintf("Failed to marshal event of type '%s': %s", e.Type(), err))
+		return
+	}
+	w.writeLine(line)
+}
+
+// ConfigureLogging sets up the standard logger to write to out in the format
+// set in cfg.LogFormat. In JSON format, every message printed by the broker,
+// including messages printed with the standard logger, is written as a JSON object
+// on a separate line. Secrets configured in cfg, as well as the machine token, are
+// masked in all messages.
+func ConfigureLogging(out io.Writer) {
+	redactor = NewRedactor(cfg.SecretEnvNames, cfg.SecretQueryParams)
+	redactor.AddSecret(cfg.Token)
+	if cfg.LogFormat == cfg.LogFormatJSON {
+		jsonOutput = &jsonLogWriter{out: out}
+		log.SetFlags(0)
+		log.SetOutput(jsonOutput)
+	} else {
+		jsonOutput = nil
+		log.SetOutput(&redactingWriter{out: out})
+	}
+}
+
+// printLog prints message at given level. When only events are printed in JSON format,
+// messages are omitted since they are already printed as log events.
+func printLog(level string, pluginID string, message string) {
+	if jsonOutput == nil {
+		if level == levelFatal {
+			log.Fatal(message)
+		}
+		log.Print(message)
+		return
+	}
+	if !cfg.PrintEventsOnly {
+		jsonOutput.writeEntry(level, pluginID, message)
+	}
+	if level == levelFatal {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/cfg"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupJSONLogging(t *testing.T, printEventsOnly bool) *bytes.Buffer {
+	cfg.LogFormat = cfg.LogFormatJSON
+	cfg.PrintEventsOnly = printEventsOnly
+	out := &bytes.Buffer{}
+	ConfigureLogging(out)
+	t.Cleanup(func() {
+		cfg.LogFormat = cfg.LogFormatText
+		cfg.PrintEventsOnly = false
+		ConfigureLogging(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	return out
+}
+
+func parseJSONLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Line '%s' is not a JSON object: %s", line, err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestJSONLoggingPrintsMessagesAsJSON(t *testing.T) {
+	out := setupJSONLogging(t, false)
+	broker := NewBroker()
+
+	broker.PrintInfo("Info %s", "message")
+	broker.PrintDebug("Debug message")
+	broker.PrintPluginInfoBuffer("publisher/name/1.0", []string{"Plugin message"})
+	log.Print("Standard logger message")
+
+	entries := parseJSONLines(t, out)
+	if assert.Len(t, entries, 4) {
+		assert.Equal(t, "info", entries[0]["level"])
+		assert.Equal(t, "Info message", entries[0]["message"])
+		assert.Contains(t, entries[0], "timestamp")
+		assert.Contains(t, entries[0], "runtimeId")
+		assert.NotContains(t, entries[0], "pluginId")
+		assert.Equal(t, "debug", entries[1]["level"])
+		assert.Equal(t, "Debug message", entries[1]["message"])
+		assert.Equal(t, "publisher/name/1.0", entries[2]["pluginId"])
+		assert.Equal(t, "Plugin message", entries[2]["message"])
+		assert.Equal(t, "info", entries[3]["level"])
+		assert.Equal(t, "Standard logger message", entries[3]["message"])
+	}
+}
+
+func TestJSONLoggingPrintsOnlyEvents(t *testing.T) {
+	out := setupJSONLogging(t, true)
+	broker := NewBroker()
+
+	broker.PubStarted()
+	broker.PrintInfo("Info message")
+	broker.PrintDebug("Debug message")
+
+	entries := parseJSONLines(t, out)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "broker/statusChanged", entries[0]["type"])
+		assert.Equal(t, "STARTED", entries[0]["event"].(map[string]interface{})["status"])
+		assert.Equal(t, "broker/log", entries[1]["type"])
+		assert.Equal(t, "Info message", entries[1]["event"].(map[string]interface{})["text"])
+	}
+}
@@ -3,9 +3,9 @@
 package mocks
 
 import event "github.com/eclipse/che-go-jsonrpc/event"
-import jsonrpc "github.com/eclipse/che-go-jsonrpc"
 import mock "github.com/stretchr/testify/mock"
 import model "github.com/eclipse/che-plugin-broker/model"
+import common "github.com/eclipse/che-plugin-broker/common"
 
 // Broker is an autogenerated mock type for the Broker type
 type Broker struct {
@@ -62,6 +62,11 @@ func (_m *Broker) PrintInfoBuffer(info []string) {
 	_m.Called(info)
 }
 
+// PrintPluginInfoBuffer provides a mock function with given fields: pluginID, info
+func (_m *Broker) PrintPluginInfoBuffer(pluginID string, info []string) {
+	_m.Called(pluginID, info)
+}
+
 // PrintPlan provides a mock function with given fields: metas
 func (_m *Broker) PrintPlan(metas []model.PluginMeta) {
 	_m.Called(metas)
@@ -72,9 +77,9 @@ func (_m *Broker) PubDone(tooling string) {
 	_m.Called(tooling)
 }
 
-// PubFailed provides a mock function with given fields: err
-func (_m *Broker) PubFailed(err string) {
-	_m.Called(err)
+// PubFailed provides a mock function with given fields: err, details
+func (_m *Broker) PubFailed(err string, details *model.ErrorDetails) {
+	_m.Called(err, details)
 }
 
 // PubLog provides a mock function with given fields: text
@@ -82,19 +87,29 @@ func (_m *Broker) PubLog(text string) {
 	_m.Called(text)
 }
 
+// PubPluginProgress provides a mock function with given fields: pluginID, phase, bytesDownloaded, bytesTotal
+func (_m *Broker) PubPluginProgress(pluginID string, phase model.PluginPhase, bytesDownloaded int64, bytesTotal int64) {
+	_m.Called(pluginID, phase, bytesDownloaded, bytesTotal)
+}
+
 // PubStarted provides a mock function with given fields:
 func (_m *Broker) PubStarted() {
 	_m.Called()
 }
 
-// PushEvents provides a mock function with given fields: tun, types
-func (_m *Broker) PushEvents(tun *jsonrpc.Tunnel, types ...string) {
+// PushEvents provides a mock function with given fields: sink, types
+func (_m *Broker) PushEvents(sink common.EventSink, types ...string) {
 	_va := make([]interface{}, len(types))
 	for _i := range types {
 		_va[_i] = types[_i]
 	}
 	var _ca []interface{}
-	_ca = append(_ca, tun)
+	_ca = append(_ca, sink)
 	_ca = append(_ca, _va...)
 	_m.Called(_ca...)
 }
+
+// RegisterSecrets provides a mock function with given fields: metas
+func (_m *Broker) RegisterSecrets(metas []model.PluginMeta) {
+	_m.Called(metas)
+}
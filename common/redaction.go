@@ -0,0 +1,171 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/che-plugin-broker/cfg"
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+// redactedValue replaces masked secrets
+const redactedValue = "xxxxx"
+
+// minSecretLength is the minimal length of masked env var values. Shorter values,
+// e.g. 'true', are too likely to occur in unrelated text.
+const minSecretLength = 6
+
+var (
+	// userinfoPattern matches scheme and userinfo of URLs, e.g. 'https://user:password@'
+	userinfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#@\s"']+@`)
+	// queryParamPattern matches query parameters of URLs, also in JSON where '&' is escaped
+	queryParamPattern = regexp.MustCompile(`(\?|&|;|\\u0026)([^=&#;?\s"'\\]+)=([^&#;\s"'\\]*)`)
+)
+
+// redactor masks secrets in everything printed and published by brokers
+var redactor = NewRedactor(cfg.SecretEnvNames, cfg.SecretQueryParams)
+
+// Redactor masks secrets in text: userinfo of URLs, values of secret URL query parameters
+// and registered secret values, such as values of secret env vars of plugins
+type Redactor struct {
+	mutex       sync.RWMutex
+	envNames    []string
+	queryParams map[string]bool
+	secrets     []string
+}
+
+// NewRedactor creates a Redactor that masks values of env vars with names matching
+// any of comma-separated, case-insensitive patterns envNames and values of query
+// parameters with any of comma-separated names queryParams
+func NewRedactor(envNames string, queryParams string) *Redactor {
+	r := &Redactor{queryParams: make(map[string]bool)}
+	for _, name := range strings.Split(envNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			r.envNames = append(r.envNames, strings.ToUpper(name))
+		}
+	}
+	for _, param := range strings.Split(queryParams, ",") {
+		if param = strings.TrimSpace(param); param != "" {
+			r.queryParams[strings.ToLower(param)] = true
+		}
+	}
+	return r
+}
+
+// AddSecret masks value in all subsequently redacted text, also when it is escaped in JSON
+func (r *Redactor) AddSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	variants := []string{value}
+	if escaped, err := json.Marshal(value); err == nil {
+		if escaped := string(escaped[1 : len(escaped)-1]); escaped != value {
+			variants = append(variants, escaped)
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, variant := range variants {
+		if !r.isSecret(variant) {
+			r.secrets = append(r.secrets, variant)
+		}
+	}
+	// Longer secrets first, so that secrets containing other secrets are masked entirely
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+func (r *Redactor) isSecret(value string) bool {
+	for _, secret := range r.secrets {
+		if secret == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AddEnvSecrets masks values of env vars whose names match configured secret env var names
+func (r *Redactor) AddEnvSecrets(env []model.EnvVar) {
+	for _, envVar := range env {
+		if r.isSecretEnv(envVar.Name) {
+			r.AddSecret(envVar.Value)
+		}
+	}
+}
+
+func (r *Redactor) isSecretEnv(name string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range r.envNames {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// AddMetaSecrets masks values of secret env vars of containers, init containers and
+// workspace env of metas
+func (r *Redactor) AddMetaSecrets(metas []model.PluginMeta) {
+	for _, meta := range metas {
+		r.AddEnvSecrets(meta.Spec.WorkspaceEnv)
+		for _, containers := range [][]model.Container{meta.Spec.Containers, meta.Spec.InitContainers} {
+			for _, container := range containers {
+				r.AddEnvSecrets(container.Env)
+			}
+		}
+	}
+}
+
+// Redact returns text with all known secrets masked
+func (r *Redactor) Redact(text string) string {
+	text = userinfoPattern.ReplaceAllString(text, "${1}"+redactedValue+"@")
+	text = queryParamPattern.ReplaceAllStringFunc(text, func(param string) string {
+		groups := queryParamPattern.FindStringSubmatch(param)
+		if !r.queryParams[strings.ToLower(groups[2])] || groups[3] == "" {
+			return param
+		}
+		return groups[1] + groups[2] + "=" + redactedValue
+	})
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+	return text
+}
+
+// Redact returns text with all secrets known to the brokers masked, for output that is
+// not printed or published as an event, e.g. metrics reports
+func Redact(text string) string {
+	return redactor.Redact(text)
+}
+
+// redactingWriter masks secrets in everything written to out
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
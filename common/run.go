@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"context"
+
+	"github.com/eclipse/che-plugin-broker/cfg"
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+	"github.com/eclipse/che-plugin-broker/utils/mergeplugins"
+)
+
+// Fail publishes err as the reason of the failure of the broker run and reports
+// metrics of the run. If ctx is done, the failure is reported as cancellation of
+// the run instead.
+func Fail(ctx context.Context, broker Broker, ioUtil utils.IoUtil, metrics *utils.Metrics, err error) error {
+	if cancelErr := CancellationError(ctx, err); cancelErr != nil {
+		err = cancelErr
+	}
+	broker.PubFailed(err.Error(), utils.GetErrorDetails(err))
+	broker.PubLog(err.Error())
+	ReportMetrics(broker, ioUtil, metrics, model.StatusFailed)
+	return err
+}
+
+// ReportMetrics prints the summary of the broker run and, if configured,
+// writes it as JSON report and Prometheus textfile
+func ReportMetrics(broker Broker, ioUtil utils.IoUtil, metrics *utils.Metrics, status model.BrokerStatus) {
+	report := metrics.Finish(status)
+	broker.PrintInfoBuffer(report.Summary())
+	if cfg.MetricsReportPath != "" {
+		reportJSON, err := report.JSON()
+		if err == nil {
+			err = ioUtil.WriteFile(cfg.MetricsReportPath, reportJSON)
+		}
+		if err != nil {
+			broker.PrintInfo("WARN: Failed to write metrics report: %s", err)
+		}
+	}
+	if cfg.MetricsTextfilePath != "" {
+		if err := utils.WritePrometheusTextfile(cfg.MetricsTextfilePath, report); err != nil {
+			broker.PrintInfo("WARN: Failed to write Prometheus metrics textfile: %s", err)
+		}
+	}
+}
+
+// ResolvePluginMetas retrieves metas of plugins and of the plugins they depend on,
+// publishing RESOLVING progress of each of them. If retrieving fails, each of them is
+// reported as failed.
+func ResolvePluginMetas(ctx context.Context, broker Broker, ioUtil utils.IoUtil, plugins []model.PluginFQN, defaultRegistries []string) ([]model.PluginMeta, error) {
+	var resolving []string
+	onResolving := func(progressID string) {
+		resolving = append(resolving, progressID)
+		broker.PubPluginProgress(progressID, model.PhaseResolving, 0, 0)
+	}
+	for _, plugin := range plugins {
+		onResolving(utils.GetPluginFQNID(plugin))
+	}
+	metas, err := utils.GetPluginMetas(ctx, plugins, defaultRegistries, ioUtil, onResolving)
+	if err != nil {
+		for _, progressID := range resolving {
+			broker.PubPluginProgress(progressID, model.PhaseFailed, 0, 0)
+		}
+		return nil, err
+	}
+	return metas, nil
+}
+
+// WarnDependencies reports problems found while resolving dependencies of metas
+func WarnDependencies(broker Broker, metas []model.PluginMeta) {
+	for _, meta := range metas {
+		for _, warning := range meta.Warnings {
+			broker.PrintInfo("WARN: %s", warning)
+		}
+	}
+}
+
+// CheckPolicy fails if any of metas is not allowed by the policy, if a policy is configured
+func CheckPolicy(ioUtil utils.IoUtil, metas []model.PluginMeta) error {
+	if cfg.PolicyFilePath == "" {
+		return nil
+	}
+	policy, err := utils.LoadPolicy(cfg.PolicyFilePath, ioUtil)
+	if err != nil {
+		return err
+	}
+	return policy.Check(metas...)
+}
+
+// ConfigureSignatureVerification returns ioUtil that verifies signatures of plugin metas
+// and extensions, if a signature policy is configured, or ioUtil itself otherwise
+func ConfigureSignatureVerification(ioUtil utils.IoUtil) (utils.IoUtil, error) {
+	policy := utils.SignaturePolicy(cfg.SignaturePolicy)
+	if policy == "" || policy == utils.SignaturePolicyOff {
+		return ioUtil, nil
+	}
+	keys, err := utils.LoadTrustedKeys(cfg.TrustedKeysPath, ioUtil)
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewVerifyingIoUtil(ioUtil, keys, policy), nil
+}
+
+// MergePlugins merges metas with compatible images, if merging of plugins is configured,
+// and prints how they were merged. The merge report is also written as JSON, if configured.
+func MergePlugins(broker Broker, ioUtil utils.IoUtil, metrics *utils.Metrics, metas []model.PluginMeta) ([]model.PluginMeta, error) {
+	if !cfg.MergePlugins {
+		return metas, nil
+	}
+	options, err := mergeOptions(ioUtil)
+	if err != nil {
+		return nil, err
+	}
+	merged := metrics.StartPhase(utils.PhaseMerge, "")
+	mergedMetas, report := mergeplugins.MergePlugins(metas, options)
+	merged(0)
+	stats := report.Stats()
+	metrics.AddMergeStats(stats.Successes, stats.Failures)
+	broker.PrintInfoBuffer(report.Text())
+	writeMergeReport(broker, ioUtil, report)
+	return mergedMetas, nil
+}
+
+// mergeOptions creates options of merging plugins from the broker configuration
+func mergeOptions(ioUtil utils.IoUtil) (mergeplugins.MergeOptions, error) {
+	matchers, err := mergeplugins.NewImageMatchers(cfg.MergeImageMatching, cfg.MergeCompatibilityFilePath, ioUtil)
+	if err != nil {
+		return mergeplugins.MergeOptions{}, err
+	}
+	resources, err := mergeplugins.ParseResourcePolicy(cfg.MergeResourcePolicy, cfg.MergeResourceCeiling)
+	if err != nil {
+		return mergeplugins.MergeOptions{}, err
+	}
+	return mergeplugins.MergeOptions{Matchers: matchers, Resources: resources}, nil
+}
+
+// writeMergeReport writes report as JSON, if configured
+func writeMergeReport(broker Broker, ioUtil utils.IoUtil, report mergeplugins.MergeReport) {
+	if cfg.MergeReportPath == "" {
+		return
+	}
+	reportJSON, err := report.JSON()
+	if err == nil {
+		err = ioUtil.WriteFile(cfg.MergeReportPath, reportJSON)
+	}
+	if err != nil {
+		broker.PrintInfo("WARN: Failed to write merge report: %s", err)
+	}
+}
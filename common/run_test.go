@@ -0,0 +1,39 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/cfg"
+	"github.com/eclipse/che-plugin-broker/utils"
+	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFailWritesMetricsReport(t *testing.T) {
+	cfg.MetricsReportPath = "/metrics.json"
+	defer func() { cfg.MetricsReportPath = "" }()
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("WriteFile", "/metrics.json", mock.Anything).Return(nil)
+
+	err := Fail(context.Background(), NewBroker(), ioUtil, utils.NewMetrics("test", Redact), errors.New("test error"))
+
+	assert.EqualError(t, err, "test error")
+	ioUtil.AssertExpectations(t)
+	report := ioUtil.Calls[0].Arguments.Get(1).([]byte)
+	assert.Contains(t, string(report), `"status": "FAILED"`)
+}
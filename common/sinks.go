@@ -0,0 +1,199 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eclipse/che-go-jsonrpc/event"
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+const (
+	webhookTimeout    = 30 * time.Second
+	webhookRetryDelay = time.Second
+	webhookBufferSize = 1000
+)
+
+// EventSink is a consumer of broker events that must be closed once
+// the broker is done publishing events.
+type EventSink interface {
+	event.Consumer
+	Close()
+}
+
+// eventEntry is the JSON representation of an event written by event sinks
+type eventEntry struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Event     event.E   `json:"event"`
+}
+
+// marshalEvent marshals e to JSON. Secrets in the tooling of success events are masked,
+// since only Che master, which receives events through the push tunnel, needs the actual
+// values of env vars.
+func marshalEvent(e event.E) ([]byte, error) {
+	if success, ok := e.(*model.SuccessEvent); ok {
+		redacted := *success
+		redacted.Tooling = redactor.Redact(redacted.Tooling)
+		e = &redacted
+	}
+	return json.Marshal(&eventEntry{
+		Type:      e.Type(),
+		Timestamp: time.Now().UTC(),
+		Event:     e,
+	})
+}
+
+// FileSink writes events to a file, one JSON object per line
+type FileSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileSink creates a FileSink writing to the file at path. The file is created
+// if it does not exist and truncated otherwise.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create events file: %s", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Accept writes event to the file
+func (sink *FileSink) Accept(e event.E) {
+	line, err := marshalEvent(e)
+	if err != nil {
+		log.Printf("Failed to marshal event of type '%s': %s", e.Type(), err)
+		return
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.file == nil {
+		return
+	}
+	if _, err := sink.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write event of type '%s' to file '%s': %s", e.Type(), sink.file.Name(), err)
+	}
+}
+
+// Close closes the file
+func (sink *FileSink) Close() {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.file == nil {
+		return
+	}
+	if err := sink.file.Close(); err != nil {
+		log.Printf("Failed to close events file '%s': %s", sink.file.Name(), err)
+	}
+	sink.file = nil
+}
+
+// WebhookSink sends each event as a JSON object in the body of a POST request to
+// a webhook URL. Events are sent in order by a background goroutine; failed
+// requests are retried with increasing delay before the event is dropped.
+type WebhookSink struct {
+	url        string
+	retries    int
+	retryDelay time.Duration
+	httpClient *http.Client
+
+	queue chan event.E
+	done  chan struct{}
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewWebhookSink creates a WebhookSink for url that retries each failed
+// request up to retries times.
+func NewWebhookSink(url string, retries int) *WebhookSink {
+	sink := &WebhookSink{
+		url:        url,
+		retries:    retries,
+		retryDelay: webhookRetryDelay,
+		httpClient: &http.Client{Timeout: webhookTimeout},
+		queue:      make(chan event.E, webhookBufferSize),
+		done:       make(chan struct{}),
+	}
+	go sink.deliver()
+	return sink
+}
+
+// Accept queues event to be sent to the webhook
+func (sink *WebhookSink) Accept(e event.E) {
+	sink.mutex.RLock()
+	defer sink.mutex.RUnlock()
+	if sink.closed {
+		return
+	}
+	sink.queue <- e
+}
+
+// Close waits until all queued events are sent
+func (sink *WebhookSink) Close() {
+	sink.mutex.Lock()
+	if sink.closed {
+		sink.mutex.Unlock()
+		return
+	}
+	sink.closed = true
+	close(sink.queue)
+	sink.mutex.Unlock()
+	<-sink.done
+}
+
+func (sink *WebhookSink) deliver() {
+	defer close(sink.done)
+	for e := range sink.queue {
+		body, err := marshalEvent(e)
+		if err != nil {
+			log.Printf("Failed to marshal event of type '%s': %s", e.Type(), err)
+			continue
+		}
+		delay := sink.retryDelay
+		for attempt := 0; ; attempt++ {
+			err = sink.post(body)
+			if err == nil {
+				break
+			}
+			if attempt >= sink.retries {
+				log.Printf("Failed to send event of type '%s' to webhook '%s': %s", e.Type(), sink.url, err)
+				break
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (sink *WebhookSink) post(body []byte) error {
+	resp, err := sink.httpClient.Post(sink.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSinkWritesEventsAsJSONLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.json")
+
+	sink, err := NewFileSink(path)
+	assert.NoError(t, err)
+	sink.Accept(&model.StartedEvent{Status: model.StatusStarted})
+	sink.Accept(logEvent("message"))
+	sink.Close()
+	// Events accepted after closing are ignored
+	sink.Accept(logEvent("ignored"))
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Regexp(t, `^\{"type":"broker/statusChanged","timestamp":".*","event":\{"status":"STARTED",.*\}\}$`, lines[0])
+		assert.Regexp(t, `^\{"type":"broker/log","timestamp":".*","event":\{.*"text":"message"\}\}$`, lines[1])
+	}
+}
+
+func TestNewFileSinkFailsOnInvalidPath(t *testing.T) {
+	_, err := NewFileSink("/non/existent/dir/events.json")
+	assert.Error(t, err)
+}
+
+func TestWebhookSinkRetriesFailedRequests(t *testing.T) {
+	var mutex sync.Mutex
+	var texts []string
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		entry := struct {
+			Type  string                     `json:"type"`
+			Event model.PluginBrokerLogEvent `json:"event"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			t.Errorf("Failed to decode request body: %s", err)
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, model.BrokerLogEventType, entry.Type)
+		texts = append(texts, entry.Event.Text)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, 3)
+	sink.retryDelay = time.Millisecond
+	sink.Accept(logEvent("first"))
+	sink.Accept(logEvent("second"))
+	sink.Close()
+
+	assert.Equal(t, []string{"first", "second"}, texts)
+	assert.Equal(t, 3, requests)
+}
+
+func TestWebhookSinkDropsEventAfterRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, 2)
+	sink.retryDelay = time.Millisecond
+	sink.Accept(logEvent("first"))
+	sink.Close()
+
+	assert.Equal(t, 3, requests)
+}
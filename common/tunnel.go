@@ -0,0 +1,223 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	jsonrpc "github.com/eclipse/che-go-jsonrpc"
+	"github.com/eclipse/che-go-jsonrpc/event"
+	"github.com/eclipse/che-go-jsonrpc/jsonrpcws"
+)
+
+const (
+	initialReconnectDelay = 250 * time.Millisecond
+	maxReconnectDelay     = 10 * time.Second
+)
+
+// PushTunnel delivers broker events to the push endpoint of Che master.
+// Accepted events are stored in a bounded queue and written in order by a background
+// goroutine. An event counts as delivered only once it is written to the connection;
+// if the write fails or the connection is lost, the tunnel reconnects with exponential
+// backoff and writes the event again. Delivery is abandoned only when the endpoint stays
+// unreachable for longer than the configured timeout.
+type PushTunnel struct {
+	endpoint string
+	token    string
+	timeout  time.Duration
+
+	// dial establishes a new connection to the endpoint
+	dial func(endpoint string, token string) (jsonrpc.NativeConn, error)
+	// onGiveUp is called once when delivery is abandoned
+	onGiveUp func(err error)
+
+	conn    jsonrpc.NativeConn
+	queue   chan event.E
+	closing chan struct{}
+	done    chan struct{}
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+// NewPushTunnel creates a PushTunnel that buffers up to bufferSize events and gives up
+// when endpoint has been unreachable for longer than timeout. onGiveUp is called with
+// the reason when the tunnel gives up, so that the broker run can fail, since Che
+// master can no longer be informed about its progress.
+func NewPushTunnel(endpoint string, token string, bufferSize int, timeout time.Duration, onGiveUp func(err error)) *PushTunnel {
+	return newPushTunnel(endpoint, token, bufferSize, timeout, dialConn, onGiveUp)
+}
+
+func newPushTunnel(
+	endpoint string,
+	token string,
+	bufferSize int,
+	timeout time.Duration,
+	dial func(string, string) (jsonrpc.NativeConn, error),
+	onGiveUp func(error)) *PushTunnel {
+
+	tun := &PushTunnel{
+		endpoint: endpoint,
+		token:    token,
+		timeout:  timeout,
+		dial:     dial,
+		onGiveUp: onGiveUp,
+		queue:    make(chan event.E, bufferSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go tun.deliver()
+	return tun
+}
+
+func dialConn(endpoint string, token string) (jsonrpc.NativeConn, error) {
+	return jsonrpcws.Dial(endpoint, token)
+}
+
+// Accept queues event for delivery. If the queue is full, Accept blocks until
+// there is room for the event or the tunnel is closed.
+func (tun *PushTunnel) Accept(e event.E) {
+	tun.mutex.Lock()
+	closed := tun.closed
+	tun.mutex.Unlock()
+	if !closed {
+		select {
+		case tun.queue <- e:
+			return
+		case <-tun.closing:
+		}
+	}
+	log.Printf("Event of type '%s' is dropped since push tunnel is closed", e.Type())
+}
+
+// Close stops accepting events, waits until queued events are delivered (or
+// delivery is abandoned) and closes the underlying connection.
+func (tun *PushTunnel) Close() {
+	tun.mutex.Lock()
+	if tun.closed {
+		tun.mutex.Unlock()
+		return
+	}
+	tun.closed = true
+	close(tun.closing)
+	tun.mutex.Unlock()
+
+	<-tun.done
+	if tun.conn != nil {
+		tun.conn.Close()
+	}
+}
+
+func (tun *PushTunnel) deliver() {
+	defer close(tun.done)
+	gaveUp := false
+	deliverEvent := func(e event.E) {
+		// Once delivery is abandoned, remaining events are discarded so that Accept
+		// never blocks
+		if gaveUp {
+			return
+		}
+		if err := tun.send(e); err != nil {
+			gaveUp = true
+			tun.onGiveUp(err)
+		}
+	}
+	for {
+		select {
+		case e := <-tun.queue:
+			deliverEvent(e)
+		case <-tun.closing:
+			// Deliver events accepted before Close
+			for {
+				select {
+				case e := <-tun.queue:
+					deliverEvent(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send delivers a single event, reconnecting as many times as needed.
+// Returns an error if the endpoint stays unreachable longer than the timeout.
+func (tun *PushTunnel) send(e event.E) error {
+	message, err := marshalNotification(e)
+	if err != nil {
+		log.Printf("Couldn't marshal event of type '%s', dropping it: %s", e.Type(), err)
+		return nil
+	}
+	var failingSince time.Time
+	delay := initialReconnectDelay
+	for {
+		if tun.conn == nil {
+			conn, err := tun.dial(tun.endpoint, tun.token)
+			if err != nil {
+				log.Printf("Couldn't connect to endpoint '%s', due to error '%s'", tun.endpoint, err)
+			} else {
+				tun.conn = conn
+				go watchConn(conn)
+			}
+		}
+		if tun.conn != nil {
+			err := tun.conn.Write(message)
+			if err == nil {
+				return nil
+			}
+			log.Printf("Failed to send event of type '%s' to endpoint '%s': %s", e.Type(), tun.endpoint, err)
+			tun.conn.Close()
+			tun.conn = nil
+		}
+
+		if failingSince.IsZero() {
+			failingSince = time.Now()
+		}
+		if time.Since(failingSince) >= tun.timeout {
+			return fmt.Errorf("endpoint '%s' is unreachable for more than %s, giving up on pushing events", tun.endpoint, tun.timeout)
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// watchConn reads and discards messages from conn and closes it when the endpoint closes
+// the connection, so that subsequent writes fail instead of being lost
+func watchConn(conn jsonrpc.NativeConn) {
+	for {
+		if _, err := conn.Next(); err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// marshalNotification marshals e as JSON-RPC notification named by the type of e
+func marshalNotification(e event.E) ([]byte, error) {
+	params, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&jsonrpc.Request{
+		Version: jsonrpc.DefaultVersion,
+		Method:  e.Type(),
+		Params:  params,
+	})
+}
@@ -0,0 +1,182 @@
+//
+// Copyright (c) 2018-2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	jsonrpc "github.com/eclipse/che-go-jsonrpc"
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn is a jsonrpc.NativeConn that records texts of log events written to it
+type fakeConn struct {
+	mutex    sync.Mutex
+	texts    []string
+	written  chan struct{}
+	closed   chan struct{}
+	isClosed bool
+	// failWrites is the number of writes that fail before writes succeed
+	failWrites int
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{written: make(chan struct{}, 100), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) Write(body []byte) error {
+	request := struct {
+		Params model.PluginBrokerLogEvent `json:"params"`
+	}{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	if c.isClosed || c.failWrites > 0 {
+		c.failWrites--
+		c.mutex.Unlock()
+		return errors.New("broken pipe")
+	}
+	c.texts = append(c.texts, request.Params.Text)
+	c.mutex.Unlock()
+	c.written <- struct{}{}
+	return nil
+}
+
+func (c *fakeConn) Next() ([]byte, error) {
+	<-c.closed
+	return nil, jsonrpc.NewCloseError(errors.New("closed"))
+}
+
+func (c *fakeConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.isClosed {
+		c.isClosed = true
+		close(c.closed)
+	}
+	return nil
+}
+
+func (c *fakeConn) Texts() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string{}, c.texts...)
+}
+
+// fakeDialer returns connections from conns in order, failing while conns[i] is nil
+type fakeDialer struct {
+	conns []*fakeConn
+	calls int
+}
+
+func (d *fakeDialer) dial(endpoint string, token string) (jsonrpc.NativeConn, error) {
+	conn := d.conns[d.calls]
+	if d.calls < len(d.conns)-1 {
+		d.calls++
+	}
+	if conn == nil {
+		return nil, errors.New("connection refused")
+	}
+	return conn, nil
+}
+
+func logEvent(text string) *model.PluginBrokerLogEvent {
+	return &model.PluginBrokerLogEvent{Text: text}
+}
+
+func TestPushTunnelDeliversEventsAfterFailedConnect(t *testing.T) {
+	conn := newFakeConn()
+	dialer := &fakeDialer{conns: []*fakeConn{nil, nil, conn}}
+	tunnel := newPushTunnel("ws://test", "", 10, time.Minute, dialer.dial, func(err error) {
+		t.Errorf("Unexpected give up: %s", err)
+	})
+
+	tunnel.Accept(logEvent("first"))
+	tunnel.Accept(logEvent("second"))
+	tunnel.Accept(logEvent("third"))
+	tunnel.Close()
+
+	assert.Equal(t, []string{"first", "second", "third"}, conn.Texts())
+}
+
+func TestPushTunnelReplaysEventsAfterConnectionLoss(t *testing.T) {
+	firstConn := newFakeConn()
+	secondConn := newFakeConn()
+	dialer := &fakeDialer{conns: []*fakeConn{firstConn, nil, secondConn}}
+	tunnel := newPushTunnel("ws://test", "", 10, time.Minute, dialer.dial, func(err error) {
+		t.Errorf("Unexpected give up: %s", err)
+	})
+
+	tunnel.Accept(logEvent("first"))
+	<-firstConn.written
+	// Simulate master going away
+	firstConn.Close()
+	tunnel.Accept(logEvent("second"))
+	tunnel.Accept(logEvent("third"))
+	tunnel.Close()
+
+	assert.Equal(t, []string{"first"}, firstConn.Texts())
+	assert.Equal(t, []string{"second", "third"}, secondConn.Texts())
+}
+
+func TestPushTunnelResendsEventAfterFailedWrite(t *testing.T) {
+	firstConn := newFakeConn()
+	firstConn.failWrites = 1
+	secondConn := newFakeConn()
+	dialer := &fakeDialer{conns: []*fakeConn{firstConn, secondConn}}
+	tunnel := newPushTunnel("ws://test", "", 10, time.Minute, dialer.dial, func(err error) {
+		t.Errorf("Unexpected give up: %s", err)
+	})
+
+	tunnel.Accept(logEvent("first"))
+	tunnel.Accept(logEvent("second"))
+	tunnel.Close()
+
+	assert.Empty(t, firstConn.Texts())
+	assert.Equal(t, []string{"first", "second"}, secondConn.Texts())
+}
+
+func TestPushTunnelGivesUpAfterTimeout(t *testing.T) {
+	dialer := &fakeDialer{conns: []*fakeConn{nil}}
+	var giveUpErr error
+	tunnel := newPushTunnel("ws://test", "", 1, 100*time.Millisecond, dialer.dial, func(err error) {
+		giveUpErr = err
+	})
+
+	tunnel.Accept(logEvent("first"))
+	tunnel.Accept(logEvent("second"))
+	tunnel.Accept(logEvent("third"))
+	tunnel.Close()
+
+	assert.EqualError(t, giveUpErr, "endpoint 'ws://test' is unreachable for more than 100ms, giving up on pushing events")
+}
+
+func TestPushTunnelDropsEventsAfterClose(t *testing.T) {
+	conn := newFakeConn()
+	dialer := &fakeDialer{conns: []*fakeConn{conn}}
+	tunnel := newPushTunnel("ws://test", "", 10, time.Minute, dialer.dial, func(err error) {
+		t.Errorf("Unexpected give up: %s", err)
+	})
+
+	tunnel.Accept(logEvent("first"))
+	tunnel.Close()
+	tunnel.Accept(logEvent("second"))
+
+	assert.Equal(t, []string{"first"}, conn.Texts())
+}
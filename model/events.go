@@ -31,6 +31,25 @@ const (
 	BrokerResultEventType = "broker/result"
 
 	BrokerLogEventType = "broker/log"
+
+	BrokerPluginProgressEventType = "broker/pluginProgress"
+)
+
+type PluginPhase string
+
+// Phases of plugin brokering reported in plugin progress events
+const (
+	PhaseResolving PluginPhase = "RESOLVING"
+
+	PhaseValidating PluginPhase = "VALIDATING"
+
+	PhaseDownloading PluginPhase = "DOWNLOADING"
+
+	PhaseInstalling PluginPhase = "INSTALLING"
+
+	PhaseDone PluginPhase = "DONE"
+
+	PhaseFailed PluginPhase = "FAILED"
 )
 
 type StartedEvent struct {
@@ -45,6 +64,70 @@ type ErrorEvent struct {
 	Status    BrokerStatus `json:"status" yaml:"status"`
 	RuntimeID RuntimeID    `json:"runtimeId" yaml:"runtimeId"`
 	Error     string       `json:"error" yaml:"error"`
+
+	// Details is a machine-readable description of the error, if available.
+	Details *ErrorDetails `json:"details,omitempty" yaml:"details,omitempty"`
+}
+
+type ErrorCode string
+
+// Codes of errors reported in ErrorEvent details
+const (
+	// ErrorCodeInvalidConfig the broker configuration or the list of requested plugins is invalid
+	ErrorCodeInvalidConfig ErrorCode = "INVALID_CONFIG"
+
+	// ErrorCodeRegistryUnreachable the plugin registry could not be reached
+	ErrorCodeRegistryUnreachable ErrorCode = "REGISTRY_UNREACHABLE"
+
+	// ErrorCodePluginNotFound the plugin registry has no meta.yaml for a plugin
+	ErrorCodePluginNotFound ErrorCode = "PLUGIN_NOT_FOUND"
+
+	// ErrorCodeRegistryError the plugin registry responded with an unexpected status
+	ErrorCodeRegistryError ErrorCode = "REGISTRY_ERROR"
+
+	// ErrorCodeInvalidMeta a plugin meta.yaml is malformed or does not pass validation
+	ErrorCodeInvalidMeta ErrorCode = "INVALID_META"
+
+	// ErrorCodeDownloadFailed a plugin extension could not be downloaded
+	ErrorCodeDownloadFailed ErrorCode = "DOWNLOAD_FAILED"
+
+	// ErrorCodeTimeout the broker did not finish before its deadline
+	ErrorCodeTimeout ErrorCode = "TIMEOUT"
+
+	// ErrorCodeCancelled the broker was cancelled, e.g. because it received SIGTERM
+	ErrorCodeCancelled ErrorCode = "CANCELLED"
+
+	// ErrorCodePolicyViolation a plugin is not allowed by the plugin policy
+	ErrorCodePolicyViolation ErrorCode = "POLICY_VIOLATION"
+
+	// ErrorCodeSignatureInvalid a plugin meta.yaml or extension is unsigned or its signature
+	// does not match any trusted key
+	ErrorCodeSignatureInvalid ErrorCode = "SIGNATURE_INVALID"
+
+	// ErrorCodeWorkspaceConflict plugins of the workspace clash, e.g. on endpoint names or
+	// exposed ports, and the conflict is configured to fail the broker
+	ErrorCodeWorkspaceConflict ErrorCode = "WORKSPACE_CONFLICT"
+)
+
+// ErrorDetails describes a brokering failure in a way that can be processed by Che server.
+type ErrorDetails struct {
+	Code ErrorCode `json:"code" yaml:"code"`
+
+	// PluginID is the ID of the plugin that caused the failure, if any.
+	PluginID string `json:"pluginId,omitempty" yaml:"pluginId,omitempty"`
+
+	// URL is the URL the broker failed to access, if any.
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+
+	// HTTPStatus is the status code of the failed response, if any.
+	HTTPStatus int `json:"httpStatus,omitempty" yaml:"httpStatus,omitempty"`
+
+	// Rule is the name of the policy rule that was violated, if any, e.g. 'images.deny[0]'.
+	Rule string `json:"rule,omitempty" yaml:"rule,omitempty"`
+
+	// Retryable is true if the failure is likely to be transient, so that
+	// starting the workspace again may succeed.
+	Retryable bool `json:"retryable" yaml:"retryable"`
 }
 
 // Type returns BrokerStatusEventType.
@@ -72,3 +155,29 @@ type PluginBrokerLogEvent struct {
 
 // Type returns BrokerLogEventType.
 func (e *PluginBrokerLogEvent) Type() string { return BrokerLogEventType }
+
+// PluginProgressEvent reports progress of brokering of a single plugin.
+type PluginProgressEvent struct {
+	RuntimeID RuntimeID `json:"runtimeId" yaml:"runtimeId"`
+
+	// PluginID identifies the plugin as it was requested: its ID, or its reference URL if it
+	// is requested by reference only. It stays the same in all events of the plugin, also
+	// when a version range is resolved or the plugin is merged with other plugins.
+	PluginID string `json:"pluginId" yaml:"pluginId"`
+
+	Phase PluginPhase `json:"phase" yaml:"phase"`
+
+	// BytesDownloaded is the number of bytes of the extension currently being downloaded
+	// that have been received so far. Set only in phase DOWNLOADING.
+	BytesDownloaded int64 `json:"bytesDownloaded" yaml:"bytesDownloaded"`
+
+	// BytesTotal is the size of the extension currently being downloaded, or -1 if the
+	// size is unknown until the download completes. Set only in phase DOWNLOADING.
+	BytesTotal int64 `json:"bytesTotal" yaml:"bytesTotal"`
+
+	// Time when this event occurred.
+	Time time.Time `json:"time" yaml:"time"`
+}
+
+// Type returns BrokerPluginProgressEventType.
+func (e *PluginProgressEvent) Type() string { return BrokerPluginProgressEventType }
@@ -47,8 +47,62 @@ type PluginMeta struct {
 	Title string `json:"title" yaml:"title"`
 
 	Icon string `json:"icon" yaml:"icon"`
+
+	// Dependencies lists plugins required by this plugin, as plugin IDs, e.g.
+	// 'publisher/name/version', plugin IDs prefixed with registry URL, or URLs of meta.yaml files
+	Dependencies []string `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+
+	// RequiredBy lists IDs of plugins that caused this plugin to be added to the
+	// workspace as a dependency. It is empty for explicitly requested plugins.
+	RequiredBy []string `json:"-" yaml:"-"`
+
+	// Warnings are problems found while resolving dependencies of the plugin, e.g. cycles
+	// or versions of required plugins different from the included ones
+	Warnings []string `json:"-" yaml:"-"`
+
+	// RequestedID is the plugin ID with version range, e.g. 'publisher/name/latest',
+	// that was resolved to ID. It is empty when plugin was requested with exact version.
+	RequestedID string `json:"-" yaml:"-"`
+
+	// ProgressID identifies the plugin in plugin progress events: the ID or reference URL
+	// it was requested with, which is known before its meta.yaml is fetched.
+	ProgressID string `json:"-" yaml:"-"`
+
+	// Source is the location this meta was retrieved from, which relative extension paths
+	// are resolved against: the URL of the plugin registry that served it, or the directory
+	// of its reference URL, which is a file:// URL for local plugin bundles.
+	Source string `json:"-" yaml:"-"`
+
+	// Signature is the result of verification of the signature of the meta.yaml. It is
+	// empty when signatures are not verified.
+	Signature SignatureStatus `json:"-" yaml:"-"`
+
+	// MergedPlugins lists plugins merged into this plugin to share a single container,
+	// in order of their extensions. It is empty for plugins that are not merged.
+	MergedPlugins []MergedPlugin `json:"-" yaml:"-"`
 }
 
+// MergedPlugin identifies one of plugins merged into a plugin that runs them in a single container
+type MergedPlugin struct {
+	ID      string `json:"id" yaml:"id"`
+	Version string `json:"version" yaml:"version"`
+	// ProgressID identifies the merged plugin in plugin progress events
+	ProgressID string `json:"-" yaml:"-"`
+}
+
+// SignatureStatus is the result of verification of the detached signature of a meta.yaml
+// or an extension archive
+type SignatureStatus string
+
+const (
+	// SignatureVerified the file is signed by one of the trusted keys
+	SignatureVerified SignatureStatus = "verified"
+	// SignatureUnsigned no signature of the file was found
+	SignatureUnsigned SignatureStatus = "unsigned"
+	// SignatureInvalid the signature of the file is malformed or does not match any trusted key
+	SignatureInvalid SignatureStatus = "invalid"
+)
+
 type PluginMetaSpec struct {
 	Endpoints      []Endpoint  `json:"endpoints" yaml:"endpoints"`
 	Containers     []Container `json:"containers" yaml:"containers"`
@@ -131,6 +185,10 @@ type ChePlugin struct {
 	InitContainers []Container `json:"initContainers" yaml:"initContainers"`
 	WorkspaceEnv   []EnvVar    `json:"workspaceEnv" yaml:"workspaceEnv"`
 	Type           string      `json:"type" yaml:"type"`
+	// MergedPlugins lists plugins that run in the single container of this plugin, if it
+	// is a result of merging plugins. ID, Name, Publisher and Version are those of the first
+	// merged plugin.
+	MergedPlugins []MergedPlugin `json:"mergedPlugins,omitempty" yaml:"mergedPlugins,omitempty"`
 }
 
 // CachedPlugin represents an "installed" plugin on the filesystem.
@@ -140,8 +198,16 @@ type ChePlugin struct {
 // been downloaded.
 type CachedPlugin struct {
 	ID               string            `json:"pluginId" yaml:"pluginId"`
+	RequestedID      string            `json:"requestedId,omitempty" yaml:"requestedId,omitempty"`
 	IsRemote         bool              `json:"isRemote" yaml:"isRemote"`
 	CachedExtensions map[string]string `json:"cachedExtensions" yaml:"cachedExtensions"`
+	// Signature is the result of verification of the signature of the plugin meta.yaml
+	Signature SignatureStatus `json:"signature,omitempty" yaml:"signature,omitempty"`
+	// ExtensionSignatures are results of verification of signatures of extensions, by extension URL
+	ExtensionSignatures map[string]SignatureStatus `json:"extensionSignatures,omitempty" yaml:"extensionSignatures,omitempty"`
+	// ProgressIDs identify the plugin in plugin progress events. A merged plugin is reported
+	// as each of the plugins merged into it.
+	ProgressIDs []string `json:"-" yaml:"-"`
 }
 
 // InstalledPluginJSON represents the JSON object to be stored when tracking
@@ -150,4 +216,14 @@ type CachedPlugin struct {
 type InstalledPluginJSON struct {
 	Version string         `json:"version" yaml:"version"`
 	Plugins []CachedPlugin `json:"plugins" yaml:"plugins"`
+	// SharedExtensions lists extensions installed once for several plugins, when
+	// extensions are de-duplicated
+	SharedExtensions []SharedExtension `json:"sharedExtensions,omitempty" yaml:"sharedExtensions,omitempty"`
+}
+
+// SharedExtension is an extension downloaded once and shared by plugins that embed it
+type SharedExtension struct {
+	URL string `json:"url" yaml:"url"`
+	// Plugins are IDs of plugins that reference the extension
+	Plugins []string `json:"plugins" yaml:"plugins"`
 }
@@ -0,0 +1,225 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// HostCredentials are credentials used for requests to hosts matching Host, which
+// is a pattern as accepted by path.Match, e.g. '*.registry.io' or 'registry.io:8443'.
+// Exactly one of Token, Username and Password, or Netrc must be specified.
+// Credentials are only sent over HTTPS, unless Insecure is set.
+type HostCredentials struct {
+	Host string `yaml:"host"`
+	// Token is sent as bearer token in the Authorization header
+	Token string `yaml:"token,omitempty"`
+	// Username and Password are sent using basic authentication
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Netrc is the path to a netrc file that provides login and password for the
+	// machine that is requested, using basic authentication
+	Netrc string `yaml:"netrc,omitempty"`
+	// Insecure allows sending credentials over plain HTTP
+	Insecure bool `yaml:"insecure,omitempty"`
+
+	netrc map[string]netrcEntry
+}
+
+// String describes credentials without revealing any secrets
+func (c HostCredentials) String() string {
+	switch {
+	case c.Token != "":
+		return fmt.Sprintf("%s (bearer token)", c.Host)
+	case c.Netrc != "":
+		return fmt.Sprintf("%s (netrc file %s)", c.Host, c.Netrc)
+	default:
+		return fmt.Sprintf("%s (basic auth for user %s)", c.Host, c.Username)
+	}
+}
+
+// Credentials is an ordered list of credentials for plugin registries and servers that
+// host plugin artifacts. The first entry that matches the host of a request is used.
+type Credentials []HostCredentials
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// netrcDefault is the key of the netrc entry that is used for any machine
+const netrcDefault = ""
+
+// LoadCredentials reads credentials from the YAML file at path. Nil credentials are
+// returned if path is empty. Errors never contain contents of the files, since those
+// could reveal secrets.
+func LoadCredentials(path string) (Credentials, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file '%s': %s", path, err)
+	}
+	var credentials Credentials
+	if err := yaml.UnmarshalStrict(data, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file '%s': expected a list of entries with "+
+			"'host' and either 'token', 'username' and 'password', or 'netrc'", path)
+	}
+	for i := range credentials {
+		if err := credentials[i].init(); err != nil {
+			return nil, fmt.Errorf("invalid entry %d of credentials file '%s': %s", i+1, path, err)
+		}
+	}
+	return credentials, nil
+}
+
+func (c *HostCredentials) init() error {
+	if c.Host == "" {
+		return fmt.Errorf("host is not specified")
+	}
+	if _, err := path.Match(c.Host, ""); err != nil {
+		return fmt.Errorf("host pattern '%s' is malformed", c.Host)
+	}
+	kinds := 0
+	if c.Token != "" {
+		kinds++
+	}
+	if c.Username != "" || c.Password != "" {
+		kinds++
+	}
+	if c.Netrc != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return fmt.Errorf("exactly one of 'token', 'username' and 'password', or 'netrc' must be specified for host '%s'", c.Host)
+	}
+	if c.Netrc != "" {
+		data, err := ioutil.ReadFile(c.Netrc)
+		if err != nil {
+			return fmt.Errorf("failed to read netrc file '%s': %s", c.Netrc, err)
+		}
+		c.netrc = parseNetrc(data)
+	}
+	return nil
+}
+
+// parseNetrc parses machine and default entries of a netrc file. Macro definitions
+// and unknown tokens are skipped.
+func parseNetrc(data []byte) map[string]netrcEntry {
+	entries := map[string]netrcEntry{}
+	var machine *string
+	var entry netrcEntry
+	flush := func() {
+		if machine != nil {
+			entries[*machine] = entry
+		}
+		machine, entry = nil, netrcEntry{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// Macro definition ends with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				flush()
+				name := value
+				machine = &name
+				i++
+			case "default":
+				flush()
+				name := netrcDefault
+				machine = &name
+			case "login":
+				entry.login = value
+				i++
+			case "password":
+				entry.password = value
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	flush()
+	return entries
+}
+
+// find returns credentials for the host of URL, with or without port, or nil if none match
+func (c Credentials) find(URL *url.URL) *HostCredentials {
+	for i := range c {
+		if matched, _ := path.Match(c[i].Host, URL.Host); matched {
+			return &c[i]
+		}
+		if matched, _ := path.Match(c[i].Host, URL.Hostname()); matched {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+// apply returns req with authorization for its host, if any credentials match and
+// the request uses HTTPS or the credentials are insecure. The request is copied rather
+// than modified, and an existing Authorization header is kept.
+func (c Credentials) apply(req *http.Request) *http.Request {
+	if len(c) == 0 || req.Header.Get("Authorization") != "" {
+		return req
+	}
+	credentials := c.find(req.URL)
+	if credentials == nil || (req.URL.Scheme != "https" && !credentials.Insecure) {
+		return req
+	}
+	switch {
+	case credentials.Token != "":
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+credentials.Token)
+	case credentials.netrc != nil:
+		entry, ok := credentials.netrc[req.URL.Hostname()]
+		if !ok {
+			entry, ok = credentials.netrc[netrcDefault]
+		}
+		if !ok {
+			return req
+		}
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(entry.login, entry.password)
+	default:
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	return req
+}
@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTempFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	netrc := writeTempFile(t, dir, ".netrc", `
+machine artifacts.io login netrc-user password netrc-secret
+macdef init
+  machine ignored.io login ignored password ignored
+
+default
+  login default-user
+  password default-secret
+`)
+	path := writeTempFile(t, dir, "credentials.yaml", `
+- host: "*.registry.io"
+  token: token-secret
+- host: registry.io:8443
+  username: user
+  password: basic-secret
+- host: "*"
+  netrc: `+netrc+`
+`)
+
+	credentials, err := LoadCredentials(path)
+
+	assert.NoError(t, err)
+	if assert.Len(t, credentials, 3) {
+		assert.Equal(t, "*.registry.io (bearer token)", credentials[0].String())
+		assert.Equal(t, "registry.io:8443 (basic auth for user user)", credentials[1].String())
+		assert.Equal(t, map[string]netrcEntry{
+			"artifacts.io": {login: "netrc-user", password: "netrc-secret"},
+			netrcDefault:   {login: "default-user", password: "default-secret"},
+		}, credentials[2].netrc)
+	}
+}
+
+func TestLoadCredentialsReturnsNilWithoutPath(t *testing.T) {
+	credentials, err := LoadCredentials("")
+
+	assert.NoError(t, err)
+	assert.Nil(t, credentials)
+}
+
+func TestLoadCredentialsErrorsDoNotRevealSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "Malformed file",
+			content: "host: registry.io\ntoken: [token-secret",
+			wantErr: "failed to parse credentials file",
+		},
+		{
+			name:    "Unknown field",
+			content: "- host: registry.io\n  tokn: token-secret",
+			wantErr: "failed to parse credentials file",
+		},
+		{
+			name:    "Missing host",
+			content: "- token: token-secret",
+			wantErr: "invalid entry 1 of credentials file '.*': host is not specified",
+		},
+		{
+			name:    "Several kinds of credentials",
+			content: "- host: registry.io\n  token: token-secret\n  password: token-secret",
+			wantErr: "invalid entry 1 of credentials file '.*': exactly one of",
+		},
+		{
+			name:    "Missing netrc file",
+			content: "- host: registry.io\n  netrc: " + filepath.Join(dir, "missing"),
+			wantErr: "failed to read netrc file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTempFile(t, dir, "credentials.yaml", tt.content)
+
+			_, err := LoadCredentials(path)
+
+			if assert.Error(t, err) {
+				assert.Regexp(t, tt.wantErr, err.Error())
+				assert.NotContains(t, err.Error(), "token-secret")
+			}
+		})
+	}
+}
+
+func TestCredentialsApply(t *testing.T) {
+	credentials := Credentials{
+		{Host: "*.registry.io", Token: "token"},
+		{Host: "registry.io:8443", Username: "user", Password: "password"},
+		{Host: "insecure.io", Token: "insecure-token", Insecure: true},
+		{Host: "*", netrc: map[string]netrcEntry{"artifacts.io": {login: "netrc-user", password: "netrc-password"}}},
+	}
+	tests := []struct {
+		name      string
+		URL       string
+		header    string
+		wantToken string
+		wantUser  string
+		wantPass  string
+	}{
+		{name: "Bearer token for host pattern", URL: "https://plugins.registry.io/index.json", wantToken: "Bearer token"},
+		{name: "Basic auth for host with port", URL: "https://registry.io:8443/index.json", wantUser: "user", wantPass: "password"},
+		{name: "Netrc machine", URL: "https://artifacts.io/test.vsix", wantUser: "netrc-user", wantPass: "netrc-password"},
+		{name: "No matching netrc machine", URL: "https://registry.io/index.json"},
+		{name: "No credentials over plain HTTP", URL: "http://plugins.registry.io/index.json"},
+		{name: "Insecure credentials over plain HTTP", URL: "http://insecure.io/index.json", wantToken: "Bearer insecure-token"},
+		{name: "Existing authorization is kept", URL: "https://plugins.registry.io/index.json", header: "Bearer other", wantToken: "Bearer other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.URL, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			got := credentials.apply(req)
+
+			user, pass, isBasic := got.BasicAuth()
+			switch {
+			case tt.wantUser != "":
+				assert.True(t, isBasic)
+				assert.Equal(t, tt.wantUser, user)
+				assert.Equal(t, tt.wantPass, pass)
+			case tt.wantToken != "":
+				assert.Equal(t, tt.wantToken, got.Header.Get("Authorization"))
+			default:
+				assert.Empty(t, got.Header.Get("Authorization"))
+			}
+			if tt.header == "" {
+				assert.Empty(t, req.Header.Get("Authorization"), "original request must not be modified")
+			}
+		})
+	}
+}
+
+func TestIoUtil_FetchAppliesCredentialsOnlyToMatchingHosts(t *testing.T) {
+	var otherAuth string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherAuth = r.Header.Get("Authorization")
+		w.Write([]byte(expectedResponseBody))
+	}))
+	defer other.Close()
+	var registryAuth string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryAuth = r.Header.Get("Authorization")
+		http.Redirect(w, r, other.URL+"/meta.yaml", http.StatusFound)
+	}))
+	defer registry.Close()
+	util := New(0, Credentials{{Host: strings.TrimPrefix(registry.URL, "http://"), Token: "secret", Insecure: true}}, "")
+
+	actual, err := util.Fetch(context.Background(), registry.URL+"/meta.yaml")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(expectedResponseBody), actual)
+	assert.Equal(t, "Bearer secret", registryAuth)
+	assert.Empty(t, otherAuth)
+}
@@ -13,9 +13,13 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+
+	"github.com/eclipse/che-plugin-broker/model"
 )
 
 type HTTPError struct {
@@ -42,3 +46,60 @@ func NewHTTPError(resp *http.Response, errMsg string) *HTTPError {
 		errMsg:     errMsg,
 	}
 }
+
+// BrokerError is an error that carries machine-readable details about a brokering
+// failure, which are published to Che master along with the error message.
+type BrokerError struct {
+	Details model.ErrorDetails
+	errMsg  string
+	cause   error
+}
+
+func (e *BrokerError) Error() string {
+	return e.errMsg
+}
+
+func (e *BrokerError) Unwrap() error {
+	return e.cause
+}
+
+// NewBrokerError creates a BrokerError with the given details and formatted message.
+func NewBrokerError(details model.ErrorDetails, format string, v ...interface{}) *BrokerError {
+	return &BrokerError{
+		Details: details,
+		errMsg:  fmt.Sprintf(format, v...),
+	}
+}
+
+// NewRequestError creates a BrokerError for a failed request to URL. If cause is
+// an HTTPError, its status code is recorded and the error is considered retryable only
+// for server-side failures; errors without any response are always retryable.
+func NewRequestError(code model.ErrorCode, pluginID string, URL string, cause error, errMsg string) *BrokerError {
+	details := model.ErrorDetails{
+		Code:      code,
+		PluginID:  pluginID,
+		URL:       URL,
+		Retryable: true,
+	}
+	var httpErr *HTTPError
+	if errors.As(cause, &httpErr) {
+		details.HTTPStatus = httpErr.StatusCode
+		details.Retryable = httpErr.StatusCode >= http.StatusInternalServerError || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	return &BrokerError{
+		Details: details,
+		errMsg:  errMsg,
+		cause:   cause,
+	}
+}
+
+// GetErrorDetails returns the details of the first BrokerError in the chain of err,
+// or nil if there is no BrokerError in the chain.
+func GetErrorDetails(err error) *model.ErrorDetails {
+	var brokerErr *BrokerError
+	if errors.As(err, &brokerErr) {
+		details := brokerErr.Details
+		return &details
+	}
+	return nil
+}
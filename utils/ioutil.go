@@ -17,6 +17,7 @@ import (
 	"archive/zip"
 	"bufio"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -28,12 +29,15 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type IoUtil interface {
-	Download(URL string, destPath string, useContentDisposition bool) (string, error)
+	Download(ctx context.Context, URL string, destPath string, useContentDisposition bool, progress func(downloaded int64, total int64)) (string, error)
 	CopyResource(src string, dest string) error
 	CopyFile(src string, dest string) error
+	LinkFile(src string, dest string) error
 	ResolveDestPath(filePath string, destDir string) string
 	ResolveDestPathFromURL(url string, destDir string) string
 	TempDir(string, string) (string, error)
@@ -41,7 +45,7 @@ type IoUtil interface {
 	Unzip(arch string, dest string) error
 	Untar(tarPath string, dest string) error
 	CreateFile(file string, tr io.Reader) error
-	Fetch(url string) ([]byte, error)
+	Fetch(ctx context.Context, url string) ([]byte, error)
 	GetFilesByGlob(glob string) ([]string, error)
 	RemoveAll(path string) error
 	ReadFile(path string) ([]byte, error)
@@ -52,20 +56,153 @@ type IoUtil interface {
 
 type impl struct {
 	httpClient *http.Client
+	// requestTimeout is how long to wait for the response to a request and for each
+	// chunk of the response body
+	requestTimeout time.Duration
 }
 
-// New creates an instance of IoUtil using the default http client.
-func New() IoUtil {
+// New creates an instance of IoUtil using an http client that fails requests when
+// no response, or no data of the response body, is received within requestTimeout.
+// Slow downloads that keep progressing are not interrupted; their overall duration is
+// limited by the context of the request. Zero requestTimeout means no timeout.
+// Requests to hosts that match credentials are authenticated; credentials can be nil.
+// Besides HTTP(S) URLs, the client reads file:// URLs of local plugin bundles in the
+// absolute bundleDir. Reading local files is disabled if bundleDir is empty.
+func New(requestTimeout time.Duration, credentials Credentials, bundleDir string) IoUtil {
+	t := &transport{credentials: credentials}
+	if bundleDir != "" {
+		t.bundleDir = filepath.Clean(bundleDir)
+		t.file = http.NewFileTransport(http.Dir(t.bundleDir))
+	}
 	return &impl{
-		httpClient: http.DefaultClient,
+		requestTimeout: requestTimeout,
+		httpClient:     &http.Client{Transport: t},
+	}
+}
+
+// transport serves file:// URLs from the bundle directory and delegates other requests
+// to http.DefaultTransport, which is looked up on each request so that it picks up
+// trusted certificates configured after the client is created. Credentials are applied
+// to each request separately, so that they are not sent to other hosts on redirects.
+type transport struct {
+	bundleDir   string
+	file        http.RoundTripper
+	credentials Credentials
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "file" {
+		return t.roundTripFile(req)
+	}
+	return http.DefaultTransport.RoundTrip(t.credentials.apply(req))
+}
+
+// roundTripFile reads the local file of req, which must be in the bundle directory
+func (t *transport) roundTripFile(req *http.Request) (*http.Response, error) {
+	if t.file == nil {
+		return nil, fmt.Errorf("reading local file %s is not allowed, no bundle directory is configured", req.URL.Path)
+	}
+	rel, err := filepath.Rel(t.bundleDir, filepath.FromSlash(path.Clean("/"+req.URL.Path)))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("local file %s is outside of bundle directory %s", req.URL.Path, t.bundleDir)
+	}
+	bundleReq := req.Clone(req.Context())
+	bundleReq.URL.Path = "/" + filepath.ToSlash(rel)
+	bundleReq.URL.RawPath = ""
+	return t.file.RoundTrip(bundleReq)
+}
+
+// get sends a GET request to URL. The request is cancelled when no response, or no
+// data of the response body, is received within the request timeout.
+func (util *impl) get(ctx context.Context, URL string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	idle := &idleTimeout{timeout: util.requestTimeout, cancel: cancel}
+	idle.start()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+	if err != nil {
+		idle.stop()
+		return nil, err
+	}
+	resp, err := util.httpClient.Do(req)
+	if err != nil {
+		idle.stop()
+		if idle.expired() {
+			return nil, fmt.Errorf("no response from %s within %s", URL, util.requestTimeout)
+		}
+		return nil, err
+	}
+	resp.Body = &idleTimeoutBody{body: resp.Body, idle: idle}
+	return resp, nil
+}
+
+// idleTimeout cancels a request when it does not progress within timeout
+type idleTimeout struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	fired   int32
+}
+
+func (t *idleTimeout) start() {
+	if t.timeout > 0 {
+		t.timer = time.AfterFunc(t.timeout, func() {
+			atomic.StoreInt32(&t.fired, 1)
+			t.cancel()
+		})
 	}
 }
 
+// progress restarts the timeout
+func (t *idleTimeout) progress() {
+	if t.timer != nil && !t.expired() {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *idleTimeout) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.cancel()
+}
+
+func (t *idleTimeout) expired() bool {
+	return atomic.LoadInt32(&t.fired) == 1
+}
+
+// idleTimeoutBody is a response body that restarts the idle timeout of the request
+// each time data is received
+type idleTimeoutBody struct {
+	body io.ReadCloser
+	idle *idleTimeout
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.idle.progress()
+	}
+	if err != nil && err != io.EOF && b.idle.expired() {
+		err = fmt.Errorf("no data received within %s: %w", b.idle.timeout, err)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.idle.stop()
+	return b.body.Close()
+}
+
 // Download downloads file by provided URL and places its content to provided destPath.
 // Returns error in a case of any problems.
 // Returns HTTPError if downloading is caused by non 2xx response from a service accessed by URL
-func (util *impl) Download(URL string, destPath string, useContentDisposition bool) (string, error) {
-	resp, err := util.httpClient.Get(URL)
+// If progress is not nil, it is called each time a chunk of the file is received with the
+// number of bytes received so far and the total size of the file, which is -1 if unknown.
+// If the size is unknown, progress is called once more with the real size when the
+// download completes.
+// Downloading is aborted when ctx is done.
+func (util *impl) Download(ctx context.Context, URL string, destPath string, useContentDisposition bool, progress func(downloaded int64, total int64)) (string, error) {
+	resp, err := util.get(ctx, URL)
 	if err != nil {
 		return "", err
 	}
@@ -113,7 +250,11 @@ func (util *impl) Download(URL string, destPath string, useContentDisposition bo
 	}
 	defer Close(out)
 
-	_, err = io.Copy(out, resp.Body)
+	var body io.Reader = resp.Body
+	if progress != nil {
+		body = &progressReader{reader: resp.Body, total: resp.ContentLength, progress: progress}
+	}
+	_, err = io.Copy(out, body)
 	if err != nil {
 		return "", err
 	}
@@ -121,9 +262,31 @@ func (util *impl) Download(URL string, destPath string, useContentDisposition bo
 	return destPath, out.Sync()
 }
 
+// progressReader reports the number of bytes read from the underlying reader
+type progressReader struct {
+	reader   io.Reader
+	read     int64
+	total    int64
+	progress func(downloaded int64, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.progress(r.read, r.total)
+	}
+	if err == io.EOF && r.total < 0 {
+		r.total = r.read
+		r.progress(r.read, r.total)
+	}
+	return n, err
+}
+
 // Fetch downloads data from URL and returns the bytes in the response.
-func (util *impl) Fetch(URL string) ([]byte, error) {
-	resp, err := util.httpClient.Get(URL)
+// Fetching is aborted when ctx is done.
+func (util *impl) Fetch(ctx context.Context, URL string) ([]byte, error) {
+	resp, err := util.get(ctx, URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get data from %s: %s", URL, err)
 	}
@@ -174,6 +337,11 @@ func (util *impl) CopyFile(src string, dest string) error {
 	return to.Sync()
 }
 
+// LinkFile creates dest as a hardlink of src, so that both share the same content on disk
+func (util *impl) LinkFile(src string, dest string) error {
+	return os.Link(src, dest)
+}
+
 func (util *impl) ResolveDestPath(filePath string, destDir string) string {
 	destName := filepath.Base(filePath)
 	destPath := filepath.Join(destDir, destName)
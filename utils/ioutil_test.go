@@ -13,13 +13,17 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/eclipse/che-plugin-broker/utils/mocks"
 	"github.com/stretchr/testify/assert"
@@ -111,9 +115,9 @@ func TestIoUtil_Fetch(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			util := &impl{
-				mocks.NewTestHTTPClient(tt.mocks.response, tt.mocks.err),
+				httpClient: mocks.NewTestHTTPClient(tt.mocks.response, tt.mocks.err),
 			}
-			actual, err := util.Fetch(tt.args.URL)
+			actual, err := util.Fetch(context.Background(), tt.args.URL)
 			if tt.want.errRegexp != nil {
 				assertErrorMatches(t, tt.want.errRegexp, err)
 				return
@@ -338,9 +342,9 @@ func TestIoUtil_Download(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			util := &impl{
-				mocks.NewTestHTTPClient(tt.mocks.response, tt.mocks.err),
+				httpClient: mocks.NewTestHTTPClient(tt.mocks.response, tt.mocks.err),
 			}
-			actual, err := util.Download(tt.args.URL, filepath.Join(workingDir, "test.url"), tt.args.useContentDisposition)
+			actual, err := util.Download(context.Background(), tt.args.URL, filepath.Join(workingDir, "test.url"), tt.args.useContentDisposition, nil)
 			if tt.want.errRegexp != nil {
 				assertErrorMatches(t, tt.want.errRegexp, err)
 				return
@@ -353,3 +357,177 @@ func TestIoUtil_Download(t *testing.T) {
 		})
 	}
 }
+
+func TestIoUtil_DownloadReportsProgress(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workingDir)
+
+	response, _ := mocks.GenerateResponse(expectedResponseBody, http.StatusOK, http.Header{})
+	response.ContentLength = int64(len(expectedResponseBody))
+	util := &impl{
+		httpClient: mocks.NewTestHTTPClient(response, nil),
+	}
+
+	var downloaded, total int64
+	_, err = util.Download(context.Background(), "test.url", filepath.Join(workingDir, "test.url"), false, func(d int64, t int64) {
+		downloaded, total = d, t
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(expectedResponseBody)), downloaded)
+	assert.Equal(t, int64(len(expectedResponseBody)), total)
+}
+
+func TestIoUtil_DownloadReportsSizeWhenUnknown(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workingDir)
+
+	response, _ := mocks.GenerateResponse(expectedResponseBody, http.StatusOK, http.Header{})
+	response.ContentLength = -1
+	util := &impl{
+		httpClient: mocks.NewTestHTTPClient(response, nil),
+	}
+
+	var calls [][2]int64
+	_, err = util.Download(context.Background(), "test.url", filepath.Join(workingDir, "test.url"), false, func(d int64, t int64) {
+		calls = append(calls, [2]int64{d, t})
+	})
+
+	assert.NoError(t, err)
+	size := int64(len(expectedResponseBody))
+	assert.Equal(t, [2]int64{size, -1}, calls[0])
+	assert.Equal(t, [2]int64{size, size}, calls[len(calls)-1])
+}
+
+func TestIoUtil_FetchIsAbortedWhenContextIsDone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	util := New(0, nil, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := util.Fetch(ctx, server.URL)
+
+	assert.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+}
+
+func TestIoUtil_DownloadFailsAfterRequestTimeout(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workingDir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	util := New(50*time.Millisecond, nil, "")
+
+	_, err = util.Download(context.Background(), server.URL, filepath.Join(workingDir, "test.url"), false, nil)
+
+	assert.Error(t, err)
+}
+
+func TestIoUtil_DownloadFailsWhenStalled(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workingDir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunk"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	util := New(50*time.Millisecond, nil, "")
+
+	_, err = util.Download(context.Background(), server.URL, filepath.Join(workingDir, "test.url"), false, nil)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no data received within 50ms")
+}
+
+func TestIoUtil_DownloadDoesNotTimeOutWhileProgressing(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workingDir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	util := New(100*time.Millisecond, nil, "")
+
+	path, err := util.Download(context.Background(), server.URL, filepath.Join(workingDir, "test.url"), false, nil)
+
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("chunk", 5), string(content))
+}
+
+func TestIoUtil_FetchReadsLocalFiles(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workingDir)
+	filePath := filepath.Join(workingDir, "meta.yaml")
+	if err := ioutil.WriteFile(filePath, []byte(expectedResponseBody), 0644); err != nil {
+		panic(err)
+	}
+	util := New(0, nil, workingDir)
+
+	actual, err := util.Fetch(context.Background(), "file://"+filepath.ToSlash(filePath))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(expectedResponseBody), actual)
+
+	_, err = util.Fetch(context.Background(), "file://"+filepath.ToSlash(filepath.Join(workingDir, "missing.yaml")))
+	if assert.IsType(t, &HTTPError{}, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*HTTPError).StatusCode)
+	}
+}
+
+func TestIoUtil_FetchDoesNotReadFilesOutsideOfBundleDir(t *testing.T) {
+	workingDir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workingDir)
+	bundleDir := filepath.Join(workingDir, "bundles")
+	if err := os.Mkdir(bundleDir, 0755); err != nil {
+		panic(err)
+	}
+	secretPath := filepath.Join(workingDir, "token")
+	if err := ioutil.WriteFile(secretPath, []byte("secret"), 0644); err != nil {
+		panic(err)
+	}
+
+	_, err = New(0, nil, bundleDir).Fetch(context.Background(), "file://"+filepath.ToSlash(secretPath))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is outside of bundle directory")
+
+	_, err = New(0, nil, bundleDir).Fetch(context.Background(), "file://"+filepath.ToSlash(bundleDir)+"/../token")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is outside of bundle directory")
+
+	_, err = New(0, nil, "").Fetch(context.Background(), "file://"+filepath.ToSlash(secretPath))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no bundle directory is configured")
+}
@@ -0,0 +1,23 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+// ListContains checks whether list contains test
+func ListContains(test string, list []string) bool {
+	for _, e := range list {
+		if test == e {
+			return true
+		}
+	}
+	return false
+}
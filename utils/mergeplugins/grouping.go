@@ -0,0 +1,319 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package mergeplugins
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// Names of image matchers accepted by NewImageMatchers
+const (
+	NormalizedMatching = "normalized"
+	DigestMatching     = "digest"
+)
+
+// defaultRegistry is the registry of images that do not specify one
+const defaultRegistry = "docker.io"
+
+// imageRef is a container image reference, e.g. 'quay.io/eclipse/che-sidecar-java:8@sha256:...'
+type imageRef struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseImageRef parses image, filling in the default registry, the 'library/' prefix of
+// official images and the 'latest' tag of images without tag and digest
+func parseImageRef(image string) imageRef {
+	ref := imageRef{registry: defaultRegistry}
+	name := image
+	if idx := strings.Index(name, "@"); idx >= 0 {
+		ref.digest = name[idx+1:]
+		name = name[:idx]
+	}
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		ref.tag = name[idx+1:]
+		name = name[:idx]
+	}
+	if idx := strings.Index(name, "/"); idx >= 0 {
+		if host := name[:idx]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.registry = normalizeRegistry(host)
+			name = name[idx+1:]
+		}
+	}
+	if ref.registry == defaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.repository = name
+	if ref.tag == "" && ref.digest == "" {
+		ref.tag = "latest"
+	}
+	return ref
+}
+
+func normalizeRegistry(host string) string {
+	host = strings.ToLower(host)
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return defaultRegistry
+	}
+	return host
+}
+
+func (r imageRef) name() string {
+	return r.registry + "/" + r.repository
+}
+
+func (r imageRef) String() string {
+	ref := r.name()
+	if r.tag != "" {
+		ref += ":" + r.tag
+	}
+	if r.digest != "" {
+		ref += "@" + r.digest
+	}
+	return ref
+}
+
+// ImageMatcher is a strategy that decides whether plugins with containers of two
+// different images can share a single container
+type ImageMatcher interface {
+	// Match returns whether images a and b are compatible and, if they are, why
+	Match(a string, b string) (bool, string)
+}
+
+// NormalizedImageMatcher considers images compatible if they are the same reference after
+// normalization, e.g. 'eclipse/che-theia' and 'docker.io/eclipse/che-theia:latest'
+type NormalizedImageMatcher struct{}
+
+func (NormalizedImageMatcher) Match(a string, b string) (bool, string) {
+	if refA := parseImageRef(a); refA == parseImageRef(b) {
+		return true, fmt.Sprintf("'%s' and '%s' are the same image %s", a, b, refA)
+	}
+	return false, ""
+}
+
+// DigestImageMatcher considers images of the same repository compatible if they have the
+// same digest, or the same tag and only one of them is pinned to a digest. This way, images
+// 'x:1.0' and 'x@sha256:...' are compatible when another plugin uses 'x:1.0@sha256:...'.
+type DigestImageMatcher struct{}
+
+func (DigestImageMatcher) Match(a string, b string) (bool, string) {
+	return matchDigests(a, b, parseImageRef(a), parseImageRef(b))
+}
+
+func matchDigests(a string, b string, refA imageRef, refB imageRef) (bool, string) {
+	if refA.name() != refB.name() {
+		return false, ""
+	}
+	if refA.digest != "" && refA.digest == refB.digest {
+		return true, fmt.Sprintf("'%s' and '%s' have the same digest %s", a, b, refA.digest)
+	}
+	if refA.tag != "" && refA.tag == refB.tag && (refA.digest == "") != (refB.digest == "") {
+		return true, fmt.Sprintf("'%s' and '%s' have the same tag '%s' and one of them is pinned to a digest", a, b, refA.tag)
+	}
+	return false, ""
+}
+
+// ImageCompatibility explicitly configures compatible images
+type ImageCompatibility struct {
+	// Mirrors maps hosts of registry mirrors to hosts of registries they mirror, e.g.
+	// 'mirror.io': 'quay.io'. Images from a mirror are compatible with the same images
+	// from the mirrored registry.
+	Mirrors map[string]string `yaml:"mirrors,omitempty"`
+	// Images are groups of images that are compatible although their references differ
+	Images [][]string `yaml:"images,omitempty"`
+}
+
+// LoadImageCompatibility reads image compatibility from the YAML file at compatibilityPath
+func LoadImageCompatibility(compatibilityPath string, ioUtil utils.IoUtil) (*ImageCompatibility, error) {
+	data, err := ioUtil.ReadFile(compatibilityPath)
+	if err != nil {
+		return nil, utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+			"failed to read image compatibility file '%s': %s", compatibilityPath, err)
+	}
+	compatibility := &ImageCompatibility{}
+	if err := yaml.UnmarshalStrict(data, compatibility); err != nil {
+		return nil, utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+			"failed to parse image compatibility file '%s': %s", compatibilityPath, err)
+	}
+	return compatibility, nil
+}
+
+func (c *ImageCompatibility) Match(a string, b string) (bool, string) {
+	refA, refB := parseImageRef(a), parseImageRef(b)
+	for i, group := range c.Images {
+		if containsImage(group, refA) && containsImage(group, refB) {
+			return true, fmt.Sprintf("'%s' and '%s' are compatible according to images[%d] of image compatibility", a, b, i)
+		}
+	}
+	mirroredA, mirroredB := c.unmirror(refA), c.unmirror(refB)
+	if mirroredA == refA && mirroredB == refB {
+		return false, ""
+	}
+	if mirroredA == mirroredB {
+		return true, fmt.Sprintf("'%s' and '%s' are the same image %s from a registry mirror", a, b, mirroredA)
+	}
+	if matched, reason := matchDigests(a, b, mirroredA, mirroredB); matched {
+		return true, reason + ", taking registry mirrors into account"
+	}
+	return false, ""
+}
+
+// unmirror returns ref with the registry replaced by the registry it mirrors, if any
+func (c *ImageCompatibility) unmirror(ref imageRef) imageRef {
+	for mirror, registry := range c.Mirrors {
+		if normalizeRegistry(mirror) == ref.registry {
+			ref.registry = normalizeRegistry(registry)
+			return ref
+		}
+	}
+	return ref
+}
+
+func containsImage(images []string, ref imageRef) bool {
+	for _, image := range images {
+		if parseImageRef(image) == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// NewImageMatchers creates matchers with comma-separated names, each of NormalizedMatching
+// and DigestMatching, followed by a matcher using explicit image compatibility from
+// the YAML file at compatibilityPath, if it is set
+func NewImageMatchers(names string, compatibilityPath string, ioUtil utils.IoUtil) ([]ImageMatcher, error) {
+	var matchers []ImageMatcher
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case NormalizedMatching:
+			matchers = append(matchers, NormalizedImageMatcher{})
+		case DigestMatching:
+			matchers = append(matchers, DigestImageMatcher{})
+		default:
+			return nil, utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+				"unknown image matching '%s', expected '%s' or '%s'", strings.TrimSpace(name), NormalizedMatching, DigestMatching)
+		}
+	}
+	if compatibilityPath != "" {
+		compatibility, err := LoadImageCompatibility(compatibilityPath, ioUtil)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, compatibility)
+	}
+	return matchers, nil
+}
+
+// imageGroup is a group of plugins whose images are compatible
+type imageGroup struct {
+	// image is the image of the merged container
+	image   string
+	plugins []model.PluginMeta
+	// reasons explain why the images of the plugins are compatible
+	reasons []string
+}
+
+// groupByImage groups plugins with compatible images, in order of their first plugin.
+// Plugins with the same image are always compatible; otherwise images are compatible if
+// any of matchers matches them, directly or through other images. The image of a group
+// is its first image pinned to a digest, or its first image if none is pinned.
+// Images pinned to different digests are never grouped.
+func groupByImage(plugins []model.PluginMeta, matchers []ImageMatcher) []*imageGroup {
+	var images []string
+	for _, plugin := range plugins {
+		if image := plugin.Spec.Containers[0].Image; indexOf(image, images) < 0 {
+			images = append(images, image)
+		}
+	}
+
+	parents := make([]int, len(images))
+	// digests maps roots of groups to the digest their images are pinned to, if any
+	digests := make([]string, len(images))
+	for i := range parents {
+		parents[i] = i
+		digests[i] = parseImageRef(images[i]).digest
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	type match struct {
+		image  int
+		reason string
+	}
+	var matches []match
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			for _, matcher := range matchers {
+				rootI, rootJ := find(i), find(j)
+				if rootI == rootJ {
+					break
+				}
+				// Matches are transitive, e.g. 'x:1.0@sha256:a' and 'x:1.0@sha256:b' both match
+				// 'x:1.0', but images pinned to different digests must not share a container
+				if digests[rootI] != "" && digests[rootJ] != "" && digests[rootI] != digests[rootJ] {
+					break
+				}
+				if matched, reason := matcher.Match(images[i], images[j]); matched {
+					parents[rootJ] = rootI
+					if digests[rootI] == "" {
+						digests[rootI] = digests[rootJ]
+					}
+					matches = append(matches, match{image: i, reason: reason})
+				}
+			}
+		}
+	}
+
+	var groups []*imageGroup
+	groupsByRoot := map[int]*imageGroup{}
+	for i, image := range images {
+		group, ok := groupsByRoot[find(i)]
+		if !ok {
+			group = &imageGroup{image: image}
+			groupsByRoot[find(i)] = group
+			groups = append(groups, group)
+		} else if parseImageRef(group.image).digest == "" && parseImageRef(image).digest != "" {
+			group.image = image
+		}
+	}
+	for _, plugin := range plugins {
+		group := groupsByRoot[find(indexOf(plugin.Spec.Containers[0].Image, images))]
+		group.plugins = append(group.plugins, plugin)
+	}
+	for _, m := range matches {
+		group := groupsByRoot[find(m.image)]
+		group.reasons = append(group.reasons, m.reason)
+	}
+	return groups
+}
+
+func indexOf(test string, list []string) int {
+	for i, e := range list {
+		if test == e {
+			return i
+		}
+	}
+	return -1
+}
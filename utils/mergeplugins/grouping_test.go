@@ -0,0 +1,198 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package mergeplugins
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDigest      = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	otherTestDigest = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "busybox", want: "docker.io/library/busybox:latest"},
+		{image: "eclipse/che-theia:next", want: "docker.io/eclipse/che-theia:next"},
+		{image: "index.docker.io/eclipse/che-theia", want: "docker.io/eclipse/che-theia:latest"},
+		{image: "Quay.io/eclipse/sidecar:1.0", want: "quay.io/eclipse/sidecar:1.0"},
+		{image: "localhost:5000/sidecar", want: "localhost:5000/sidecar:latest"},
+		{image: "quay.io/eclipse/sidecar@" + testDigest, want: "quay.io/eclipse/sidecar@" + testDigest},
+		{image: "quay.io/eclipse/sidecar:1.0@" + testDigest, want: "quay.io/eclipse/sidecar:1.0@" + testDigest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseImageRef(tt.image).String())
+		})
+	}
+}
+
+func TestImageMatchers(t *testing.T) {
+	compatibility := &ImageCompatibility{
+		Mirrors: map[string]string{"mirror.io": "quay.io"},
+		Images:  [][]string{{"quay.io/eclipse/sidecar:1.0", "quay.io/eclipse/sidecar-ubi:1.0"}},
+	}
+	tests := []struct {
+		name    string
+		matcher ImageMatcher
+		a, b    string
+		want    bool
+	}{
+		{name: "Normalized references", matcher: NormalizedImageMatcher{}, a: "eclipse/theia", b: "docker.io/eclipse/theia:latest", want: true},
+		{name: "Different tags", matcher: NormalizedImageMatcher{}, a: "eclipse/theia:1", b: "eclipse/theia:2"},
+		{name: "Same digest, different tags", matcher: DigestImageMatcher{},
+			a: "quay.io/x/sidecar:1.0@" + testDigest, b: "quay.io/x/sidecar:latest@" + testDigest, want: true},
+		{name: "Tag pinned to digest", matcher: DigestImageMatcher{},
+			a: "quay.io/x/sidecar:1.0", b: "quay.io/x/sidecar:1.0@" + testDigest, want: true},
+		{name: "Tag and unrelated digest", matcher: DigestImageMatcher{},
+			a: "quay.io/x/sidecar:1.0", b: "quay.io/x/sidecar@" + testDigest},
+		{name: "Same digest in other repository", matcher: DigestImageMatcher{},
+			a: "quay.io/x/sidecar@" + testDigest, b: "quay.io/y/sidecar@" + testDigest},
+		{name: "Registry mirror", matcher: compatibility, a: "mirror.io/eclipse/sidecar:1.0", b: "quay.io/eclipse/sidecar:1.0", want: true},
+		{name: "Registry mirror and digest", matcher: compatibility,
+			a: "mirror.io/eclipse/sidecar@" + testDigest, b: "quay.io/eclipse/sidecar:1.0@" + testDigest, want: true},
+		{name: "Compatible images", matcher: compatibility, a: "quay.io/eclipse/sidecar-ubi:1.0", b: "quay.io/eclipse/sidecar:1.0", want: true},
+		{name: "Incompatible images", matcher: compatibility, a: "quay.io/eclipse/sidecar-ubi:1.0", b: "quay.io/eclipse/other:1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := tt.matcher.Match(tt.a, tt.b)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want, reason != "", "Reason should be given only for compatible images")
+		})
+	}
+}
+
+func theiaPlugin(id string, image string) model.PluginMeta {
+	return model.PluginMeta{
+		ID:   id,
+		Name: id,
+		Type: model.TheiaPluginType,
+		Spec: model.PluginMetaSpec{
+			Containers: []model.Container{{Name: id, Image: image}},
+			Extensions: []string{fmt.Sprintf("https://extensions.io/%s.vsix", id)},
+		},
+	}
+}
+
+func TestMergePluginsGroupsCompatibleImages(t *testing.T) {
+	plugins := []model.PluginMeta{
+		theiaPlugin("tagged", "quay.io/x/sidecar:1.0"),
+		theiaPlugin("digest", "quay.io/x/sidecar@"+testDigest),
+		theiaPlugin("other", "quay.io/x/other:1.0"),
+		theiaPlugin("pinned", "quay.io/x/sidecar:1.0@"+testDigest),
+		{ID: "editor", Type: model.EditorPluginType, Spec: model.PluginMetaSpec{Containers: []model.Container{{Image: "quay.io/x/sidecar:1.0"}}}},
+	}
+	multiple := theiaPlugin("multiple", "quay.io/x/sidecar:1.0")
+	multiple.Spec.Containers = append(multiple.Spec.Containers, model.Container{Image: "busybox"})
+	plugins = append(plugins, multiple)
+
+	merged, report := MergePlugins(plugins, MergeOptions{Matchers: []ImageMatcher{NormalizedImageMatcher{}, DigestImageMatcher{}}})
+
+	assert.Equal(t, MergeStats{Successes: 1}, report.Stats())
+	if assert.Len(t, merged, 4) {
+		assert.Equal(t, []string{"editor", "multiple", "other"}, []string{merged[0].ID, merged[1].ID, merged[2].ID})
+		assert.Equal(t, "quay.io/x/sidecar@"+testDigest, merged[3].Spec.Containers[0].Image)
+		assert.Equal(t, []string{
+			"https://extensions.io/tagged.vsix",
+			"https://extensions.io/digest.vsix",
+			"https://extensions.io/pinned.vsix",
+		}, merged[3].Spec.Extensions)
+	}
+	assert.Equal(t, []string{
+		"Plugin multiple is not merged: it has 2 containers instead of 1",
+		"Merging plugins [tagged, digest, pinned] to image quay.io/x/sidecar@" + testDigest,
+		"  Images are compatible: 'quay.io/x/sidecar:1.0' and 'quay.io/x/sidecar:1.0@" + testDigest + "' have the same tag '1.0' and one of them is pinned to a digest",
+		"  Images are compatible: 'quay.io/x/sidecar@" + testDigest + "' and 'quay.io/x/sidecar:1.0@" + testDigest + "' have the same digest " + testDigest,
+		"  Merged container: merged-quay-io-x-sidecar-0123456789ab",
+		"Plugin other is not merged: no other plugin uses an image compatible with quay.io/x/other:1.0",
+	}, report.Text())
+}
+
+func TestMergePluginsDoesNotGroupImagesWithDifferentDigests(t *testing.T) {
+	plugins := []model.PluginMeta{
+		theiaPlugin("first", "quay.io/x/sidecar:1.0@"+testDigest),
+		theiaPlugin("tagged", "quay.io/x/sidecar:1.0"),
+		theiaPlugin("second", "quay.io/x/sidecar:1.0@"+otherTestDigest),
+		theiaPlugin("other", "quay.io/x/sidecar@"+otherTestDigest),
+	}
+
+	merged, report := MergePlugins(plugins, MergeOptions{Matchers: []ImageMatcher{NormalizedImageMatcher{}, DigestImageMatcher{}}})
+
+	assert.Equal(t, MergeStats{Successes: 2}, report.Stats())
+	if assert.Len(t, merged, 2) {
+		assert.Equal(t, "quay.io/x/sidecar:1.0@"+testDigest, merged[0].Spec.Containers[0].Image)
+		assert.Equal(t, []string{"https://extensions.io/first.vsix", "https://extensions.io/tagged.vsix"}, merged[0].Spec.Extensions)
+		assert.Equal(t, "quay.io/x/sidecar:1.0@"+otherTestDigest, merged[1].Spec.Containers[0].Image)
+		assert.Equal(t, []string{"https://extensions.io/second.vsix", "https://extensions.io/other.vsix"}, merged[1].Spec.Extensions)
+	}
+}
+
+func TestMergedContainerNameIsValidLabel(t *testing.T) {
+	assert.Equal(t, "merged-quay-io-x-sidecar-1-0", mergedContainerName("quay.io/x/sidecar:1.0"))
+	assert.Equal(t, "merged-quay-io-x-sidecar-1-0-0123456789ab", mergedContainerName("quay.io/x/sidecar:1.0@"+testDigest))
+
+	long := "registry.example.com/some-organization/some-very-long-repository-name:1.0.0@" + testDigest
+	name := mergedContainerName(long)
+	assert.Len(t, name, 63)
+	assert.Regexp(t, "^merged-registry-example-com-some-organization-.*-[0-9a-f]{8}$", name)
+	assert.NotEqual(t, name, mergedContainerName(strings.Replace(long, "1.0.0", "1.0.1", 1)))
+}
+
+func TestMergePluginsWithoutMatchersMergesOnlyIdenticalImages(t *testing.T) {
+	plugins := []model.PluginMeta{
+		theiaPlugin("first", "eclipse/theia"),
+		theiaPlugin("second", "docker.io/eclipse/theia:latest"),
+		theiaPlugin("third", "eclipse/theia"),
+	}
+
+	merged, report := MergePlugins(plugins, MergeOptions{})
+
+	assert.Equal(t, MergeStats{Successes: 1}, report.Stats())
+	if assert.Len(t, merged, 2) {
+		assert.Equal(t, "second", merged[0].ID)
+		assert.Equal(t, "eclipse/theia", merged[1].Spec.Containers[0].Image)
+	}
+}
+
+func TestNewImageMatchers(t *testing.T) {
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("ReadFile", "/compatibility.yaml").Return([]byte("mirrors:\n  mirror.io: quay.io\n"), nil)
+	ioUtil.On("ReadFile", "/invalid.yaml").Return([]byte("unknown: field\n"), nil)
+
+	matchers, err := NewImageMatchers("normalized, digest", "/compatibility.yaml", ioUtil)
+	assert.NoError(t, err)
+	assert.Equal(t, []ImageMatcher{
+		NormalizedImageMatcher{},
+		DigestImageMatcher{},
+		&ImageCompatibility{Mirrors: map[string]string{"mirror.io": "quay.io"}},
+	}, matchers)
+
+	_, err = NewImageMatchers("exact", "", ioUtil)
+	assert.EqualError(t, err, "unknown image matching 'exact', expected 'normalized' or 'digest'")
+
+	_, err = NewImageMatchers("", "/invalid.yaml", ioUtil)
+	assert.Error(t, err)
+	assert.Equal(t, model.ErrorCodeInvalidConfig, utils.GetErrorDetails(err).Code)
+}
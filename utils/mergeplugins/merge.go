@@ -13,6 +13,8 @@
 package mergeplugins
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
@@ -38,42 +40,103 @@ func newResources() containerResources {
 	return r
 }
 
-// MergePlugins collapses a list of plugins by merging any plugins that share the same container into
-// a single plugin with multiple extensions
-func MergePlugins(plugins []model.PluginMeta) ([]model.PluginMeta, []string) {
+// MergeOptions configure how plugins are merged
+type MergeOptions struct {
+	// Matchers decide whether plugins with different images can be merged. Without matchers,
+	// only plugins with the same image are merged.
+	Matchers []ImageMatcher
+	// Resources determines resources of merged containers
+	Resources ResourcePolicy
+}
+
+// MergeStats counts groups of plugins sharing the same container that were merged
+// and that could not be merged
+type MergeStats struct {
+	Successes int
+	Failures  int
+}
+
+// MergePlugins collapses a list of plugins by merging any plugins that can share the same container
+// into a single plugin with multiple extensions. Plugins can share the container if they use the same
+// image, or images that any of matchers of options considers compatible. The returned report explains
+// why plugins were or were not merged.
+func MergePlugins(plugins []model.PluginMeta, options MergeOptions) ([]model.PluginMeta, MergeReport) {
 	var unmodified []model.PluginMeta
-	var logBuf []string
-	toMerge := map[string][]model.PluginMeta{}
+	var report MergeReport
+	var toMerge []model.PluginMeta
 	for _, plugin := range plugins {
-		if !pluginMergable(plugin) {
+		if reason := mergeBlocker(plugin); reason != "" {
+			if isTheiaOrVscodePlugin(plugin) {
+				report.Unmergeable = append(report.Unmergeable, UnmergeablePlugin{PluginID: plugin.ID, Reason: reason})
+			}
 			unmodified = append(unmodified, plugin)
 			continue
 		}
-		pluginImage := plugin.Spec.Containers[0].Image
-		toMerge[pluginImage] = append(toMerge[pluginImage], plugin)
+		toMerge = append(toMerge, plugin)
 	}
 
 	var merged []model.PluginMeta
-	for image, plugins := range toMerge {
+	for _, group := range groupByImage(toMerge, options.Matchers) {
+		groupReport := GroupReport{Image: group.image, Compatibility: group.reasons}
+		for _, plugin := range group.plugins {
+			groupReport.Plugins = append(groupReport.Plugins, plugin.ID)
+		}
 		// Merging single plugins doesn't make sense
-		if len(plugins) == 1 {
-			unmodified = append(unmodified, plugins...)
+		if len(group.plugins) == 1 {
+			groupReport.Status = GroupSingle
+			report.Groups = append(report.Groups, groupReport)
+			unmodified = append(unmodified, group.plugins...)
 			continue
 		}
-		logBuf = append(logBuf, fmt.Sprintf("Merging plugins [%s] to image %s", formatPluginNames(plugins), image))
-		mergedPlugin, err := mergePluginsForImage(image, plugins)
+		mergedPlugin, err := mergePluginsForImage(group.image, group.plugins, options.Resources)
 		if err != nil {
-			logBuf = append(logBuf, fmt.Sprintf("  Cannot merge plugins: %s", err))
-			unmodified = append(unmodified, plugins...)
+			groupReport.Status = GroupConflict
+			groupReport.Conflict = err.Error()
+			var conflict *mergeConflict
+			if errors.As(err, &conflict) {
+				groupReport.ConflictPlugin = conflict.plugin.ID
+			}
+			report.Groups = append(report.Groups, groupReport)
+			unmodified = append(unmodified, group.plugins...)
 			continue
 		}
+		groupReport.Status = GroupMerged
+		groupReport.Container = mergedPlugin.Spec.Containers[0].Name
+		groupReport.Members = newMemberReports(group.plugins)
+		groupReport.Resources = newResourceTotals(mergedPlugin.Spec.Containers[0])
+		report.Groups = append(report.Groups, groupReport)
 		merged = append(merged, *mergedPlugin)
 	}
 
-	return append(unmodified, merged...), logBuf
+	return append(unmodified, merged...), report
 }
 
-func mergePluginsForImage(image string, plugins []model.PluginMeta) (*model.PluginMeta, error) {
+// maxContainerNameLength is the maximal length of container names, which are DNS-1123 labels
+const maxContainerNameLength = 63
+
+// mergedContainerName returns the name of the container of plugins merged to image. The
+// digest the image is pinned to is shortened to 12 characters, and names that are still
+// too long are truncated and suffixed with a hash of image to keep them unique.
+func mergedContainerName(image string) string {
+	name := image
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		digest := image[idx+1:]
+		digest = digest[strings.Index(digest, ":")+1:]
+		if len(digest) > 12 {
+			digest = digest[:12]
+		}
+		name = image[:idx] + "-" + digest
+	}
+	name = "merged-" + utils.SanitizeImage(name)
+	if len(name) <= maxContainerNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(image))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	return strings.TrimRight(name[:maxContainerNameLength-len(suffix)], "-") + suffix
+}
+
+func mergePluginsForImage(image string, plugins []model.PluginMeta, resourcePolicy ResourcePolicy) (*model.PluginMeta, error) {
 	merged := &model.PluginMeta{
 		APIVersion: "v2",
 		Version:    plugins[0].Version,
@@ -84,47 +147,58 @@ func mergePluginsForImage(image string, plugins []model.PluginMeta) (*model.Plug
 	merged.ID = fmt.Sprintf("%s/%s/%s", merged.Publisher, merged.Name, merged.Version)
 
 	container := model.Container{
-		Name:  "merged-" + utils.SanitizeImage(image),
+		Name:  mergedContainerName(image),
 		Image: image,
 	}
 
-	resources := newResources()
+	sums, maxes := newResources(), newResources()
 
-	for _, plugin := range plugins {
+	for i, plugin := range plugins {
 
 		err := addEnv(merged, plugin)
 		if err != nil {
-			return nil, fmt.Errorf("failed to merge plugins on plugin %s: %w", plugin.Name, err)
+			return nil, &mergeConflict{plugin: plugin, err: err}
 		}
 
 		err = addEndpoints(merged, plugin)
 		if err != nil {
-			return nil, fmt.Errorf("failed to merge plugins on plugin %s: %w", plugin.Name, err)
+			return nil, &mergeConflict{plugin: plugin, err: err}
 		}
 
+		addInitContainers(merged, plugin)
+
 		err = addContainerEnv(&container, plugin.Spec.Containers[0])
 		if err != nil {
-			return nil, fmt.Errorf("failed to merge plugins on plugin %s: %w", plugin.Name, err)
+			return nil, &mergeConflict{plugin: plugin, err: err}
+		}
+
+		err = addContainerCommand(&container, plugin.Spec.Containers[0], i == 0)
+		if err != nil {
+			return nil, &mergeConflict{plugin: plugin, err: err}
 		}
 
-		err = addResources(&resources, plugin.Spec.Containers[0])
+		err = addResources(&sums, plugin.Spec.Containers[0])
+		if err == nil {
+			err = maxResources(&maxes, plugin.Spec.Containers[0])
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to merge plugins on plugin %s: %w", plugin.Name, err)
+			return nil, &mergeConflict{plugin: plugin, err: err}
 		}
 
 		err = addCommands(&container, plugin)
 		if err != nil {
-			return nil, fmt.Errorf("failed to merge plugins on plugin %s: %w", plugin.Name, err)
+			return nil, &mergeConflict{plugin: plugin, err: err}
 		}
 
 		// Note: volumes differing in only their name will still be merged; the first volume will
 		// decide the new volume's name
 		err = addVolumes(&container, plugin)
 		if err != nil {
-			return nil, fmt.Errorf("failed to merge plugins on plugin %s: %w", plugin.Name, err)
+			return nil, &mergeConflict{plugin: plugin, err: err}
 		}
 
 		addExtensions(merged, plugin)
+		merged.MergedPlugins = append(merged.MergedPlugins, model.MergedPlugin{ID: plugin.ID, Version: plugin.Version, ProgressID: plugin.ProgressID})
 
 		// Ports may collide but multiple plugins listening on the same port is already a failure,
 		// even without merging, so we'll let it fail normally
@@ -135,7 +209,12 @@ func mergePluginsForImage(image string, plugins []model.PluginMeta) (*model.Plug
 		}
 	}
 
+	resources, err := resourcePolicy.aggregate(sums, maxes, len(plugins))
+	if err != nil {
+		return nil, err
+	}
 	setContainerResources(&container, resources)
+	container.Lifecycle = mergeLifecycles(plugins)
 	merged.Spec.Containers = []model.Container{container}
 
 	return merged, nil
@@ -168,6 +247,40 @@ func addEndpoints(merged *model.PluginMeta, toMerge model.PluginMeta) error {
 	return nil
 }
 
+// addInitContainers adds init containers of toMerge to merged, skipping init containers
+// identical to already added ones. An init container with the name of a different added
+// init container is renamed by appending the name of its plugin, and a number if needed.
+func addInitContainers(merged *model.PluginMeta, toMerge model.PluginMeta) {
+	for _, initContainer := range toMerge.Spec.InitContainers {
+		merge, clash := initContainerMergeable(initContainer, merged.Spec.InitContainers)
+		if clash {
+			initContainer.Name += "-" + strings.ToLower(utils.SanitizeImage(toMerge.Name))
+			merge, clash = initContainerMergeable(initContainer, merged.Spec.InitContainers)
+		}
+		if clash {
+			initContainer.Name = uniqueInitContainerName(initContainer.Name, merged.Spec.InitContainers)
+			merge = true
+		}
+		if merge {
+			merged.Spec.InitContainers = append(merged.Spec.InitContainers, initContainer)
+		}
+	}
+}
+
+// uniqueInitContainerName returns name, followed by a number if it is already taken by
+// any of initContainers
+func uniqueInitContainerName(name string, initContainers []model.Container) string {
+	taken := map[string]bool{}
+	for _, initContainer := range initContainers {
+		taken[initContainer.Name] = true
+	}
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	return unique
+}
+
 func addContainerEnv(container *model.Container, toMerge model.Container) error {
 	for _, env := range toMerge.Env {
 		merge, fail := envMergeable(env, container.Env)
@@ -181,43 +294,48 @@ func addContainerEnv(container *model.Container, toMerge model.Container) error
 	return nil
 }
 
-func addResources(resources *containerResources, container model.Container) error {
-	if container.MemoryLimit != "" {
-		memLimit, err := resource.ParseQuantity(container.MemoryLimit)
-		if err != nil {
-			return fmt.Errorf("failed to parse memory limit: %w", err)
-		} else {
-			resources.memLimit.Add(memLimit)
+// parseResources parses resources of container; resources that are not set are zero
+func parseResources(container model.Container) (containerResources, error) {
+	resources := newResources()
+	values := []string{container.MemoryLimit, container.MemoryRequest, container.CPULimit, container.CPURequest}
+	for i, quantity := range resources.quantities() {
+		if values[i] == "" {
+			continue
 		}
-	}
-
-	if container.MemoryRequest != "" {
-		memRequest, err := resource.ParseQuantity(container.MemoryRequest)
+		parsed, err := resource.ParseQuantity(values[i])
 		if err != nil {
-			return fmt.Errorf("failed to parse memory request: %w", err)
-		} else {
-			resources.memRequest.Add(memRequest)
+			return containerResources{}, fmt.Errorf("failed to parse %s: %w", resourceNames[i], err)
 		}
+		*quantity = parsed
 	}
+	return resources, nil
+}
 
-	if container.CPULimit != "" {
-		cpuLimit, err := resource.ParseQuantity(container.CPULimit)
-		if err != nil {
-			return fmt.Errorf("failed to parse cpu limit: %w", err)
-		} else {
-			resources.cpuLimit.Add(cpuLimit)
-		}
+func addResources(resources *containerResources, container model.Container) error {
+	parsed, err := parseResources(container)
+	if err != nil {
+		return err
 	}
-
-	if container.CPURequest != "" {
-		cpuRequest, err := resource.ParseQuantity(container.CPURequest)
-		if err != nil {
-			return fmt.Errorf("failed to parse cpu request: %w", err)
-		} else {
-			resources.cpuRequest.Add(cpuRequest)
-		}
+	for i, quantity := range resources.quantities() {
+		quantity.Add(*parsed.quantities()[i])
 	}
+	return nil
+}
 
+// addContainerCommand sets command and args of container to those of toMerge. Merged
+// containers can run only a single entrypoint, so all plugins must have the same.
+func addContainerCommand(container *model.Container, toMerge model.Container, first bool) error {
+	if first {
+		container.Command = toMerge.Command
+		container.Args = toMerge.Args
+		return nil
+	}
+	if !listsEqual(container.Command, toMerge.Command) {
+		return fmt.Errorf("different container command %q and %q", container.Command, toMerge.Command)
+	}
+	if !listsEqual(container.Args, toMerge.Args) {
+		return fmt.Errorf("different container args %q and %q", container.Args, toMerge.Args)
+	}
 	return nil
 }
 
@@ -249,12 +367,76 @@ func addVolumes(container *model.Container, plugin model.PluginMeta) error {
 
 func addExtensions(merged *model.PluginMeta, toMerge model.PluginMeta) {
 	for _, ext := range toMerge.Spec.Extensions {
-		if !listContains(ext, merged.Spec.Extensions) {
+		if !utils.ListContains(ext, merged.Spec.Extensions) {
 			merged.Spec.Extensions = append(merged.Spec.Extensions, ext)
 		}
 	}
 }
 
+// mergeLifecycles combines exec lifecycle hooks of containers of plugins. Identical hooks
+// run once; distinct hooks of the same kind run in order of plugins in a single shell
+// invocation. The combined postStart hook stops at the first failing hook, as a failing
+// hook of a single plugin would, while all preStop hooks run to let each plugin clean up.
+func mergeLifecycles(plugins []model.PluginMeta) *model.Lifecycle {
+	var postStart, preStop [][]string
+	for _, plugin := range plugins {
+		if lifecycle := plugin.Spec.Containers[0].Lifecycle; lifecycle != nil {
+			postStart = appendHook(postStart, lifecycle.PostStart)
+			preStop = appendHook(preStop, lifecycle.PreStop)
+		}
+	}
+	if len(postStart) == 0 && len(preStop) == 0 {
+		return nil
+	}
+	return &model.Lifecycle{
+		PostStart: sequenceHooks(postStart, " && "),
+		PreStop:   sequenceHooks(preStop, "; "),
+	}
+}
+
+func appendHook(hooks [][]string, handler *model.Handler) [][]string {
+	if handler == nil || handler.Exec == nil || len(handler.Exec.Command) == 0 {
+		return hooks
+	}
+	for _, hook := range hooks {
+		if listsEqual(hook, handler.Exec.Command) {
+			return hooks
+		}
+	}
+	return append(hooks, handler.Exec.Command)
+}
+
+// sequenceHooks returns a handler running commands of hooks separated by shell operator separator
+func sequenceHooks(hooks [][]string, separator string) *model.Handler {
+	switch len(hooks) {
+	case 0:
+		return nil
+	case 1:
+		return &model.Handler{Exec: &model.ExecAction{Command: hooks[0]}}
+	}
+	var commands []string
+	for _, hook := range hooks {
+		commands = append(commands, shellQuote(hook))
+	}
+	return &model.Handler{Exec: &model.ExecAction{Command: []string{"/bin/sh", "-c", strings.Join(commands, separator)}}}
+}
+
+// shellQuote returns command as a shell command line, quoting arguments where necessary
+func shellQuote(command []string) string {
+	var quoted []string
+	for _, arg := range command {
+		if arg != "" && strings.Trim(arg, safeShellChars) == "" {
+			quoted = append(quoted, arg)
+		} else {
+			quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// safeShellChars are characters of arguments that do not need quoting in shell
+const safeShellChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-"
+
 func setContainerResources(container *model.Container, resources containerResources) {
 	if !resources.memLimit.IsZero() {
 		container.MemoryLimit = resources.memLimit.String()
@@ -269,20 +451,3 @@ func setContainerResources(container *model.Container, resources containerResour
 		container.CPURequest = resources.cpuRequest.String()
 	}
 }
-
-func formatPluginNames(plugins []model.PluginMeta) string {
-	var names []string
-	for _, plugin := range plugins {
-		names = append(names, plugin.Name)
-	}
-	return strings.Join(names, ", ")
-}
-
-func listContains(test string, list []string) bool {
-	for _, e := range list {
-		if test == e {
-			return true
-		}
-	}
-	return false
-}
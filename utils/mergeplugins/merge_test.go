@@ -31,11 +31,18 @@ type testdata struct {
 	Expected []model.PluginMeta
 }
 
+// expectedMeta is an expected merged meta in testdata files, which also lists merged
+// plugins since they are not read from YAML into model.PluginMeta
+type expectedMeta struct {
+	model.PluginMeta `yaml:",inline"`
+	MergedPlugins    []model.MergedPlugin `yaml:"mergedPlugins"`
+}
+
 func TestMergePluginsIgnoresInvalidPlugins(t *testing.T) {
 	data := loadPluginMetasFromFile(t, "success/ignore_unmergeable_plugins.yaml")
 	inputPlugins := data.Metas
 	expectedPlugins := data.Expected
-	actualPlugins, _ := MergePlugins(inputPlugins)
+	actualPlugins, _ := MergePlugins(inputPlugins, MergeOptions{})
 	assert.Equal(t, expectedPlugins, actualPlugins)
 }
 
@@ -43,7 +50,7 @@ func TestMergePluginsDoesNothingWhenMergeFails(t *testing.T) {
 	data := loadPluginMetasFromFile(t, "success/does_not_modify_unmergeable_plugins.yaml")
 	inputPlugins := data.Metas
 	expectedPlugins := data.Expected
-	actualPlugins, _ := MergePlugins(inputPlugins)
+	actualPlugins, _ := MergePlugins(inputPlugins, MergeOptions{})
 	assert.Equal(t, expectedPlugins, actualPlugins)
 }
 
@@ -98,10 +105,20 @@ func TestMergePluginsForImageFailureModes(t *testing.T) {
 			args:              loadPluginMetasFromFile(t, "failure/conflicting_endpoints.yaml").Metas,
 			expectedErrRegexp: regexp.MustCompile(`conflicting endpoints`),
 		},
+		{
+			name:              "Different container command",
+			args:              loadPluginMetasFromFile(t, "failure/conflicting_container_command.yaml").Metas,
+			expectedErrRegexp: regexp.MustCompile(`different container command \["/entrypoint.sh"\] and \[\]`),
+		},
+		{
+			name:              "Different container args",
+			args:              loadPluginMetasFromFile(t, "failure/conflicting_container_args.yaml").Metas,
+			expectedErrRegexp: regexp.MustCompile(`different container args \["sleep" "infinity"\] and \["tail" "-f" "/dev/null"\]`),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := mergePluginsForImage("testimg", tt.args)
+			_, err := mergePluginsForImage("testimg", tt.args, ResourcePolicy{})
 			if assert.Error(t, err) {
 				assert.Regexp(t, tt.expectedErrRegexp, err.Error(), "Error message should match regex")
 			}
@@ -126,17 +143,38 @@ func TestMergePluginsForImageSuccessfully(t *testing.T) {
 			name:     "Combines volumes with different names if mountpath is same",
 			testdata: loadPluginMetasFromFile(t, "success/combine_volumes_on_mountpath.yaml"),
 		},
+		{
+			name:     "Merges init containers, lifecycle hooks and identical command",
+			testdata: loadPluginMetasFromFile(t, "success/merging_init_containers_and_lifecycle.yaml"),
+		},
+		{
+			name:     "Renames init containers with the same name but different specs",
+			testdata: loadPluginMetasFromFile(t, "success/renaming_conflicting_init_containers.yaml"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			plugImage := tt.testdata.Metas[0].Spec.Containers[0].Image
-			actual, err := mergePluginsForImage(plugImage, tt.testdata.Metas)
+			actual, err := mergePluginsForImage(plugImage, tt.testdata.Metas, ResourcePolicy{})
 			assert.NoError(t, err)
 			assert.Equal(t, tt.testdata.Expected[0], *actual)
 		})
 	}
 }
 
+func TestMergeLifecyclesRunsAllPreStopHooks(t *testing.T) {
+	plugins := []model.PluginMeta{theiaPlugin("a", "testimg"), theiaPlugin("b", "testimg"), theiaPlugin("c", "testimg")}
+	plugins[0].Spec.Containers[0].Lifecycle = &model.Lifecycle{PreStop: &model.Handler{Exec: &model.ExecAction{Command: []string{"rm", "-rf", "/tmp/a b"}}}}
+	plugins[2].Spec.Containers[0].Lifecycle = &model.Lifecycle{PreStop: &model.Handler{Exec: &model.ExecAction{Command: []string{"/stop.sh", ""}}}}
+
+	lifecycle := mergeLifecycles(plugins)
+
+	assert.Equal(t, &model.Lifecycle{
+		PreStop: &model.Handler{Exec: &model.ExecAction{Command: []string{"/bin/sh", "-c", "rm -rf '/tmp/a b'; /stop.sh ''"}}},
+	}, lifecycle)
+	assert.Nil(t, mergeLifecycles(plugins[1:2]))
+}
+
 func TestAddResources(t *testing.T) {
 	type containerResourcesString struct {
 		memLimit   string
@@ -278,7 +316,7 @@ func TestCanBeMerged(t *testing.T) {
 			expected: false,
 		},
 		{
-			name: "Can't be merged if container has command",
+			name: "Can be merged if container has command",
 			args: model.PluginMeta{
 				Type: model.TheiaPluginType,
 				Spec: model.PluginMetaSpec{
@@ -290,10 +328,10 @@ func TestCanBeMerged(t *testing.T) {
 					},
 				},
 			},
-			expected: false,
+			expected: true,
 		},
 		{
-			name: "Can't be merged if container has args",
+			name: "Can be merged if container has args",
 			args: model.PluginMeta{
 				Type: model.TheiaPluginType,
 				Spec: model.PluginMetaSpec{
@@ -305,10 +343,10 @@ func TestCanBeMerged(t *testing.T) {
 					},
 				},
 			},
-			expected: false,
+			expected: true,
 		},
 		{
-			name: "Can't be merged if container has lifecycle",
+			name: "Can be merged if container has lifecycle",
 			args: model.PluginMeta{
 				Type: model.TheiaPluginType,
 				Spec: model.PluginMetaSpec{
@@ -326,10 +364,10 @@ func TestCanBeMerged(t *testing.T) {
 					},
 				},
 			},
-			expected: false,
+			expected: true,
 		},
 		{
-			name: "Can't be merged if plugin has initContainer",
+			name: "Can be merged if plugin has initContainer",
 			args: model.PluginMeta{
 				Type: model.TheiaPluginType,
 				Spec: model.PluginMetaSpec{
@@ -341,7 +379,7 @@ func TestCanBeMerged(t *testing.T) {
 					},
 				},
 			},
-			expected: false,
+			expected: true,
 		},
 	}
 
@@ -360,9 +398,18 @@ func loadPluginMetasFromFile(t *testing.T, metaFile string) testdata {
 	if err != nil {
 		t.Fatal(err)
 	}
-	var input testdata
+	var input struct {
+		Metas    []model.PluginMeta
+		Expected []expectedMeta
+	}
 	if err := yaml.Unmarshal(bytes, &input); err != nil {
 		t.Fatal(err)
 	}
-	return input
+	data := testdata{Metas: input.Metas}
+	for _, expected := range input.Expected {
+		meta := expected.PluginMeta
+		meta.MergedPlugins = expected.MergedPlugins
+		data.Expected = append(data.Expected, meta)
+	}
+	return data
 }
@@ -20,27 +20,36 @@ import (
 )
 
 func pluginMergable(plugin model.PluginMeta) bool {
+	return mergeBlocker(plugin) == ""
+}
+
+func isTheiaOrVscodePlugin(plugin model.PluginMeta) bool {
 	pluginType := strings.ToLower(plugin.Type)
-	if pluginType != model.TheiaPluginType && pluginType != model.VscodePluginType {
-		return false
+	return pluginType == model.TheiaPluginType || pluginType == model.VscodePluginType
+}
+
+// mergeBlocker returns the reason why plugin cannot share a container with other plugins,
+// or an empty string if it can
+func mergeBlocker(plugin model.PluginMeta) string {
+	if !isTheiaOrVscodePlugin(plugin) {
+		return "it is not a Theia or VS Code plugin"
 	}
 	if len(plugin.Spec.Containers) != 1 {
-		return false
-	}
-	container := plugin.Spec.Containers[0]
-	if container.Command != nil || container.Args != nil {
-		return false
-	}
-	if container.Lifecycle != nil {
-		return false
+		return fmt.Sprintf("it has %d containers instead of 1", len(plugin.Spec.Containers))
 	}
+	return ""
+}
 
-	// For now: don't deal with plugins that have initContainers for simplicity
-	if len(plugin.Spec.InitContainers) > 0 {
-		return false
+func initContainerMergeable(initContainer model.Container, list []model.Container) (merge, clash bool) {
+	for _, listContainer := range list {
+		if initContainer.Name == listContainer.Name {
+			if reflect.DeepEqual(initContainer, listContainer) {
+				return false, false
+			}
+			return false, true
+		}
 	}
-
-	return true
+	return true, false
 }
 
 func envMergeable(env model.EnvVar, envList []model.EnvVar) (merge, fail bool) {
@@ -0,0 +1,274 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package mergeplugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+// GroupStatus is the outcome of merging a group of plugins with compatible images
+type GroupStatus string
+
+const (
+	// GroupMerged plugins of the group share a single merged container
+	GroupMerged GroupStatus = "merged"
+	// GroupConflict plugins of the group could not be merged because of a conflict
+	GroupConflict GroupStatus = "conflict"
+	// GroupSingle no other plugin uses an image compatible with the plugin of the group
+	GroupSingle GroupStatus = "single"
+)
+
+// MergeReport explains which plugins were merged and how
+type MergeReport struct {
+	// Unmergeable plugins that cannot share a container with other plugins
+	Unmergeable []UnmergeablePlugin `json:"unmergeable,omitempty"`
+	// Groups of plugins with compatible images, in order of their first plugin
+	Groups []GroupReport `json:"groups,omitempty"`
+}
+
+// UnmergeablePlugin is a plugin that cannot share a container with other plugins
+type UnmergeablePlugin struct {
+	PluginID string `json:"pluginId"`
+	Reason   string `json:"reason"`
+}
+
+// GroupReport explains merging of a group of plugins with compatible images
+type GroupReport struct {
+	// Image of the merged container
+	Image  string      `json:"image"`
+	Status GroupStatus `json:"status"`
+	// Plugins are IDs of plugins of the group
+	Plugins []string `json:"plugins"`
+	// Compatibility explains why different images of plugins of the group are compatible
+	Compatibility []string `json:"compatibility,omitempty"`
+	// Container is the name of the merged container
+	Container string `json:"container,omitempty"`
+	// Members describe what the merged plugin takes from each plugin of the group
+	Members []MemberReport `json:"members,omitempty"`
+	// Resources of the merged container
+	Resources *ResourceTotals `json:"resources,omitempty"`
+	// Conflict is the reason why plugins of the group could not be merged
+	Conflict string `json:"conflict,omitempty"`
+	// ConflictPlugin is the ID of the plugin that conflicts with the other plugins of the group,
+	// if the conflict is caused by a single plugin
+	ConflictPlugin string `json:"conflictPlugin,omitempty"`
+}
+
+// MemberReport lists what the merged plugin takes from one of the merged plugins. Items
+// identical to items of a preceding plugin are listed only for the preceding plugin.
+type MemberReport struct {
+	PluginID     string   `json:"pluginId"`
+	Env          []string `json:"env,omitempty"`
+	WorkspaceEnv []string `json:"workspaceEnv,omitempty"`
+	// Volumes are mount paths of volumes
+	Volumes   []string `json:"volumes,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+	Commands  []string `json:"commands,omitempty"`
+	// InitContainers are names of init containers in the merged plugin, which differ
+	// from the original names of renamed init containers
+	InitContainers []string `json:"initContainers,omitempty"`
+	// Lifecycle are kinds of lifecycle hooks, 'postStart' and 'preStop', that run
+	// commands of the plugin in the merged container
+	Lifecycle []string `json:"lifecycle,omitempty"`
+}
+
+// ResourceTotals are resources of a merged container
+type ResourceTotals struct {
+	MemoryLimit   string `json:"memoryLimit,omitempty"`
+	MemoryRequest string `json:"memoryRequest,omitempty"`
+	CPULimit      string `json:"cpuLimit,omitempty"`
+	CPURequest    string `json:"cpuRequest,omitempty"`
+}
+
+// Stats counts groups that were merged and that could not be merged
+func (r MergeReport) Stats() MergeStats {
+	var stats MergeStats
+	for _, group := range r.Groups {
+		switch group.Status {
+		case GroupMerged:
+			stats.Successes++
+		case GroupConflict:
+			stats.Failures++
+		}
+	}
+	return stats
+}
+
+// Text renders the report as lines of a log
+func (r MergeReport) Text() []string {
+	var lines []string
+	for _, plugin := range r.Unmergeable {
+		lines = append(lines, fmt.Sprintf("Plugin %s is not merged: %s", plugin.PluginID, plugin.Reason))
+	}
+	for _, group := range r.Groups {
+		if group.Status == GroupSingle {
+			lines = append(lines, fmt.Sprintf("Plugin %s is not merged: no other plugin uses an image compatible with %s",
+				group.Plugins[0], group.Image))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("Merging plugins [%s] to image %s", strings.Join(group.Plugins, ", "), group.Image))
+		for _, reason := range group.Compatibility {
+			lines = append(lines, fmt.Sprintf("  Images are compatible: %s", reason))
+		}
+		if group.Status == GroupConflict {
+			lines = append(lines, fmt.Sprintf("  Cannot merge plugins: %s", group.Conflict))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  Merged container: %s", group.Container))
+		for _, member := range group.Members {
+			if contents := member.contents(); len(contents) > 0 {
+				lines = append(lines, fmt.Sprintf("  From %s: %s", member.PluginID, strings.Join(contents, "; ")))
+			}
+		}
+		if resources := group.Resources.contents(); len(resources) > 0 {
+			lines = append(lines, fmt.Sprintf("  Resources: %s", strings.Join(resources, ", ")))
+		}
+	}
+	return lines
+}
+
+// JSON renders the report as JSON
+func (r MergeReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (m MemberReport) contents() []string {
+	var contents []string
+	for _, items := range []struct {
+		kind  string
+		items []string
+	}{
+		{"env", m.Env},
+		{"workspace env", m.WorkspaceEnv},
+		{"volumes", m.Volumes},
+		{"endpoints", m.Endpoints},
+		{"commands", m.Commands},
+		{"init containers", m.InitContainers},
+		{"lifecycle hooks", m.Lifecycle},
+	} {
+		if len(items.items) > 0 {
+			contents = append(contents, fmt.Sprintf("%s %s", items.kind, strings.Join(items.items, ", ")))
+		}
+	}
+	return contents
+}
+
+func (t *ResourceTotals) contents() []string {
+	if t == nil {
+		return nil
+	}
+	var contents []string
+	for i, value := range []string{t.MemoryLimit, t.MemoryRequest, t.CPULimit, t.CPURequest} {
+		if value != "" {
+			contents = append(contents, fmt.Sprintf("%s %s", resourceNames[i], value))
+		}
+	}
+	return contents
+}
+
+// newMemberReports lists what the merged plugin takes from each of plugins, identifying
+// items the same way as merging does: env vars, endpoints and commands by name, volumes
+// by mount path, and init containers and lifecycle hooks by content
+func newMemberReports(plugins []model.PluginMeta) []MemberReport {
+	seen := map[string]bool{}
+	// taken returns whether the item of kind is new, marking it as seen
+	taken := func(kind string, name string) bool {
+		key := kind + "/" + name
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		return true
+	}
+	// Init containers and hooks are merged again to find those added by each plugin
+	initContainers := &model.PluginMeta{}
+	var postStart, preStop [][]string
+	var members []MemberReport
+	for _, plugin := range plugins {
+		member := MemberReport{PluginID: plugin.ID}
+		container := plugin.Spec.Containers[0]
+		for _, env := range container.Env {
+			if taken("env", env.Name) {
+				member.Env = append(member.Env, env.Name)
+			}
+		}
+		for _, env := range plugin.Spec.WorkspaceEnv {
+			if taken("workspaceEnv", env.Name) {
+				member.WorkspaceEnv = append(member.WorkspaceEnv, env.Name)
+			}
+		}
+		for _, volume := range container.Volumes {
+			if taken("volume", volume.MountPath) {
+				member.Volumes = append(member.Volumes, volume.MountPath)
+			}
+		}
+		for _, endpoint := range plugin.Spec.Endpoints {
+			if taken("endpoint", endpoint.Name) {
+				member.Endpoints = append(member.Endpoints, endpoint.Name)
+			}
+		}
+		for _, command := range container.Commands {
+			if taken("command", command.Name) {
+				member.Commands = append(member.Commands, command.Name)
+			}
+		}
+		added := len(initContainers.Spec.InitContainers)
+		addInitContainers(initContainers, plugin)
+		for _, initContainer := range initContainers.Spec.InitContainers[added:] {
+			member.InitContainers = append(member.InitContainers, initContainer.Name)
+		}
+		if lifecycle := container.Lifecycle; lifecycle != nil {
+			if hooks := appendHook(postStart, lifecycle.PostStart); len(hooks) > len(postStart) {
+				postStart = hooks
+				member.Lifecycle = append(member.Lifecycle, "postStart")
+			}
+			if hooks := appendHook(preStop, lifecycle.PreStop); len(hooks) > len(preStop) {
+				preStop = hooks
+				member.Lifecycle = append(member.Lifecycle, "preStop")
+			}
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+func newResourceTotals(container model.Container) *ResourceTotals {
+	totals := &ResourceTotals{
+		MemoryLimit:   container.MemoryLimit,
+		MemoryRequest: container.MemoryRequest,
+		CPULimit:      container.CPULimit,
+		CPURequest:    container.CPURequest,
+	}
+	if *totals == (ResourceTotals{}) {
+		return nil
+	}
+	return totals
+}
+
+// mergeConflict is an error caused by a plugin that conflicts with other merged plugins
+type mergeConflict struct {
+	plugin model.PluginMeta
+	err    error
+}
+
+func (c *mergeConflict) Error() string {
+	return fmt.Sprintf("failed to merge plugins on plugin %s: %s", c.plugin.Name, c.err)
+}
+
+func (c *mergeConflict) Unwrap() error {
+	return c.err
+}
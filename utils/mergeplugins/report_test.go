@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package mergeplugins
+
+import (
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func reportedPlugins() []model.PluginMeta {
+	java := theiaPlugin("java", "quay.io/x/sidecar:1.0")
+	java.Spec.Containers[0].Env = []model.EnvVar{{Name: "JAVA_HOME", Value: "/jdk"}, {Name: "SHARED", Value: "yes"}}
+	java.Spec.Containers[0].Volumes = []model.Volume{{Name: "m2", MountPath: "/home/user/.m2"}}
+	java.Spec.Containers[0].MemoryLimit = "1Gi"
+	java.Spec.Endpoints = []model.Endpoint{{Name: "debug", TargetPort: 5005}}
+	java.Spec.InitContainers = []model.Container{{Name: "setup", Image: "busybox"}}
+	java.Spec.Containers[0].Lifecycle = &model.Lifecycle{PostStart: &model.Handler{Exec: &model.ExecAction{Command: []string{"/init.sh"}}}}
+	maven := theiaPlugin("maven", "quay.io/x/sidecar:1.0")
+	maven.Spec.Containers[0].Env = []model.EnvVar{{Name: "SHARED", Value: "yes"}}
+	maven.Spec.Containers[0].Volumes = []model.Volume{{Name: "maven", MountPath: "/home/user/.m2"}}
+	maven.Spec.Containers[0].Commands = []model.Command{{Name: "build", Command: []string{"mvn", "package"}}}
+	maven.Spec.Containers[0].MemoryLimit = "512Mi"
+	maven.Spec.WorkspaceEnv = []model.EnvVar{{Name: "MAVEN_OPTS", Value: "-Xmx256m"}}
+	maven.Spec.InitContainers = []model.Container{{Name: "setup", Image: "busybox"}, {Name: "setup", Image: "maven"}}
+	maven.Spec.Containers[0].Lifecycle = &model.Lifecycle{
+		PostStart: &model.Handler{Exec: &model.ExecAction{Command: []string{"/init.sh"}}},
+		PreStop:   &model.Handler{Exec: &model.ExecAction{Command: []string{"/stop.sh"}}},
+	}
+	first := theiaPlugin("first", "quay.io/x/other:1.0")
+	second := theiaPlugin("second", "quay.io/x/other:1.0")
+	second.Spec.Containers[0].Args = []string{"sleep", "infinity"}
+	editor := model.PluginMeta{ID: "editor", Type: model.EditorPluginType}
+	return []model.PluginMeta{java, editor, first, maven, second}
+}
+
+func TestMergeReport(t *testing.T) {
+	_, report := MergePlugins(reportedPlugins(), MergeOptions{})
+
+	assert.Equal(t, MergeReport{
+		Groups: []GroupReport{
+			{
+				Image:     "quay.io/x/sidecar:1.0",
+				Status:    GroupMerged,
+				Plugins:   []string{"java", "maven"},
+				Container: "merged-quay-io-x-sidecar-1-0",
+				Members: []MemberReport{
+					{PluginID: "java", Env: []string{"JAVA_HOME", "SHARED"}, Volumes: []string{"/home/user/.m2"}, Endpoints: []string{"debug"},
+						InitContainers: []string{"setup"}, Lifecycle: []string{"postStart"}},
+					{PluginID: "maven", WorkspaceEnv: []string{"MAVEN_OPTS"}, Commands: []string{"build"},
+						InitContainers: []string{"setup-maven"}, Lifecycle: []string{"preStop"}},
+				},
+				Resources: &ResourceTotals{MemoryLimit: "1536Mi"},
+			},
+			{
+				Image:          "quay.io/x/other:1.0",
+				Status:         GroupConflict,
+				Plugins:        []string{"first", "second"},
+				Conflict:       `failed to merge plugins on plugin second: different container args [] and ["sleep" "infinity"]`,
+				ConflictPlugin: "second",
+			},
+		},
+	}, report)
+	assert.Equal(t, MergeStats{Successes: 1, Failures: 1}, report.Stats())
+	assert.Equal(t, []string{
+		"Merging plugins [java, maven] to image quay.io/x/sidecar:1.0",
+		"  Merged container: merged-quay-io-x-sidecar-1-0",
+		"  From java: env JAVA_HOME, SHARED; volumes /home/user/.m2; endpoints debug; init containers setup; lifecycle hooks postStart",
+		"  From maven: workspace env MAVEN_OPTS; commands build; init containers setup-maven; lifecycle hooks preStop",
+		"  Resources: memory limit 1536Mi",
+		"Merging plugins [first, second] to image quay.io/x/other:1.0",
+		`  Cannot merge plugins: failed to merge plugins on plugin second: different container args [] and ["sleep" "infinity"]`,
+	}, report.Text())
+}
+
+func TestMergeReportJSON(t *testing.T) {
+	report := MergeReport{
+		Unmergeable: []UnmergeablePlugin{{PluginID: "multiple", Reason: "it has 2 containers instead of 1"}},
+		Groups: []GroupReport{
+			{Image: "quay.io/x/other:1.0", Status: GroupSingle, Plugins: []string{"other"}},
+		},
+	}
+
+	reportJSON, err := report.JSON()
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"unmergeable": [{"pluginId": "multiple", "reason": "it has 2 containers instead of 1"}],
+		"groups": [{"image": "quay.io/x/other:1.0", "status": "single", "plugins": ["other"]}]
+	}`, string(reportJSON))
+	assert.Equal(t, []string{
+		"Plugin multiple is not merged: it has 2 containers instead of 1",
+		"Plugin other is not merged: no other plugin uses an image compatible with quay.io/x/other:1.0",
+	}, report.Text())
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package mergeplugins
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ResourceAggregation determines how resources of merged plugins are combined into
+// resources of the merged container
+type ResourceAggregation string
+
+const (
+	// AggregateSum sums resources of merged plugins
+	AggregateSum ResourceAggregation = "sum"
+	// AggregateMax uses the largest resources of merged plugins
+	AggregateMax ResourceAggregation = "max"
+	// AggregateSumCapped sums resources of merged plugins, but at most up to the cap
+	// configured for memory and CPU
+	AggregateSumCapped ResourceAggregation = "sum-capped"
+	// AggregateMaxPlusOverhead uses the largest resources of merged plugins, plus the
+	// overhead configured for memory and CPU for each other merged plugin
+	AggregateMaxPlusOverhead ResourceAggregation = "max-plus-overhead"
+)
+
+// ResourcePolicy determines resources of merged containers. The zero value sums resources
+// without any ceiling.
+type ResourcePolicy struct {
+	Aggregation ResourceAggregation
+	// Memory and CPU are the cap of AggregateSumCapped or the overhead of AggregateMaxPlusOverhead
+	Memory *resource.Quantity
+	CPU    *resource.Quantity
+	// MemoryCeiling and CPUCeiling are the largest limits and requests of a merged container.
+	// Plugins are not merged if their merged container would exceed them.
+	MemoryCeiling *resource.Quantity
+	CPUCeiling    *resource.Quantity
+}
+
+// ParseResourcePolicy parses policy in format 'aggregation[:memory=quantity,cpu=quantity]',
+// e.g. 'max' or 'sum-capped:memory=2Gi,cpu=1', and ceiling in format
+// 'memory=quantity,cpu=quantity'. Empty policy sums resources.
+func ParseResourcePolicy(policy string, ceiling string) (ResourcePolicy, error) {
+	result := ResourcePolicy{Aggregation: AggregateSum}
+	parts := strings.SplitN(policy, ":", 2)
+	if aggregation := strings.TrimSpace(parts[0]); aggregation != "" {
+		result.Aggregation = ResourceAggregation(aggregation)
+	}
+	var params string
+	if len(parts) == 2 {
+		params = parts[1]
+	}
+	var err error
+	switch result.Aggregation {
+	case AggregateSum, AggregateMax:
+		if strings.TrimSpace(params) != "" {
+			return ResourcePolicy{}, newResourcePolicyError("resource policy '%s' does not accept quantities", result.Aggregation)
+		}
+	case AggregateSumCapped, AggregateMaxPlusOverhead:
+		if result.Memory, result.CPU, err = parseResourceQuantities(params); err != nil {
+			return ResourcePolicy{}, err
+		}
+		if result.Memory == nil && result.CPU == nil {
+			return ResourcePolicy{}, newResourcePolicyError(
+				"resource policy '%s' requires quantities, e.g. '%s:memory=1Gi,cpu=500m'", result.Aggregation, result.Aggregation)
+		}
+	default:
+		return ResourcePolicy{}, newResourcePolicyError("unknown resource policy '%s', expected one of '%s', '%s', '%s' or '%s'",
+			result.Aggregation, AggregateSum, AggregateMax, AggregateSumCapped, AggregateMaxPlusOverhead)
+	}
+	if result.MemoryCeiling, result.CPUCeiling, err = parseResourceQuantities(ceiling); err != nil {
+		return ResourcePolicy{}, err
+	}
+	return result, nil
+}
+
+func newResourcePolicyError(format string, v ...interface{}) error {
+	return utils.NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig}, format, v...)
+}
+
+// parseResourceQuantities parses comma-separated 'memory=quantity' and 'cpu=quantity' pairs
+func parseResourceQuantities(value string) (memory *resource.Quantity, cpu *resource.Quantity, err error) {
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, nil, newResourcePolicyError("resource quantity '%s' is not in format 'memory=quantity' or 'cpu=quantity'", pair)
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, nil, newResourcePolicyError("failed to parse resource quantity '%s': %s", pair, err)
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "memory":
+			memory = &quantity
+		case "cpu":
+			cpu = &quantity
+		default:
+			return nil, nil, newResourcePolicyError("unknown resource '%s' in '%s', expected 'memory' or 'cpu'", parts[0], pair)
+		}
+	}
+	return memory, cpu, nil
+}
+
+// aggregate returns resources of the merged container from sums and maximums of resources
+// of count merged plugins, or an error if they exceed the ceiling
+func (p ResourcePolicy) aggregate(sums containerResources, maxes containerResources, count int) (containerResources, error) {
+	result := newResources()
+	for i, quantity := range result.quantities() {
+		param, ceiling := p.Memory, p.MemoryCeiling
+		if strings.HasPrefix(resourceNames[i], "cpu") {
+			param, ceiling = p.CPU, p.CPUCeiling
+		}
+		switch p.Aggregation {
+		case AggregateMax:
+			quantity.Add(*maxes.quantities()[i])
+		case AggregateSumCapped:
+			quantity.Add(*sums.quantities()[i])
+			if param != nil && quantity.Cmp(*param) > 0 {
+				*quantity = param.DeepCopy()
+			}
+		case AggregateMaxPlusOverhead:
+			quantity.Add(*maxes.quantities()[i])
+			// Resources not set by any plugin stay unset
+			if param != nil && !quantity.IsZero() {
+				for j := 1; j < count; j++ {
+					quantity.Add(*param)
+				}
+			}
+		default:
+			quantity.Add(*sums.quantities()[i])
+		}
+		if ceiling != nil && quantity.Cmp(*ceiling) > 0 {
+			return containerResources{}, fmt.Errorf("merged container would have %s %s, which exceeds the ceiling %s",
+				resourceNames[i], quantity, ceiling)
+		}
+	}
+	return result, nil
+}
+
+// resourceNames are names of quantities of containerResources, in the same order
+var resourceNames = []string{"memory limit", "memory request", "cpu limit", "cpu request"}
+
+// quantities returns pointers to memory limit, memory request, cpu limit and cpu request
+func (r containerResources) quantities() []*resource.Quantity {
+	return []*resource.Quantity{r.memLimit, r.memRequest, r.cpuLimit, r.cpuRequest}
+}
+
+// maxResources sets each resource of resources to the resource of container, if it is larger
+func maxResources(resources *containerResources, container model.Container) error {
+	parsed, err := parseResources(container)
+	if err != nil {
+		return err
+	}
+	for i, quantity := range resources.quantities() {
+		if containerQuantity := parsed.quantities()[i]; quantity.Cmp(*containerQuantity) < 0 {
+			*quantity = containerQuantity.DeepCopy()
+		}
+	}
+	return nil
+}
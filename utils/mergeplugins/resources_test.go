@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package mergeplugins
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func quantity(value string) *resource.Quantity {
+	q := resource.MustParse(value)
+	return &q
+}
+
+func TestParseResourcePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		ceiling string
+		want    ResourcePolicy
+		wantErr string
+	}{
+		{name: "Default", want: ResourcePolicy{Aggregation: AggregateSum}},
+		{name: "Max", policy: "max", want: ResourcePolicy{Aggregation: AggregateMax}},
+		{name: "Sum capped with ceiling", policy: "sum-capped:memory=2Gi", ceiling: "memory=4Gi, cpu=2",
+			want: ResourcePolicy{Aggregation: AggregateSumCapped, Memory: quantity("2Gi"), MemoryCeiling: quantity("4Gi"), CPUCeiling: quantity("2")}},
+		{name: "Max plus overhead", policy: "max-plus-overhead:memory=128Mi,cpu=100m",
+			want: ResourcePolicy{Aggregation: AggregateMaxPlusOverhead, Memory: quantity("128Mi"), CPU: quantity("100m")}},
+		{name: "Unknown policy", policy: "min",
+			wantErr: "unknown resource policy 'min', expected one of 'sum', 'max', 'sum-capped' or 'max-plus-overhead'"},
+		{name: "Missing quantities", policy: "sum-capped",
+			wantErr: "resource policy 'sum-capped' requires quantities, e.g. 'sum-capped:memory=1Gi,cpu=500m'"},
+		{name: "Unexpected quantities", policy: "max:memory=1Gi", wantErr: "resource policy 'max' does not accept quantities"},
+		{name: "Unknown resource", ceiling: "disk=1Gi", wantErr: "unknown resource 'disk' in 'disk=1Gi', expected 'memory' or 'cpu'"},
+		{name: "Malformed quantity", ceiling: "memory=lots",
+			wantErr: "failed to parse resource quantity 'memory=lots': quantities must match the regular expression '^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResourcePolicy(tt.policy, tt.ceiling)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// pluginsWithResources returns count plugins with the same image and given resources
+func pluginsWithResources(count int, memLimit string, cpuLimit string) []model.PluginMeta {
+	var plugins []model.PluginMeta
+	for i := 0; i < count; i++ {
+		plugin := theiaPlugin(fmt.Sprintf("plugin-%d", i), "quay.io/x/sidecar:1.0")
+		plugin.Spec.Containers[0].MemoryLimit = memLimit
+		plugin.Spec.Containers[0].CPULimit = cpuLimit
+		plugins = append(plugins, plugin)
+	}
+	return plugins
+}
+
+func TestMergePluginsForImageAggregatesResources(t *testing.T) {
+	plugins := pluginsWithResources(5, "1Gi", "")
+	plugins[2].Spec.Containers[0].MemoryLimit = "1536Mi"
+	plugins[2].Spec.Containers[0].CPULimit = "500m"
+	tests := []struct {
+		name         string
+		policy       ResourcePolicy
+		wantMemLimit string
+		wantCPULimit string
+	}{
+		{name: "Sum", policy: ResourcePolicy{}, wantMemLimit: "5632Mi", wantCPULimit: "500m"},
+		{name: "Max", policy: ResourcePolicy{Aggregation: AggregateMax}, wantMemLimit: "1536Mi", wantCPULimit: "500m"},
+		{name: "Sum capped", policy: ResourcePolicy{Aggregation: AggregateSumCapped, Memory: quantity("2Gi")},
+			wantMemLimit: "2Gi", wantCPULimit: "500m"},
+		{name: "Max plus overhead", policy: ResourcePolicy{Aggregation: AggregateMaxPlusOverhead, Memory: quantity("128Mi"), CPU: quantity("100m")},
+			wantMemLimit: "2Gi", wantCPULimit: "900m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergePluginsForImage("quay.io/x/sidecar:1.0", plugins, tt.policy)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMemLimit, merged.Spec.Containers[0].MemoryLimit)
+			assert.Equal(t, tt.wantCPULimit, merged.Spec.Containers[0].CPULimit)
+			assert.Empty(t, merged.Spec.Containers[0].MemoryRequest)
+		})
+	}
+}
+
+func TestMergePluginsFallsBackWhenResourcesExceedCeiling(t *testing.T) {
+	plugins := pluginsWithResources(5, "1Gi", "")
+
+	merged, report := MergePlugins(plugins, MergeOptions{Resources: ResourcePolicy{MemoryCeiling: quantity("4Gi")}})
+
+	assert.Equal(t, plugins, merged)
+	assert.Equal(t, MergeStats{Failures: 1}, report.Stats())
+	assert.Equal(t, []string{
+		"Merging plugins [plugin-0, plugin-1, plugin-2, plugin-3, plugin-4] to image quay.io/x/sidecar:1.0",
+		"  Cannot merge plugins: merged container would have memory limit 5Gi, which exceeds the ceiling 4Gi",
+	}, report.Text())
+}
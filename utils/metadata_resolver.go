@@ -1,10 +1,14 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/eclipse/che-plugin-broker/model"
@@ -15,51 +19,142 @@ import (
 // when downloading metas
 const RegistryURLFormat = "%s/%s/meta.yaml"
 
-// GetPluginMetas retrieves plugin metas for a list of plugin FQNs. This method is
-// a thin wrapper over GetPluginMeta.
-func GetPluginMetas(plugins []model.PluginFQN, defaultRegistry string, ioUtil IoUtil) ([]model.PluginMeta, error) {
+// GetPluginMetas retrieves plugin metas for a list of plugin FQNs, followed by metas
+// of plugins they depend on, transitively. Retrieving stops as soon as ctx is done.
+// If resolving is not nil, it is called with the progress ID of each plugin dependency
+// when the dependency is added.
+// See also: GetPluginMeta
+func GetPluginMetas(ctx context.Context, plugins []model.PluginFQN, defaultRegistries []string, ioUtil IoUtil, resolving func(progressID string)) ([]model.PluginMeta, error) {
 	metas := make([]model.PluginMeta, 0, len(plugins))
 	for _, plugin := range plugins {
-		pluginMeta, err := GetPluginMeta(plugin, defaultRegistry, ioUtil)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pluginMeta, err := GetPluginMeta(ctx, plugin, defaultRegistries, ioUtil)
 		if err != nil {
 			return nil, err
 		}
 		metas = append(metas, *pluginMeta)
 	}
-	return metas, nil
+	return resolveDependencies(ctx, plugins, metas, defaultRegistries, ioUtil, resolving)
+}
+
+// GetPluginMeta downloads the metadata for a plugin. If plugin does not specify its registry,
+// defaultRegistries are tried in order until one of them serves the meta.yaml.
+// If defaultRegistries is empty, and plugin does not specify a registry, an error is returned.
+func GetPluginMeta(ctx context.Context, plugin model.PluginFQN, defaultRegistries []string, ioUtil IoUtil) (*model.PluginMeta, error) {
+	if plugin.Reference != "" || plugin.Registry != "" || len(defaultRegistries) == 0 {
+		return getPluginMetaFromRegistry(ctx, plugin, "", ioUtil)
+	}
+	var errs []error
+	for _, registry := range defaultRegistries {
+		pluginMeta, err := getPluginMetaFromRegistry(ctx, plugin, registry, ioUtil)
+		if err == nil {
+			return pluginMeta, nil
+		}
+		if len(defaultRegistries) == 1 || ctx.Err() != nil || !isRegistryFailure(err) {
+			return nil, err
+		}
+		log.Printf("Failed to get plugin '%s' from registry '%s': %s", plugin.ID, registry, err)
+		errs = append(errs, err)
+	}
+	return nil, newRegistriesError(plugin, errs)
+}
+
+// isRegistryFailure checks whether err means that the registry could not serve a meta.yaml,
+// in which case the next registry can be tried
+func isRegistryFailure(err error) bool {
+	details := GetErrorDetails(err)
+	if details == nil {
+		return false
+	}
+	switch details.Code {
+	case model.ErrorCodePluginNotFound, model.ErrorCodeRegistryUnreachable, model.ErrorCodeRegistryError:
+		return true
+	}
+	return false
 }
 
-// GetPluginMeta downloads the metadata for a plugin. If specified,
-// defaultRegistry is used as the registry when plugin does not specify its registry.
-// If defaultRegistry is empty, and plugin does not specify a registry, an error is returned.
-func GetPluginMeta(plugin model.PluginFQN, defaultRegistry string, ioUtil IoUtil) (*model.PluginMeta, error) {
-	var pluginURL string
+// newRegistriesError combines errors of fetching plugin from all default registries. The
+// plugin is reported as not found only if none of the registries has it; otherwise the
+// details of the first other failure are reported.
+func newRegistriesError(plugin model.PluginFQN, errs []error) error {
+	details := *GetErrorDetails(errs[0])
+	messages := make([]string, 0, len(errs))
+	found := false
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+		if d := GetErrorDetails(err); !found && d.Code != model.ErrorCodePluginNotFound {
+			details = *d
+			found = true
+		}
+	}
+	details.URL = ""
+	return &BrokerError{
+		Details: details,
+		errMsg:  fmt.Sprintf("plugin '%s' is not available from any registry: %s", plugin.ID, strings.Join(messages, "; ")),
+		cause:   errs[0],
+	}
+}
+
+// getPluginMetaFromRegistry downloads the metadata for a plugin, using defaultRegistry when
+// plugin does not specify its registry
+func getPluginMetaFromRegistry(ctx context.Context, plugin model.PluginFQN, defaultRegistry string, ioUtil IoUtil) (*model.PluginMeta, error) {
+	requestedID := plugin.ID
+	progressID := GetPluginFQNID(plugin)
+	plugin, err := ResolvePluginVersion(ctx, plugin, defaultRegistry, ioUtil)
+	if err != nil {
+		return nil, err
+	}
+	if plugin.ID != requestedID {
+		log.Printf("Resolved plugin %s to %s", requestedID, plugin.ID)
+	}
+
+	var pluginURL, source string
 	if plugin.Reference != "" {
 		pluginURL = plugin.Reference
+		if filepath.IsAbs(pluginURL) {
+			// Meta of a local plugin bundle
+			pluginURL = "file://" + filepath.ToSlash(pluginURL)
+		}
+		source = getReferenceDir(pluginURL)
 	} else {
 		registry, err := getRegistryURL(plugin, defaultRegistry)
 		if err != nil {
 			return nil, err
 		}
+		source = strings.TrimSuffix(registry, "/plugins")
 		pluginURL = fmt.Sprintf(RegistryURLFormat, registry, plugin.ID)
 		log.Printf("Fetching plugin meta.yaml from %s", pluginURL)
 	}
-	pluginRaw, err := ioUtil.Fetch(pluginURL)
+	pluginRaw, err := ioUtil.Fetch(ctx, pluginURL)
 	if err != nil {
+		pluginID := GetPluginFQNID(plugin)
+		var brokerErr *BrokerError
+		if errors.As(err, &brokerErr) {
+			// e.g. failed signature verification
+			brokerErr.Details.PluginID = pluginID
+			return nil, brokerErr
+		}
 		if httpErr, ok := err.(*HTTPError); ok {
-			return nil, fmt.Errorf(
+			code := model.ErrorCodeRegistryError
+			if httpErr.StatusCode == http.StatusNotFound {
+				code = model.ErrorCodePluginNotFound
+			}
+			return nil, NewRequestError(code, pluginID, pluginURL, err, fmt.Sprintf(
 				"failed to fetch plugin meta.yaml from URL '%s': %s. Response body: %s",
-				pluginURL, httpErr, httpErr.Body)
+				pluginURL, httpErr, httpErr.Body))
 		} else {
-			return nil, fmt.Errorf(
+			return nil, NewRequestError(model.ErrorCodeRegistryUnreachable, pluginID, pluginURL, err, fmt.Sprintf(
 				"failed to fetch plugin meta.yaml from URL '%s': %s",
-				pluginURL, err)
+				pluginURL, err))
 		}
 	}
 
 	var pluginMeta model.PluginMeta
 	if err := yaml.Unmarshal(pluginRaw, &pluginMeta); err != nil {
-		return nil, fmt.Errorf(
+		return nil, NewBrokerError(
+			model.ErrorDetails{Code: model.ErrorCodeInvalidMeta, PluginID: GetPluginFQNID(plugin), URL: pluginURL},
 			"failed to unmarshal downloaded meta.yaml for plugin '%s': %s", plugin.ID, err)
 	}
 	// Ensure ID field is set since it is used all over the place in broker
@@ -71,16 +166,71 @@ func GetPluginMeta(plugin model.PluginFQN, defaultRegistry string, ioUtil IoUtil
 			pluginMeta.ID = fmt.Sprintf("%s/%s/%s", pluginMeta.Publisher, pluginMeta.Name, pluginMeta.Version)
 		}
 	}
+	if !isLocalFile(pluginURL) {
+		if err := checkNoLocalFiles(&pluginMeta, pluginURL); err != nil {
+			return nil, err
+		}
+	}
+	if plugin.ID != requestedID {
+		pluginMeta.RequestedID = requestedID
+	}
+	pluginMeta.ProgressID = progressID
+	pluginMeta.Source = source
+	if result, ok := GetSignatureResult(ioUtil, pluginURL); ok {
+		pluginMeta.Signature = result.Status
+	}
 	return &pluginMeta, nil
 }
 
+// checkNoLocalFiles rejects extensions and dependencies that refer to local files in a
+// meta.yaml fetched from metaURL over the network. Only local plugin bundles can refer
+// to other local files.
+func checkNoLocalFiles(meta *model.PluginMeta, metaURL string) error {
+	for _, extension := range meta.Spec.Extensions {
+		if isLocalFile(extension) {
+			return NewBrokerError(
+				model.ErrorDetails{Code: model.ErrorCodeInvalidMeta, PluginID: meta.ID, URL: metaURL},
+				"extension '%s' of plugin '%s' refers to a local file", extension, meta.ID)
+		}
+	}
+	for _, dependency := range meta.Dependencies {
+		if isLocalFile(dependency) {
+			return NewBrokerError(
+				model.ErrorDetails{Code: model.ErrorCodeInvalidMeta, PluginID: meta.ID, URL: metaURL},
+				"dependency '%s' of plugin '%s' refers to a local file", dependency, meta.ID)
+		}
+	}
+	return nil
+}
+
+// isLocalFile checks whether reference is a file:// URL or an absolute path
+func isLocalFile(reference string) bool {
+	return strings.HasPrefix(strings.ToLower(reference), "file:") || filepath.IsAbs(reference)
+}
+
+// getReferenceDir returns the URL of the directory that contains the meta.yaml at
+// reference, e.g. 'https://host/dir/' for 'https://host/dir/meta.yaml'
+func getReferenceDir(reference string) string {
+	refURL, err := url.Parse(reference)
+	if err != nil {
+		return ""
+	}
+	refURL.Path = refURL.Path[:strings.LastIndex(refURL.Path, "/")+1]
+	refURL.RawPath = ""
+	refURL.RawQuery = ""
+	refURL.Fragment = ""
+	return refURL.String()
+}
+
 func getRegistryURL(plugin model.PluginFQN, defaultRegistry string) (string, error) {
 	var registry string
 	if plugin.Registry != "" {
 		registry = strings.TrimSuffix(plugin.Registry, "/") + "/plugins"
 	} else {
 		if defaultRegistry == "" {
-			return "", fmt.Errorf("plugin '%s' does not specify registry and no default is provided", plugin.ID)
+			return "", NewBrokerError(
+				model.ErrorDetails{Code: model.ErrorCodeInvalidConfig, PluginID: plugin.ID},
+				"plugin '%s' does not specify registry and no default is provided", plugin.ID)
 		}
 		registry = strings.TrimSuffix(defaultRegistry, "/") + "/plugins"
 	}
@@ -88,21 +238,33 @@ func getRegistryURL(plugin model.PluginFQN, defaultRegistry string) (string, err
 }
 
 // ResolveRelativeExtensionPaths takes a slice of plugin metas and updates relative extension
-// references (e.g. relative:extension/[...]) to point to relative paths in the default registry.
+// references (e.g. relative:extension/[...]) to point to relative paths in the source of the
+// plugin meta: the registry that served it, or the directory of its reference URL or local
+// bundle. The default registry is used for metas with unknown source.
 func ResolveRelativeExtensionPaths(metas []model.PluginMeta, defaultRegistry string) error {
 	for i, meta := range metas {
+		registry, registryKind := meta.Source, "plugin source"
+		if registry == "" {
+			registry, registryKind = defaultRegistry, "default registry"
+		}
 		for j, extension := range meta.Spec.Extensions {
 			if strings.HasPrefix(extension, "relative:extension/") {
-				if defaultRegistry == "" {
-					return fmt.Errorf("cannot resolve relative extension path without default registry")
+				if registry == "" {
+					return NewBrokerError(
+						model.ErrorDetails{Code: model.ErrorCodeInvalidConfig, PluginID: meta.ID},
+						"cannot resolve relative extension path without default registry")
 				}
-				pluginURL, err := url.Parse(defaultRegistry)
+				pluginURL, err := url.Parse(registry)
 				if err != nil {
-					return fmt.Errorf("failed to parse default registry URL: %s", err)
+					return NewBrokerError(
+						model.ErrorDetails{Code: model.ErrorCodeInvalidConfig, PluginID: meta.ID, URL: registry},
+						"failed to parse %s URL: %s", registryKind, err)
 				}
 				relativePath := strings.TrimPrefix(extension, "relative:extension/")
 				if strings.Contains(relativePath, "..") {
-					return fmt.Errorf("plugin reference path '%s' cannot refer to parent directories", relativePath)
+					return NewBrokerError(
+						model.ErrorDetails{Code: model.ErrorCodeInvalidMeta, PluginID: meta.ID},
+						"plugin reference path '%s' cannot refer to parent directories", relativePath)
 				}
 				pluginURL.Path = path.Join(pluginURL.Path, strings.TrimPrefix(extension, "relative:extension/"))
 				metas[i].Spec.Extensions[j] = pluginURL.String()
@@ -13,7 +13,9 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
 	"testing"
@@ -21,6 +23,7 @@ import (
 	"github.com/eclipse/che-plugin-broker/model"
 	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"gopkg.in/yaml.v2"
 )
 
@@ -38,14 +41,16 @@ func TestGetPluginMetasGetsAllPluginMetas(t *testing.T) {
 	plugin1, plugin1Raw := generatePluginMeta(t, "pub1/name1/ver1")
 	plugin2, plugin2Raw := generatePluginMeta(t, "pub2/name2/ver2")
 	plugin3, plugin3Raw := generatePluginMeta(t, "pub3/name3/ver3")
+	plugin1.ProgressID, plugin2.ProgressID, plugin3.ProgressID = "id1", "id2", "id3"
+	plugin1.Source, plugin2.Source, plugin3.Source = "reg1", "reg2", "reg3"
 	want := []model.PluginMeta{plugin1, plugin2, plugin3}
 
 	ioUtil := &utilMock.IoUtil{}
-	ioUtil.On("Fetch", "reg1/plugins/id1/meta.yaml").Return(plugin1Raw, nil)
-	ioUtil.On("Fetch", "reg2/plugins/id2/meta.yaml").Return(plugin2Raw, nil)
-	ioUtil.On("Fetch", "reg3/plugins/id3/meta.yaml").Return(plugin3Raw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/id1/meta.yaml").Return(plugin1Raw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg2/plugins/id2/meta.yaml").Return(plugin2Raw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg3/plugins/id3/meta.yaml").Return(plugin3Raw, nil)
 
-	got, err := GetPluginMetas(pluginFQNs, "", ioUtil)
+	got, err := GetPluginMetas(context.Background(), pluginFQNs, nil, ioUtil, nil)
 
 	ioUtil.AssertExpectations(t)
 	assert.Nil(t, err)
@@ -61,14 +66,20 @@ func TestGetPluginMetasStopsOnError(t *testing.T) {
 	_, plugin1Raw := generatePluginMeta(t, "pub1/name1/ver1")
 
 	ioUtil := &utilMock.IoUtil{}
-	ioUtil.On("Fetch", "reg1/plugins/id1/meta.yaml").Return(plugin1Raw, nil)
-	ioUtil.On("Fetch", "reg2/plugins/id2/meta.yaml").Return(nil, fmt.Errorf("Test error"))
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/id1/meta.yaml").Return(plugin1Raw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg2/plugins/id2/meta.yaml").Return(nil, fmt.Errorf("Test error"))
 
-	_, err := GetPluginMetas(pluginFQNs, "", ioUtil)
+	_, err := GetPluginMetas(context.Background(), pluginFQNs, nil, ioUtil, nil)
 
 	ioUtil.AssertExpectations(t)
 	assert.NotNil(t, err)
 	assert.Regexp(t, regexp.MustCompile("failed to fetch plugin meta.yaml from URL"), err)
+	assert.Equal(t, &model.ErrorDetails{
+		Code:      model.ErrorCodeRegistryUnreachable,
+		PluginID:  "id2",
+		URL:       "reg2/plugins/id2/meta.yaml",
+		Retryable: true,
+	}, GetErrorDetails(err))
 }
 
 func TestGetPluginMetasReportsErrorWhenHttpError(t *testing.T) {
@@ -80,14 +91,41 @@ func TestGetPluginMetasReportsErrorWhenHttpError(t *testing.T) {
 	_, plugin1Raw := generatePluginMeta(t, "pub1/name1/ver1")
 
 	ioUtil := &utilMock.IoUtil{}
-	ioUtil.On("Fetch", "reg1/plugins/id1/meta.yaml").Return(plugin1Raw, nil)
-	ioUtil.On("Fetch", "reg2/plugins/id2/meta.yaml").Return(nil, &HTTPError{Body: "failed"})
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/id1/meta.yaml").Return(plugin1Raw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg2/plugins/id2/meta.yaml").Return(nil, &HTTPError{StatusCode: http.StatusNotFound, Body: "failed"})
 
-	_, err := GetPluginMetas(pluginFQNs, "", ioUtil)
+	_, err := GetPluginMetas(context.Background(), pluginFQNs, nil, ioUtil, nil)
 
 	ioUtil.AssertExpectations(t)
 	assert.NotNil(t, err)
 	assert.Regexp(t, regexp.MustCompile("failed to fetch plugin meta.yaml from URL .* Response body:"), err)
+	assert.Equal(t, &model.ErrorDetails{
+		Code:       model.ErrorCodePluginNotFound,
+		PluginID:   "id2",
+		URL:        "reg2/plugins/id2/meta.yaml",
+		HTTPStatus: http.StatusNotFound,
+		Retryable:  false,
+	}, GetErrorDetails(err))
+}
+
+func TestGetPluginMetasReportsRetryableErrorWhenRegistryFails(t *testing.T) {
+	pluginFQNs := []model.PluginFQN{
+		generatePluginFQN("reg1", "id1", ""),
+	}
+
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/id1/meta.yaml").Return(nil, &HTTPError{StatusCode: http.StatusServiceUnavailable})
+
+	_, err := GetPluginMetas(context.Background(), pluginFQNs, nil, ioUtil, nil)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, &model.ErrorDetails{
+		Code:       model.ErrorCodeRegistryError,
+		PluginID:   "id1",
+		URL:        "reg1/plugins/id1/meta.yaml",
+		HTTPStatus: http.StatusServiceUnavailable,
+		Retryable:  true,
+	}, GetErrorDetails(err))
 }
 
 func TestGetPluginMetasReportsErrorWhenFailsToUnmarshal(t *testing.T) {
@@ -98,19 +136,20 @@ func TestGetPluginMetasReportsErrorWhenFailsToUnmarshal(t *testing.T) {
 	badYaml := []byte("test: test: test: ]")
 
 	ioUtil := &utilMock.IoUtil{}
-	ioUtil.On("Fetch", "reg1/plugins/id1/meta.yaml").Return(badYaml, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/id1/meta.yaml").Return(badYaml, nil)
 
-	_, err := GetPluginMetas(pluginFQNs, "", ioUtil)
+	_, err := GetPluginMetas(context.Background(), pluginFQNs, nil, ioUtil, nil)
 
 	ioUtil.AssertExpectations(t)
 	assert.NotNil(t, err)
 	assert.Regexp(t, regexp.MustCompile("failed to unmarshal downloaded meta.yaml for plugin"), err)
+	assert.Equal(t, model.ErrorCodeInvalidMeta, GetErrorDetails(err).Code)
 }
 
 func TestGetPluginMeta(t *testing.T) {
 	type args struct {
-		plugin          model.PluginFQN
-		defaultRegistry string
+		plugin            model.PluginFQN
+		defaultRegistries []string
 	}
 	tests := []struct {
 		name          string
@@ -118,68 +157,98 @@ func TestGetPluginMeta(t *testing.T) {
 		fetchURL      string
 		fetchErr      error
 		wantPluginID  string
+		wantSource    string
 		wantErrRegexp *regexp.Regexp
 	}{
 		{
 			name: "Get plugin meta uses reference when available",
 			args: args{
-				plugin:          generatePluginFQN("registry", "id", "https://reference.io"),
-				defaultRegistry: defaultRegistry,
+				plugin:            generatePluginFQN("registry", "id", "https://reference.io"),
+				defaultRegistries: []string{defaultRegistry},
 			},
 			fetchURL:      "https://reference.io",
 			fetchErr:      nil,
 			wantPluginID:  "mypub/myname/myver",
+			wantSource:    "https://reference.io",
+			wantErrRegexp: nil,
+		},
+		{
+			name: "Get plugin meta records directory of reference as source",
+			args: args{
+				plugin:            generatePluginFQN("", "", "https://reference.io/plugins/java/meta.yaml?ref=1"),
+				defaultRegistries: []string{defaultRegistry},
+			},
+			fetchURL:      "https://reference.io/plugins/java/meta.yaml?ref=1",
+			fetchErr:      nil,
+			wantPluginID:  "mypub/myname/myver",
+			wantSource:    "https://reference.io/plugins/java/",
+			wantErrRegexp: nil,
+		},
+		{
+			name: "Get plugin meta reads local plugin bundle",
+			args: args{
+				plugin:            generatePluginFQN("", "", "/bundles/java/meta.yaml"),
+				defaultRegistries: []string{defaultRegistry},
+			},
+			fetchURL:      "file:///bundles/java/meta.yaml",
+			fetchErr:      nil,
+			wantPluginID:  "mypub/myname/myver",
+			wantSource:    "file:///bundles/java/",
 			wantErrRegexp: nil,
 		},
 		{
 			name: "Get plugin meta uses defined registry",
 			args: args{
-				plugin:          generatePluginFQN("myregistry.io", "mypub/myname/myver", ""),
-				defaultRegistry: defaultRegistry,
+				plugin:            generatePluginFQN("myregistry.io", "mypub/myname/myver", ""),
+				defaultRegistries: []string{defaultRegistry},
 			},
 			fetchURL:      "myregistry.io/plugins/mypub/myname/myver/meta.yaml",
 			fetchErr:      nil,
 			wantPluginID:  "mypub/myname/myver",
+			wantSource:    "myregistry.io",
 			wantErrRegexp: nil,
 		},
 		{
 			name: "Get plugin meta uses default registry when no registry defined",
 			args: args{
-				plugin:          generatePluginFQN("", "mypub/myname/myver", ""),
-				defaultRegistry: defaultRegistry,
+				plugin:            generatePluginFQN("", "mypub/myname/myver", ""),
+				defaultRegistries: []string{defaultRegistry},
 			},
 			fetchURL:      defaultRegistry + "/plugins/mypub/myname/myver/meta.yaml",
 			fetchErr:      nil,
 			wantPluginID:  "mypub/myname/myver",
+			wantSource:    defaultRegistry,
 			wantErrRegexp: nil,
 		},
 		{
 			name: "Get plugin meta uses defined registry with trailing slash",
 			args: args{
-				plugin:          generatePluginFQN("myregistry.io", "mypub/myname/myver", ""),
-				defaultRegistry: defaultRegistry + "/",
+				plugin:            generatePluginFQN("myregistry.io", "mypub/myname/myver", ""),
+				defaultRegistries: []string{defaultRegistry + "/"},
 			},
 			fetchURL:      "myregistry.io/plugins/mypub/myname/myver/meta.yaml",
 			fetchErr:      nil,
 			wantPluginID:  "mypub/myname/myver",
+			wantSource:    "myregistry.io",
 			wantErrRegexp: nil,
 		},
 		{
 			name: "Get plugin meta uses default registry when no registry defined with trailing slash",
 			args: args{
-				plugin:          generatePluginFQN("", "mypub/myname/myver", ""),
-				defaultRegistry: defaultRegistry + "/",
+				plugin:            generatePluginFQN("", "mypub/myname/myver", ""),
+				defaultRegistries: []string{defaultRegistry + "/"},
 			},
 			fetchURL:      defaultRegistry + "/plugins/mypub/myname/myver/meta.yaml",
 			fetchErr:      nil,
 			wantPluginID:  "mypub/myname/myver",
+			wantSource:    defaultRegistry,
 			wantErrRegexp: nil,
 		},
 		{
 			name: "Returns error when registry cannot be determined",
 			args: args{
-				plugin:          generatePluginFQN("", "mypub/myname/myver", ""),
-				defaultRegistry: "",
+				plugin:            generatePluginFQN("", "mypub/myname/myver", ""),
+				defaultRegistries: nil,
 			},
 			fetchURL:      "",
 			fetchErr:      nil,
@@ -189,8 +258,8 @@ func TestGetPluginMeta(t *testing.T) {
 		{
 			name: "Returns specific error when fetch fails with HTTP error",
 			args: args{
-				plugin:          generatePluginFQN("", "mypub/myname/myver", ""),
-				defaultRegistry: defaultRegistry,
+				plugin:            generatePluginFQN("", "mypub/myname/myver", ""),
+				defaultRegistries: []string{defaultRegistry},
 			},
 			fetchURL:      defaultRegistry + "/plugins/mypub/myname/myver/meta.yaml",
 			fetchErr:      &HTTPError{Body: "Test error"},
@@ -200,8 +269,8 @@ func TestGetPluginMeta(t *testing.T) {
 		{
 			name: "Returns generic error when fetch fails for unclear reason",
 			args: args{
-				plugin:          generatePluginFQN("", "mypub/myname/myver", ""),
-				defaultRegistry: defaultRegistry,
+				plugin:            generatePluginFQN("", "mypub/myname/myver", ""),
+				defaultRegistries: []string{defaultRegistry},
 			},
 			fetchURL:      defaultRegistry + "/plugins/mypub/myname/myver/meta.yaml",
 			fetchErr:      fmt.Errorf("Test error"),
@@ -214,18 +283,154 @@ func TestGetPluginMeta(t *testing.T) {
 			meta, metaRaw := generatePluginMeta(t, tt.wantPluginID)
 
 			ioUtil := &utilMock.IoUtil{}
-			ioUtil.On("Fetch", tt.fetchURL).Return(metaRaw, tt.fetchErr)
-			got, err := GetPluginMeta(tt.args.plugin, tt.args.defaultRegistry, ioUtil)
+			ioUtil.On("Fetch", mock.Anything, tt.fetchURL).Return(metaRaw, tt.fetchErr)
+			got, err := GetPluginMeta(context.Background(), tt.args.plugin, tt.args.defaultRegistries, ioUtil)
 			if tt.wantErrRegexp != nil {
 				assert.NotNil(t, err)
 				assert.Regexp(t, tt.wantErrRegexp, err)
 			} else {
+				// Plugins are identified in progress events as they are requested
+				meta.ProgressID = tt.args.plugin.ID
+				if meta.ProgressID == "" {
+					meta.ProgressID = tt.args.plugin.Reference
+				}
+				meta.Source = tt.wantSource
 				assert.Equal(t, meta, *got)
 			}
 		})
 	}
 }
 
+func TestGetPluginMetaFallsBackToNextRegistry(t *testing.T) {
+	meta, metaRaw := generatePluginMeta(t, "mypub/myname/myver")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/mypub/myname/myver/meta.yaml").Return(nil, &HTTPError{StatusCode: http.StatusNotFound})
+	ioUtil.On("Fetch", mock.Anything, "reg2/plugins/mypub/myname/myver/meta.yaml").Return(metaRaw, nil)
+
+	got, err := GetPluginMeta(context.Background(), generatePluginFQN("", "mypub/myname/myver", ""), []string{"reg1", "reg2", "reg3"}, ioUtil)
+
+	assert.NoError(t, err)
+	meta.Source = "reg2"
+	meta.ProgressID = "mypub/myname/myver"
+	assert.Equal(t, meta, *got)
+	ioUtil.AssertNumberOfCalls(t, "Fetch", 2)
+}
+
+func TestGetPluginMetaReportsErrorsOfAllRegistries(t *testing.T) {
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/mypub/myname/myver/meta.yaml").Return(nil, &HTTPError{StatusCode: http.StatusNotFound, errMsg: "not found"})
+	ioUtil.On("Fetch", mock.Anything, "reg2/plugins/mypub/myname/myver/meta.yaml").Return(nil, fmt.Errorf("connection refused"))
+
+	_, err := GetPluginMeta(context.Background(), generatePluginFQN("", "mypub/myname/myver", ""), []string{"reg1", "reg2"}, ioUtil)
+
+	assert.EqualError(t, err, "plugin 'mypub/myname/myver' is not available from any registry: "+
+		"failed to fetch plugin meta.yaml from URL 'reg1/plugins/mypub/myname/myver/meta.yaml': not found. Response body: ; "+
+		"failed to fetch plugin meta.yaml from URL 'reg2/plugins/mypub/myname/myver/meta.yaml': connection refused")
+	assert.Equal(t, &model.ErrorDetails{
+		Code:      model.ErrorCodeRegistryUnreachable,
+		PluginID:  "mypub/myname/myver",
+		Retryable: true,
+	}, GetErrorDetails(err))
+}
+
+func TestGetPluginMetaDoesNotFallBackOnInvalidMeta(t *testing.T) {
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/mypub/myname/myver/meta.yaml").Return([]byte("invalid: [yaml"), nil)
+
+	_, err := GetPluginMeta(context.Background(), generatePluginFQN("", "mypub/myname/myver", ""), []string{"reg1", "reg2"}, ioUtil)
+
+	assert.Error(t, err)
+	assert.Equal(t, model.ErrorCodeInvalidMeta, GetErrorDetails(err).Code)
+	ioUtil.AssertNumberOfCalls(t, "Fetch", 1)
+}
+
+func TestGetPluginMetaRejectsLocalFilesInRemoteMeta(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    model.PluginMeta
+		wantErr string
+	}{
+		{
+			name:    "file extension",
+			meta:    generatePluginMetaWithExtensions(t, "mypub/myname/myver", "file:///var/run/secrets/token"),
+			wantErr: "extension 'file:///var/run/secrets/token' of plugin 'mypub/myname/myver' refers to a local file",
+		},
+		{
+			name: "file dependency",
+			meta: func() model.PluginMeta {
+				meta, _ := generatePluginMeta(t, "mypub/myname/myver")
+				meta.Dependencies = []string{"/var/run/secrets/meta.yaml"}
+				return meta
+			}(),
+			wantErr: "dependency '/var/run/secrets/meta.yaml' of plugin 'mypub/myname/myver' refers to a local file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metaRaw, err := yaml.Marshal(tt.meta)
+			assert.NoError(t, err)
+			ioUtil := &utilMock.IoUtil{}
+			ioUtil.On("Fetch", mock.Anything, "https://reg1/plugins/mypub/myname/myver/meta.yaml").Return(metaRaw, nil)
+
+			_, err = GetPluginMeta(context.Background(), generatePluginFQN("https://reg1", "mypub/myname/myver", ""), nil, ioUtil)
+
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, model.ErrorCodeInvalidMeta, GetErrorDetails(err).Code)
+		})
+	}
+}
+
+func TestGetPluginMetaAllowsLocalFilesInLocalBundle(t *testing.T) {
+	meta := generatePluginMetaWithExtensions(t, "mypub/myname/myver", "file:///bundles/java/extension.vsix")
+	metaRaw, err := yaml.Marshal(meta)
+	assert.NoError(t, err)
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "file:///bundles/java/meta.yaml").Return(metaRaw, nil)
+
+	got, err := GetPluginMeta(context.Background(), generatePluginFQN("", "", "/bundles/java/meta.yaml"), nil, ioUtil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, meta.Spec.Extensions, got.Spec.Extensions)
+}
+
+func TestGetPluginMetaRecordsSignatureStatus(t *testing.T) {
+	meta, metaRaw := generatePluginMeta(t, "mypub/myname/myver")
+	trusted, private := generateTrustedKey(t)
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/mypub/myname/myver/meta.yaml").Return(metaRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/mypub/myname/myver/meta.yaml.sig").Return(
+		[]byte(sign(private, "reg1/plugins/mypub/myname/myver/meta.yaml", string(metaRaw))), nil)
+
+	got, err := GetPluginMeta(context.Background(), generatePluginFQN("", "mypub/myname/myver", ""), []string{"reg1"},
+		NewVerifyingIoUtil(ioUtil, []TrustedKey{trusted}, SignaturePolicyEnforce))
+
+	assert.NoError(t, err)
+	meta.Source = "reg1"
+	meta.ProgressID = "mypub/myname/myver"
+	meta.Signature = model.SignatureVerified
+	assert.Equal(t, meta, *got)
+}
+
+func TestGetPluginMetaReportsRejectedSignature(t *testing.T) {
+	_, metaRaw := generatePluginMeta(t, "mypub/myname/myver")
+	trusted, _ := generateTrustedKey(t)
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/mypub/myname/myver/meta.yaml").Return(metaRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/mypub/myname/myver/meta.yaml.sig").Return(nil, &HTTPError{StatusCode: http.StatusNotFound})
+
+	_, err := GetPluginMeta(context.Background(), generatePluginFQN("", "mypub/myname/myver", ""), []string{"reg1", "reg2"},
+		NewVerifyingIoUtil(ioUtil, []TrustedKey{trusted}, SignaturePolicyEnforce))
+
+	assert.EqualError(t, err, "signature verification of 'reg1/plugins/mypub/myname/myver/meta.yaml' failed: "+
+		"no signature at 'reg1/plugins/mypub/myname/myver/meta.yaml.sig'")
+	assert.Equal(t, &model.ErrorDetails{
+		Code:     model.ErrorCodeSignatureInvalid,
+		PluginID: "mypub/myname/myver",
+		URL:      "reg1/plugins/mypub/myname/myver/meta.yaml",
+	}, GetErrorDetails(err))
+	ioUtil.AssertNumberOfCalls(t, "Fetch", 2)
+}
+
 func TestGetPluginMetaSetsPluginIdFromPluginFQNWhenAvailable(t *testing.T) {
 	meta := model.PluginMeta{
 		APIVersion: "apiversion",
@@ -247,9 +452,9 @@ func TestGetPluginMetaSetsPluginIdFromPluginFQNWhenAvailable(t *testing.T) {
 	}
 
 	ioUtil := &utilMock.IoUtil{}
-	ioUtil.On("Fetch", "registry.io").Return(metaRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "registry.io").Return(metaRaw, nil)
 
-	got, err := GetPluginMeta(generatePluginFQN("", "pluginId", "registry.io"), "", ioUtil)
+	got, err := GetPluginMeta(context.Background(), generatePluginFQN("", "pluginId", "registry.io"), nil, ioUtil)
 
 	assert.Nil(t, err)
 	assert.Equal(t, "pluginId", got.ID)
@@ -276,9 +481,9 @@ func TestGetPluginMetaSetsPluginIdFromPluginMetaWhenFQNEmpty(t *testing.T) {
 	}
 
 	ioUtil := &utilMock.IoUtil{}
-	ioUtil.On("Fetch", "registry.io").Return(metaRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "registry.io").Return(metaRaw, nil)
 
-	got, err := GetPluginMeta(generatePluginFQN("", "", "registry.io"), "", ioUtil)
+	got, err := GetPluginMeta(context.Background(), generatePluginFQN("", "", "registry.io"), nil, ioUtil)
 
 	assert.Nil(t, err)
 	assert.Equal(t, "publisher/name/version", got.ID)
@@ -362,6 +567,32 @@ func TestResolveRelativeExtensionPaths(t *testing.T) {
 			},
 			errRegexp: nil,
 		},
+		{
+			name: "Resolves relative extension path against registry that served the plugin",
+			args: args{
+				metas: []model.PluginMeta{
+					generatePluginMetaWithSource(t, "a/b/c", "other.io", "relative:extension/a/b/c"),
+				},
+				defaultRegistry: "default.io",
+			},
+			want: []model.PluginMeta{
+				generatePluginMetaWithSource(t, "a/b/c", "other.io", "other.io/a/b/c"),
+			},
+			errRegexp: nil,
+		},
+		{
+			name: "Resolves relative extension path against local plugin bundle",
+			args: args{
+				metas: []model.PluginMeta{
+					generatePluginMetaWithSource(t, "a/b/c", "file:///bundles/java/", "relative:extension/a/b/c"),
+				},
+				defaultRegistry: "default.io",
+			},
+			want: []model.PluginMeta{
+				generatePluginMetaWithSource(t, "a/b/c", "file:///bundles/java/", "file:///bundles/java/a/b/c"),
+			},
+			errRegexp: nil,
+		},
 		{
 			name: "Returns error when default registry not specified",
 			args: args{
@@ -461,3 +692,9 @@ func generatePluginMetaWithExtensions(t *testing.T, id string, extensions ...str
 	meta.Spec.Extensions = extensions
 	return meta
 }
+
+func generatePluginMetaWithSource(t *testing.T, id string, source string, extensions ...string) model.PluginMeta {
+	meta := generatePluginMetaWithExtensions(t, id, extensions...)
+	meta.Source = source
+	return meta
+}
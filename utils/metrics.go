@@ -0,0 +1,225 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+// TimingPhase is a step of a broker run whose duration is measured
+type TimingPhase string
+
+// Measured steps of broker runs
+const (
+	PhaseFetch      TimingPhase = "fetch"
+	PhaseValidation TimingPhase = "validation"
+	PhaseMerge      TimingPhase = "merge"
+	PhaseDownload   TimingPhase = "download"
+	PhaseCopy       TimingPhase = "copy"
+	PhaseCacheSync  TimingPhase = "cache-sync"
+)
+
+// PhaseTiming is the duration of a single step of a broker run
+type PhaseTiming struct {
+	Phase           TimingPhase `json:"phase"`
+	URL             string      `json:"url,omitempty"`
+	Bytes           int64       `json:"bytes,omitempty"`
+	DurationSeconds float64     `json:"durationSeconds"`
+}
+
+// RunReport summarizes a broker run
+type RunReport struct {
+	Broker string `json:"broker"`
+	// Workspace is the ID of the workspace the broker runs for, if known
+	Workspace        string             `json:"workspace,omitempty"`
+	Status           model.BrokerStatus `json:"status"`
+	DurationSeconds  float64            `json:"durationSeconds"`
+	PluginsResolved  int                `json:"pluginsResolved"`
+	MergeSuccesses   int                `json:"mergeSuccesses"`
+	MergeFailures    int                `json:"mergeFailures"`
+	Phases           []PhaseTiming      `json:"phases"`
+	CacheHits        int                `json:"cacheHits"`
+	CacheMisses      int                `json:"cacheMisses"`
+	BytesTransferred int64              `json:"bytesTransferred"`
+}
+
+// Metrics collects timings and statistics of a broker run. It is safe to use
+// from multiple goroutines.
+type Metrics struct {
+	mutex  sync.Mutex
+	broker string
+	start  time.Time
+	report RunReport
+	redact func(URL string) string
+}
+
+// NewMetrics creates Metrics for a run of broker, starting the run timer. URLs of
+// recorded steps are masked with redact, if it is not nil, since reports are written
+// to files that are not covered by the redaction of logs and events.
+func NewMetrics(broker string, redact func(URL string) string) *Metrics {
+	return &Metrics{
+		broker: broker,
+		start:  time.Now(),
+		report: RunReport{Broker: broker, Phases: []PhaseTiming{}},
+		redact: redact,
+	}
+}
+
+// StartPhase starts measuring a step and returns a function that records the step
+// when called. Bytes is the number of bytes transferred during the step, if any.
+func (m *Metrics) StartPhase(phase TimingPhase, URL string) func(bytes int64) {
+	start := time.Now()
+	if m.redact != nil {
+		URL = m.redact(URL)
+	}
+	return func(bytes int64) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.report.Phases = append(m.report.Phases, PhaseTiming{
+			Phase:           phase,
+			URL:             URL,
+			Bytes:           bytes,
+			DurationSeconds: time.Since(start).Seconds(),
+		})
+		m.report.BytesTransferred += bytes
+	}
+}
+
+// AddCacheStats adds the number of extensions found in and missing from the
+// plugins cache
+func (m *Metrics) AddCacheStats(hits int, misses int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.report.CacheHits += hits
+	m.report.CacheMisses += misses
+}
+
+// SetPluginsResolved sets the number of plugins resolved from registries
+func (m *Metrics) SetPluginsResolved(count int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.report.PluginsResolved = count
+}
+
+// SetWorkspace sets the ID of the workspace the broker runs for
+func (m *Metrics) SetWorkspace(workspace string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.report.Workspace = workspace
+}
+
+// AddMergeStats adds the number of groups of plugins that were merged and that
+// could not be merged
+func (m *Metrics) AddMergeStats(successes int, failures int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.report.MergeSuccesses += successes
+	m.report.MergeFailures += failures
+}
+
+// Finish stops the run timer and returns the report of the run
+func (m *Metrics) Finish(status model.BrokerStatus) RunReport {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.report.Status = status
+	m.report.DurationSeconds = time.Since(m.start).Seconds()
+	report := m.report
+	report.Phases = append([]PhaseTiming{}, m.report.Phases...)
+	return report
+}
+
+// Summary formats the report as lines of human-readable text
+func (r RunReport) Summary() []string {
+	summary := []string{
+		fmt.Sprintf("Plugin %s broker run summary", r.Broker),
+		fmt.Sprintf("  Status: %s", r.Status),
+		fmt.Sprintf("  Total time: %.3fs", r.DurationSeconds),
+		fmt.Sprintf("  Plugins resolved: %d", r.PluginsResolved),
+	}
+	totals := map[TimingPhase]float64{}
+	var phases []TimingPhase
+	for _, timing := range r.Phases {
+		if _, ok := totals[timing.Phase]; !ok {
+			phases = append(phases, timing.Phase)
+		}
+		totals[timing.Phase] += timing.DurationSeconds
+	}
+	for _, phase := range phases {
+		summary = append(summary, fmt.Sprintf("  %s: %.3fs", phase, totals[phase]))
+		for _, timing := range r.Phases {
+			if timing.Phase != phase || timing.URL == "" {
+				continue
+			}
+			if timing.Bytes > 0 {
+				summary = append(summary, fmt.Sprintf("    %s (%d bytes): %.3fs", timing.URL, timing.Bytes, timing.DurationSeconds))
+			} else {
+				summary = append(summary, fmt.Sprintf("    %s: %.3fs", timing.URL, timing.DurationSeconds))
+			}
+		}
+	}
+	summary = append(summary,
+		fmt.Sprintf("  Merged plugin groups: %d, failed merges: %d", r.MergeSuccesses, r.MergeFailures),
+		fmt.Sprintf("  Cache hits: %d, misses: %d", r.CacheHits, r.CacheMisses),
+		fmt.Sprintf("  Bytes transferred: %d", r.BytesTransferred))
+	return summary
+}
+
+// JSON serializes the report
+func (r RunReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// timedIoUtil is an IoUtil that records timings of network and copy operations
+type timedIoUtil struct {
+	IoUtil
+	metrics *Metrics
+}
+
+// NewTimedIoUtil wraps ioUtil so that fetches, downloads and file copies are
+// recorded in metrics.
+func NewTimedIoUtil(ioUtil IoUtil, metrics *Metrics) IoUtil {
+	return &timedIoUtil{IoUtil: ioUtil, metrics: metrics}
+}
+
+func (util *timedIoUtil) Fetch(ctx context.Context, URL string) ([]byte, error) {
+	done := util.metrics.StartPhase(PhaseFetch, URL)
+	data, err := util.IoUtil.Fetch(ctx, URL)
+	done(int64(len(data)))
+	return data, err
+}
+
+func (util *timedIoUtil) Download(ctx context.Context, URL string, destPath string, useContentDisposition bool, progress func(downloaded int64, total int64)) (string, error) {
+	done := util.metrics.StartPhase(PhaseDownload, URL)
+	var downloaded int64
+	path, err := util.IoUtil.Download(ctx, URL, destPath, useContentDisposition, func(d int64, total int64) {
+		downloaded = d
+		if progress != nil {
+			progress(d, total)
+		}
+	})
+	done(downloaded)
+	return path, err
+}
+
+func (util *timedIoUtil) CopyFile(src string, dest string) error {
+	done := util.metrics.StartPhase(PhaseCopy, "")
+	err := util.IoUtil.CopyFile(src, dest)
+	done(0)
+	return err
+}
@@ -0,0 +1,163 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/eclipse/che-plugin-broker/utils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTimedIoUtilRecordsFetchesDownloadsAndCopies(t *testing.T) {
+	ioUtil := &mocks.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "http://registry/meta.yaml").Return([]byte("0123456789"), nil)
+	ioUtil.On("Download", mock.Anything, "http://host/ext.vsix", "/tmp", false, mock.Anything).
+		Run(func(args mock.Arguments) {
+			progress := args.Get(4).(func(int64, int64))
+			progress(50, 100)
+			progress(100, 100)
+		}).
+		Return("/tmp/ext.vsix", nil)
+	ioUtil.On("CopyFile", "/tmp/ext.vsix", "/plugins/ext.vsix").Return(errors.New("copy failed"))
+	metrics := NewMetrics("artifacts", nil)
+	timed := NewTimedIoUtil(ioUtil, metrics)
+
+	var reported int64
+	_, err := timed.Fetch(context.Background(), "http://registry/meta.yaml")
+	assert.NoError(t, err)
+	_, err = timed.Download(context.Background(), "http://host/ext.vsix", "/tmp", false, func(downloaded int64, total int64) {
+		reported = downloaded
+	})
+	assert.NoError(t, err)
+	err = timed.CopyFile("/tmp/ext.vsix", "/plugins/ext.vsix")
+	assert.EqualError(t, err, "copy failed")
+
+	report := metrics.Finish(model.StatusDone)
+	assert.Equal(t, int64(100), reported)
+	assert.Equal(t, "artifacts", report.Broker)
+	assert.Equal(t, model.StatusDone, report.Status)
+	assert.Equal(t, int64(110), report.BytesTransferred)
+	if assert.Len(t, report.Phases, 3) {
+		assert.Equal(t, PhaseFetch, report.Phases[0].Phase)
+		assert.Equal(t, "http://registry/meta.yaml", report.Phases[0].URL)
+		assert.Equal(t, int64(10), report.Phases[0].Bytes)
+		assert.Equal(t, PhaseDownload, report.Phases[1].Phase)
+		assert.Equal(t, "http://host/ext.vsix", report.Phases[1].URL)
+		assert.Equal(t, int64(100), report.Phases[1].Bytes)
+		assert.Equal(t, PhaseCopy, report.Phases[2].Phase)
+	}
+}
+
+func TestMetricsRedactsURLs(t *testing.T) {
+	metrics := NewMetrics("metadata", func(URL string) string {
+		return strings.Replace(URL, "token=secret", "token=xxxxx", 1)
+	})
+	metrics.StartPhase(PhaseFetch, "https://registry/meta.yaml?token=secret")(10)
+
+	report := metrics.Finish(model.StatusDone)
+
+	assert.Equal(t, "https://registry/meta.yaml?token=xxxxx", report.Phases[0].URL)
+}
+
+func TestRunReportSummaryAndJSON(t *testing.T) {
+	metrics := NewMetrics("metadata", nil)
+	metrics.StartPhase(PhaseFetch, "http://registry/a/meta.yaml")(20)
+	metrics.StartPhase(PhaseFetch, "http://registry/b/meta.yaml")(30)
+	metrics.StartPhase(PhaseValidation, "")(0)
+	metrics.AddCacheStats(2, 1)
+	report := metrics.Finish(model.StatusFailed)
+
+	summary := report.Summary()
+	assert.Equal(t, "Plugin metadata broker run summary", summary[0])
+	assert.Equal(t, "  Status: FAILED", summary[1])
+	assert.Regexp(t, `^  fetch: \d+\.\d{3}s$`, summary[4])
+	assert.Regexp(t, `^    http://registry/a/meta.yaml \(20 bytes\): \d+\.\d{3}s$`, summary[5])
+	assert.Regexp(t, `^    http://registry/b/meta.yaml \(30 bytes\): \d+\.\d{3}s$`, summary[6])
+	assert.Regexp(t, `^  validation: \d+\.\d{3}s$`, summary[7])
+	assert.Equal(t, "  Cache hits: 2, misses: 1", summary[9])
+	assert.Equal(t, "  Bytes transferred: 50", summary[10])
+
+	reportJSON, err := report.JSON()
+	assert.NoError(t, err)
+	var decoded RunReport
+	assert.NoError(t, json.Unmarshal(reportJSON, &decoded))
+	assert.Equal(t, report, decoded)
+}
+
+func TestRunReportPrometheus(t *testing.T) {
+	report := RunReport{
+		Broker:          "artifacts",
+		Workspace:       "workspace123",
+		Status:          model.StatusDone,
+		DurationSeconds: 1.5,
+		PluginsResolved: 2,
+		MergeSuccesses:  1,
+		Phases: []PhaseTiming{
+			{Phase: PhaseFetch, URL: "http://registry/a/meta.yaml", DurationSeconds: 0.2},
+			{Phase: PhaseFetch, URL: "http://registry/b/meta.yaml", DurationSeconds: 3},
+			{Phase: PhaseDownload, URL: "http://host/ext.vsix", Bytes: 1024, DurationSeconds: 1},
+		},
+		CacheHits:   3,
+		CacheMisses: 1,
+	}
+
+	text := string(report.Prometheus())
+
+	assert.Contains(t, text, "# TYPE che_plugin_broker_plugins_resolved gauge\n")
+	assert.Contains(t, text, "che_plugin_broker_plugins_resolved{broker=\"artifacts\",workspace_id=\"workspace123\"} 2\n")
+	assert.Contains(t, text, "# TYPE che_plugin_broker_registry_fetch_duration_seconds histogram\n")
+	assert.Contains(t, text, "che_plugin_broker_registry_fetch_duration_seconds_bucket{broker=\"artifacts\",workspace_id=\"workspace123\",le=\"0.1\"} 0\n")
+	assert.Contains(t, text, "che_plugin_broker_registry_fetch_duration_seconds_bucket{broker=\"artifacts\",workspace_id=\"workspace123\",le=\"0.25\"} 1\n")
+	assert.Contains(t, text, "che_plugin_broker_registry_fetch_duration_seconds_bucket{broker=\"artifacts\",workspace_id=\"workspace123\",le=\"5\"} 2\n")
+	assert.Contains(t, text, "che_plugin_broker_registry_fetch_duration_seconds_bucket{broker=\"artifacts\",workspace_id=\"workspace123\",le=\"+Inf\"} 2\n")
+	assert.Contains(t, text, "che_plugin_broker_registry_fetch_duration_seconds_sum{broker=\"artifacts\",workspace_id=\"workspace123\"} 3.2\n")
+	assert.Contains(t, text, "che_plugin_broker_registry_fetch_duration_seconds_count{broker=\"artifacts\",workspace_id=\"workspace123\"} 2\n")
+	assert.Contains(t, text, "che_plugin_broker_download_bytes{broker=\"artifacts\",workspace_id=\"workspace123\"} 1024\n")
+	assert.Contains(t, text, "che_plugin_broker_cache_hits{broker=\"artifacts\",workspace_id=\"workspace123\"} 3\n")
+	assert.Contains(t, text, "che_plugin_broker_cache_misses{broker=\"artifacts\",workspace_id=\"workspace123\"} 1\n")
+	assert.Contains(t, text, "che_plugin_broker_merge_successes{broker=\"artifacts\",workspace_id=\"workspace123\"} 1\n")
+	assert.Contains(t, text, "che_plugin_broker_merge_failures{broker=\"artifacts\",workspace_id=\"workspace123\"} 0\n")
+	assert.Contains(t, text, "che_plugin_broker_status{broker=\"artifacts\",workspace_id=\"workspace123\",status=\"DONE\"} 1\n")
+	assert.Contains(t, text, "che_plugin_broker_status{broker=\"artifacts\",workspace_id=\"workspace123\",status=\"FAILED\"} 0\n")
+	assert.NotContains(t, text, "counter")
+}
+
+func TestWritePrometheusTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "broker.prom")
+	report := RunReport{Broker: "metadata", Status: model.StatusFailed}
+
+	err = WritePrometheusTextfile(path, report)
+
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, report.Prometheus(), content)
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
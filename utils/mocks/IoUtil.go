@@ -2,6 +2,7 @@
 
 package mocks
 
+import context "context"
 import io "io"
 import mock "github.com/stretchr/testify/mock"
 
@@ -52,20 +53,20 @@ func (_m *IoUtil) CreateFile(file string, tr io.Reader) error {
 	return r0
 }
 
-// Download provides a mock function with given fields: URL, destPath, useContentDisposition
-func (_m *IoUtil) Download(URL string, destPath string, useContentDisposition bool) (string, error) {
-	ret := _m.Called(URL, destPath, useContentDisposition)
+// Download provides a mock function with given fields: ctx, URL, destPath, useContentDisposition, progress
+func (_m *IoUtil) Download(ctx context.Context, URL string, destPath string, useContentDisposition bool, progress func(int64, int64)) (string, error) {
+	ret := _m.Called(ctx, URL, destPath, useContentDisposition, progress)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, string, bool) string); ok {
-		r0 = rf(URL, destPath, useContentDisposition)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, bool, func(int64, int64)) string); ok {
+		r0 = rf(ctx, URL, destPath, useContentDisposition, progress)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string, bool) error); ok {
-		r1 = rf(URL, destPath, useContentDisposition)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, bool, func(int64, int64)) error); ok {
+		r1 = rf(ctx, URL, destPath, useContentDisposition, progress)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -73,13 +74,13 @@ func (_m *IoUtil) Download(URL string, destPath string, useContentDisposition bo
 	return r0, r1
 }
 
-// Fetch provides a mock function with given fields: url
-func (_m *IoUtil) Fetch(url string) ([]byte, error) {
-	ret := _m.Called(url)
+// Fetch provides a mock function with given fields: ctx, url
+func (_m *IoUtil) Fetch(ctx context.Context, url string) ([]byte, error) {
+	ret := _m.Called(ctx, url)
 
 	var r0 []byte
-	if rf, ok := ret.Get(0).(func(string) []byte); ok {
-		r0 = rf(url)
+	if rf, ok := ret.Get(0).(func(context.Context, string) []byte); ok {
+		r0 = rf(ctx, url)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]byte)
@@ -87,8 +88,8 @@ func (_m *IoUtil) Fetch(url string) ([]byte, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(url)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, url)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -133,6 +134,20 @@ func (_m *IoUtil) GetFilesByGlob(glob string) ([]string, error) {
 	return r0, r1
 }
 
+// LinkFile provides a mock function with given fields: src, dest
+func (_m *IoUtil) LinkFile(src string, dest string) error {
+	ret := _m.Called(src, dest)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(src, dest)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // MkDir provides a mock function with given fields: _a0
 func (_m *IoUtil) MkDir(_a0 string) error {
 	ret := _m.Called(_a0)
@@ -0,0 +1,183 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+// ParsePluginDependency converts an entry of the dependencies list of a meta.yaml
+// to a plugin FQN. An entry can be a URL of a meta.yaml file, a plugin ID prefixed
+// with registry URL, e.g. 'https://registry.io/publisher/name/version', or a plugin ID,
+// which is resolved against registry.
+func ParsePluginDependency(dependency string, registry string) model.PluginFQN {
+	dependency = strings.TrimSuffix(dependency, "/")
+	if strings.HasSuffix(dependency, ".yaml") {
+		return model.PluginFQN{Reference: dependency}
+	}
+	if strings.Contains(dependency, "://") {
+		parts := strings.Split(dependency, "/")
+		// Scheme, empty segment and host are followed by at least 3 segments of plugin ID
+		if len(parts) >= 6 {
+			return model.PluginFQN{
+				Registry: strings.Join(parts[:len(parts)-3], "/"),
+				ID:       strings.Join(parts[len(parts)-3:], "/"),
+			}
+		}
+	}
+	return model.PluginFQN{Registry: registry, ID: dependency}
+}
+
+// dependencyResolver adds plugins required by plugin metas to the list of metas,
+// transitively
+type dependencyResolver struct {
+	ctx               context.Context
+	defaultRegistries []string
+	ioUtil            IoUtil
+	resolving         func(progressID string)
+
+	metas     []model.PluginMeta
+	requested int
+	// resolved maps references and 'publisher/name' of plugins to their index in metas,
+	// so that each plugin is included in a single version
+	resolved map[string]int
+	// path is the chain of plugins whose dependencies are being resolved
+	path []string
+}
+
+// resolveDependencies appends plugins required by metas, which were retrieved for
+// requested plugin FQNs, to metas. Each plugin is included once, in the version that is
+// requested explicitly or, for implicit plugins, required first; other required versions
+// and dependency cycles are reported as warnings of the dependent plugin and otherwise
+// ignored. Dependencies given by plugin ID are resolved against the registry that served
+// the dependent plugin. Added plugins are reported to resolving, if it is not nil.
+func resolveDependencies(ctx context.Context, requested []model.PluginFQN, metas []model.PluginMeta, defaultRegistries []string, ioUtil IoUtil, resolving func(progressID string)) ([]model.PluginMeta, error) {
+	r := &dependencyResolver{
+		ctx:               ctx,
+		defaultRegistries: defaultRegistries,
+		ioUtil:            ioUtil,
+		resolving:         resolving,
+		metas:             metas,
+		requested:         len(metas),
+		resolved:          map[string]int{},
+	}
+	for i, meta := range metas {
+		if _, ok := r.resolved[pluginName(meta.ID)]; !ok {
+			r.resolved[pluginName(meta.ID)] = i
+		}
+		if requested[i].Reference != "" {
+			r.resolved[requested[i].Reference] = i
+		}
+	}
+	for i := range requested {
+		if err := r.resolve(i, dependencyRegistry(requested[i], metas[i])); err != nil {
+			return nil, err
+		}
+	}
+	return r.metas, nil
+}
+
+func (r *dependencyResolver) resolve(index int, registry string) error {
+	pluginID := r.metas[index].ID
+	dependencies := r.metas[index].Dependencies
+	r.path = append(r.path, pluginID)
+	defer func() { r.path = r.path[:len(r.path)-1] }()
+
+	for _, dependency := range dependencies {
+		fqn := ParsePluginDependency(dependency, registry)
+		key := fqn.Reference
+		if key == "" {
+			key = pluginName(fqn.ID)
+		}
+		if r.isResolved(key, fqn.ID, index) {
+			continue
+		}
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		meta, err := GetPluginMeta(r.ctx, fqn, r.defaultRegistries, r.ioUtil)
+		if err != nil {
+			return fmt.Errorf("failed to resolve dependency '%s' of plugin '%s': %w", dependency, pluginID, err)
+		}
+		// A plugin required by reference may have been already resolved by ID
+		if i, ok := r.resolved[pluginName(meta.ID)]; ok {
+			r.resolved[key] = i
+			r.isResolved(key, meta.ID, index)
+			continue
+		}
+		meta.RequiredBy = []string{pluginID}
+		r.metas = append(r.metas, *meta)
+		if r.resolving != nil {
+			r.resolving(meta.ProgressID)
+		}
+		r.resolved[pluginName(meta.ID)] = len(r.metas) - 1
+		r.resolved[key] = len(r.metas) - 1
+		if err := r.resolve(len(r.metas)-1, dependencyRegistry(fqn, *meta)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isResolved checks whether plugin with key, required in version of requiredID, is already
+// included and, if it is an implicit plugin, records that it is required by the plugin at
+// dependent index
+func (r *dependencyResolver) isResolved(key string, requiredID string, dependent int) bool {
+	i, ok := r.resolved[key]
+	if !ok {
+		return false
+	}
+	dependentID := r.metas[dependent].ID
+	for _, id := range r.path {
+		if id == r.metas[i].ID {
+			r.warn(dependent, fmt.Sprintf("Plugin dependency cycle detected: %s -> %s", strings.Join(r.path, " -> "), id))
+			return true
+		}
+	}
+	if requiredID != "" && requiredID != r.metas[i].ID && requiredID != r.metas[i].RequestedID {
+		r.warn(dependent, fmt.Sprintf("Plugin %s requires %s, but %s is included in the workspace", dependentID, requiredID, r.metas[i].ID))
+	}
+	if i >= r.requested && !ListContains(dependentID, r.metas[i].RequiredBy) {
+		r.metas[i].RequiredBy = append(r.metas[i].RequiredBy, dependentID)
+	}
+	return true
+}
+
+// warn records a problem with dependencies of the plugin at index
+func (r *dependencyResolver) warn(index int, warning string) {
+	if !ListContains(warning, r.metas[index].Warnings) {
+		r.metas[index].Warnings = append(r.metas[index].Warnings, warning)
+	}
+}
+
+// dependencyRegistry returns the registry that dependencies of meta, which was retrieved
+// for plugin, are resolved against: the registry that served it. Dependencies of plugins
+// retrieved by reference are resolved against default registries.
+func dependencyRegistry(plugin model.PluginFQN, meta model.PluginMeta) string {
+	if plugin.Reference != "" {
+		return ""
+	}
+	return meta.Source
+}
+
+// pluginName returns the 'publisher/name' part of plugin ID 'publisher/name/version'
+func pluginName(id string) string {
+	if i := strings.LastIndex(id, "/"); i > 0 {
+		return id[:i]
+	}
+	return id
+}
@@ -0,0 +1,186 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/yaml.v2"
+)
+
+func generatePluginMetaWithDependencies(t *testing.T, id string, dependencies ...string) (model.PluginMeta, []byte) {
+	meta, _ := generatePluginMeta(t, id)
+	meta.Dependencies = dependencies
+	metaRaw, err := yaml.Marshal(meta)
+	if err != nil {
+		t.Fatal("Failed to marshal yaml")
+	}
+	return meta, metaRaw
+}
+
+func TestParsePluginDependency(t *testing.T) {
+	tests := []struct {
+		dependency string
+		registry   string
+		want       model.PluginFQN
+	}{
+		{
+			dependency: "pub/name/ver",
+			registry:   "",
+			want:       model.PluginFQN{ID: "pub/name/ver"},
+		},
+		{
+			dependency: "pub/name/ver",
+			registry:   "https://registry.io",
+			want:       model.PluginFQN{Registry: "https://registry.io", ID: "pub/name/ver"},
+		},
+		{
+			dependency: "https://other.io/v3/pub/name/ver",
+			registry:   "https://registry.io",
+			want:       model.PluginFQN{Registry: "https://other.io/v3", ID: "pub/name/ver"},
+		},
+		{
+			dependency: "https://other.io/pub/name/ver/meta.yaml",
+			registry:   "https://registry.io",
+			want:       model.PluginFQN{Reference: "https://other.io/pub/name/ver/meta.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dependency, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParsePluginDependency(tt.dependency, tt.registry))
+		})
+	}
+}
+
+func TestGetPluginMetasResolvesDependenciesTransitively(t *testing.T) {
+	debugger, debuggerRaw := generatePluginMetaWithDependencies(t, "pub/debugger/1.0", "pub/java/1.0")
+	java, javaRaw := generatePluginMetaWithDependencies(t, "pub/java/1.0", "pub/jdk/1.0")
+	jdk, jdkRaw := generatePluginMetaWithDependencies(t, "pub/jdk/1.0")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/debugger/1.0/meta.yaml").Return(debuggerRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/java/1.0/meta.yaml").Return(javaRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/jdk/1.0/meta.yaml").Return(jdkRaw, nil)
+
+	var resolving []string
+	got, err := GetPluginMetas(context.Background(), []model.PluginFQN{generatePluginFQN("reg", "pub/debugger/1.0", "")}, nil, ioUtil,
+		func(progressID string) { resolving = append(resolving, progressID) })
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pub/java/1.0", "pub/jdk/1.0"}, resolving)
+	java.RequiredBy = []string{"pub/debugger/1.0"}
+	jdk.RequiredBy = []string{"pub/java/1.0"}
+	debugger.ProgressID, java.ProgressID, jdk.ProgressID = "pub/debugger/1.0", "pub/java/1.0", "pub/jdk/1.0"
+	debugger.Source, java.Source, jdk.Source = "reg", "reg", "reg"
+	assert.Equal(t, []model.PluginMeta{debugger, java, jdk}, got)
+}
+
+func TestGetPluginMetasDeduplicatesDependencies(t *testing.T) {
+	debugger, debuggerRaw := generatePluginMetaWithDependencies(t, "pub/debugger/1.0", "pub/java/1.0", "pub/jdk/1.0")
+	java, javaRaw := generatePluginMetaWithDependencies(t, "pub/java/1.0", "pub/jdk/1.0")
+	jdk, jdkRaw := generatePluginMetaWithDependencies(t, "pub/jdk/1.0")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/debugger/1.0/meta.yaml").Return(debuggerRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/java/1.0/meta.yaml").Return(javaRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/jdk/1.0/meta.yaml").Return(jdkRaw, nil)
+
+	// Java plugin is requested explicitly, so it is not an implicit plugin
+	got, err := GetPluginMetas(context.Background(), []model.PluginFQN{
+		generatePluginFQN("reg", "pub/debugger/1.0", ""),
+		generatePluginFQN("reg", "pub/java/1.0", ""),
+	}, nil, ioUtil, nil)
+
+	assert.NoError(t, err)
+	jdk.RequiredBy = []string{"pub/debugger/1.0", "pub/java/1.0"}
+	debugger.ProgressID, java.ProgressID, jdk.ProgressID = "pub/debugger/1.0", "pub/java/1.0", "pub/jdk/1.0"
+	debugger.Source, java.Source, jdk.Source = "reg", "reg", "reg"
+	assert.Equal(t, []model.PluginMeta{debugger, java, jdk}, got)
+	ioUtil.AssertNumberOfCalls(t, "Fetch", 3)
+}
+
+func TestGetPluginMetasStopsOnDependencyCycle(t *testing.T) {
+	first, firstRaw := generatePluginMetaWithDependencies(t, "pub/first/1.0", "pub/second/1.0")
+	second, secondRaw := generatePluginMetaWithDependencies(t, "pub/second/1.0", "pub/first/1.0")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, defaultRegistry+"/plugins/pub/first/1.0/meta.yaml").Return(firstRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, defaultRegistry+"/plugins/pub/second/1.0/meta.yaml").Return(secondRaw, nil)
+
+	got, err := GetPluginMetas(context.Background(), []model.PluginFQN{generatePluginFQN("", "pub/first/1.0", "")}, []string{defaultRegistry}, ioUtil, nil)
+
+	assert.NoError(t, err)
+	second.RequiredBy = []string{"pub/first/1.0"}
+	first.ProgressID, second.ProgressID = "pub/first/1.0", "pub/second/1.0"
+	first.Source, second.Source = defaultRegistry, defaultRegistry
+	second.Warnings = []string{"Plugin dependency cycle detected: pub/first/1.0 -> pub/second/1.0 -> pub/first/1.0"}
+	assert.Equal(t, []model.PluginMeta{first, second}, got)
+	ioUtil.AssertNumberOfCalls(t, "Fetch", 2)
+}
+
+func TestGetPluginMetasReportsDependencyError(t *testing.T) {
+	_, debuggerRaw := generatePluginMetaWithDependencies(t, "pub/debugger/1.0", "pub/java/1.0")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/debugger/1.0/meta.yaml").Return(debuggerRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/java/1.0/meta.yaml").Return(nil, errors.New("test error"))
+
+	_, err := GetPluginMetas(context.Background(), []model.PluginFQN{generatePluginFQN("reg", "pub/debugger/1.0", "")}, nil, ioUtil, nil)
+
+	assert.EqualError(t, err, "failed to resolve dependency 'pub/java/1.0' of plugin 'pub/debugger/1.0': "+
+		"failed to fetch plugin meta.yaml from URL 'reg/plugins/pub/java/1.0/meta.yaml': test error")
+	details := GetErrorDetails(err)
+	if assert.NotNil(t, details) {
+		assert.Equal(t, model.ErrorCodeRegistryUnreachable, details.Code)
+		assert.Equal(t, "pub/java/1.0", details.PluginID)
+	}
+}
+
+func TestGetPluginMetasKeepsExplicitlyRequestedVersion(t *testing.T) {
+	debugger, debuggerRaw := generatePluginMetaWithDependencies(t, "pub/debugger/1.0", "pub/java/1.1")
+	java, javaRaw := generatePluginMetaWithDependencies(t, "pub/java/1.0")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/debugger/1.0/meta.yaml").Return(debuggerRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg/plugins/pub/java/1.0/meta.yaml").Return(javaRaw, nil)
+
+	got, err := GetPluginMetas(context.Background(), []model.PluginFQN{
+		generatePluginFQN("reg", "pub/debugger/1.0", ""),
+		generatePluginFQN("reg", "pub/java/1.0", ""),
+	}, nil, ioUtil, nil)
+
+	assert.NoError(t, err)
+	debugger.Source, java.Source = "reg", "reg"
+	debugger.ProgressID, java.ProgressID = "pub/debugger/1.0", "pub/java/1.0"
+	debugger.Warnings = []string{"Plugin pub/debugger/1.0 requires pub/java/1.1, but pub/java/1.0 is included in the workspace"}
+	assert.Equal(t, []model.PluginMeta{debugger, java}, got)
+	ioUtil.AssertNumberOfCalls(t, "Fetch", 2)
+}
+
+func TestGetPluginMetasResolvesDependenciesAgainstRegistryOfDependentPlugin(t *testing.T) {
+	_, debuggerRaw := generatePluginMetaWithDependencies(t, "pub/debugger/1.0", "pub/java/1.0")
+	_, javaRaw := generatePluginMetaWithDependencies(t, "pub/java/1.0")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "reg1/plugins/pub/debugger/1.0/meta.yaml").Return(nil, &HTTPError{StatusCode: 404})
+	ioUtil.On("Fetch", mock.Anything, "reg2/plugins/pub/debugger/1.0/meta.yaml").Return(debuggerRaw, nil)
+	ioUtil.On("Fetch", mock.Anything, "reg2/plugins/pub/java/1.0/meta.yaml").Return(javaRaw, nil)
+
+	got, err := GetPluginMetas(context.Background(), []model.PluginFQN{generatePluginFQN("", "pub/debugger/1.0", "")}, []string{"reg1", "reg2"}, ioUtil, nil)
+
+	assert.NoError(t, err)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, "reg2", got[1].Source)
+	}
+	ioUtil.AssertNotCalled(t, "Fetch", mock.Anything, "reg1/plugins/pub/java/1.0/meta.yaml")
+}
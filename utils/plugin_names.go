@@ -31,6 +31,15 @@ func ConvertIDToUniqueName(id string) string {
 	return re.ReplaceAllString(id, "_")
 }
 
+// GetPluginFQNID returns the ID of a requested plugin, or its reference URL if the plugin
+// is requested by reference only
+func GetPluginFQNID(plugin model.PluginFQN) string {
+	if plugin.ID != "" {
+		return plugin.ID
+	}
+	return plugin.Reference
+}
+
 func SanitizeImage(image string) string {
 	return re.ReplaceAllString(image, "-")
 }
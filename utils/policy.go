@@ -0,0 +1,208 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"gopkg.in/yaml.v2"
+)
+
+// PolicyRules are lists of patterns that values must match (Allow) or must not match
+// (Deny). Deny takes precedence; an empty Allow list allows any value.
+type PolicyRules struct {
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
+}
+
+// Policy restricts plugins that can be used in workspaces
+type Policy struct {
+	// Plugins are globs of plugin IDs, as accepted by path.Match, e.g. 'redhat/java/*'
+	Plugins PolicyRules `yaml:"plugins,omitempty"`
+	// Publishers are globs of plugin publishers
+	Publishers PolicyRules `yaml:"publishers,omitempty"`
+	// Registries are URLs of registries, or references, that serve plugin metas. A URL
+	// matches itself and URLs with the same scheme and host below its path.
+	Registries PolicyRules `yaml:"registries,omitempty"`
+	// ExtensionHosts are globs of hosts plugin extensions are downloaded from. Extensions
+	// without host, e.g. in local plugin bundles, are checked as their URL scheme followed
+	// by a colon, e.g. 'file:', and must always be allowed explicitly.
+	ExtensionHosts PolicyRules `yaml:"extensionHosts,omitempty"`
+	// Images are container image repositories of plugins, or registries and namespaces
+	// that contain them, e.g. 'quay.io/eclipse'
+	Images PolicyRules `yaml:"images,omitempty"`
+}
+
+type policyMatcher func(pattern string, value string) bool
+
+func matchesGlob(pattern string, value string) bool {
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// matchesURL checks whether value has the scheme and host of pattern, and its path
+// starts with the path segments of pattern, e.g. 'https://registry.io/v3' matches
+// 'https://registry.io/v3/plugins' but not 'https://registry.io/v30'
+func matchesURL(pattern string, value string) bool {
+	patternURL, err := url.Parse(pattern)
+	if err != nil {
+		return false
+	}
+	valueURL, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(patternURL.Scheme, valueURL.Scheme) &&
+		strings.EqualFold(patternURL.Host, valueURL.Host) &&
+		hasPathSegments(valueURL.Path, patternURL.Path)
+}
+
+// matchesImage checks whether image is in the repository, or the registry or namespace,
+// given by pattern, e.g. 'quay.io/eclipse' matches 'quay.io/eclipse/java:1.0' but not
+// 'quay.io/eclipse-other/java:1.0'. A pattern with tag or digest matches only that image.
+func matchesImage(pattern string, image string) bool {
+	if pattern == image {
+		return true
+	}
+	repository := image
+	if idx := strings.Index(repository, "@"); idx >= 0 {
+		repository = repository[:idx]
+	}
+	if idx := strings.LastIndex(repository, ":"); idx > strings.LastIndex(repository, "/") {
+		repository = repository[:idx]
+	}
+	return hasPathSegments(repository, pattern)
+}
+
+// hasPathSegments checks whether the slash-separated segments of prefix are the first
+// segments of value
+func hasPathSegments(value string, prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	value = strings.Trim(value, "/")
+	return value == prefix || strings.HasPrefix(value, prefix+"/")
+}
+
+// LoadPolicy reads the policy from the YAML file at path
+func LoadPolicy(policyPath string, ioUtil IoUtil) (*Policy, error) {
+	data, err := ioUtil.ReadFile(policyPath)
+	if err != nil {
+		return nil, NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+			"failed to read policy file '%s': %s", policyPath, err)
+	}
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+			"failed to parse policy file '%s': %s", policyPath, err)
+	}
+	globRules := []struct {
+		name  string
+		rules PolicyRules
+	}{
+		{"plugins", policy.Plugins},
+		{"publishers", policy.Publishers},
+		{"extensionHosts", policy.ExtensionHosts},
+	}
+	for _, glob := range globRules {
+		for _, patterns := range [][]string{glob.rules.Allow, glob.rules.Deny} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+						"invalid pattern '%s' in rules '%s' of policy file '%s'", pattern, glob.name, policyPath)
+				}
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Check returns an error naming the violated rule if any of metas is not allowed by
+// the policy. Relative extension paths are checked against the host of the plugin source.
+func (p *Policy) Check(metas ...model.PluginMeta) error {
+	for _, meta := range metas {
+		if err := p.check(meta, "plugins", "plugin", meta.ID, p.Plugins, matchesGlob, false); err != nil {
+			return err
+		}
+		if err := p.check(meta, "publishers", "publisher", meta.Publisher, p.Publishers, matchesGlob, false); err != nil {
+			return err
+		}
+		if meta.Source != "" {
+			if err := p.check(meta, "registries", "registry", meta.Source, p.Registries, matchesURL, false); err != nil {
+				return err
+			}
+		}
+		for _, containers := range [][]model.Container{meta.Spec.Containers, meta.Spec.InitContainers} {
+			for _, container := range containers {
+				if err := p.check(meta, "images", "image", container.Image, p.Images, matchesImage, false); err != nil {
+					return err
+				}
+			}
+		}
+		for _, extension := range meta.Spec.Extensions {
+			// Extensions without host must match an allow rule explicitly
+			host, hasHost := extensionHost(meta, extension)
+			if err := p.check(meta, "extensionHosts", "extension host", host, p.ExtensionHosts, matchesGlob, !hasHost); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check checks value against rules. If explicit is set, value must match an allow pattern
+// even if there are no allow patterns.
+func (p *Policy) check(meta model.PluginMeta, rulesName string, kind string, value string, rules PolicyRules, matches policyMatcher, explicit bool) error {
+	for i, pattern := range rules.Deny {
+		if matches(pattern, value) {
+			rule := fmt.Sprintf("%s.deny[%d]", rulesName, i)
+			return NewBrokerError(
+				model.ErrorDetails{Code: model.ErrorCodePolicyViolation, PluginID: meta.ID, Rule: rule},
+				"plugin '%s' is not allowed by policy: %s '%s' matches rule %s '%s'", meta.ID, kind, value, rule, pattern)
+		}
+	}
+	if len(rules.Allow) == 0 && !explicit {
+		return nil
+	}
+	for _, pattern := range rules.Allow {
+		if matches(pattern, value) {
+			return nil
+		}
+	}
+	rule := rulesName + ".allow"
+	return NewBrokerError(
+		model.ErrorDetails{Code: model.ErrorCodePolicyViolation, PluginID: meta.ID, Rule: rule},
+		"plugin '%s' is not allowed by policy: %s '%s' does not match any pattern of rule %s", meta.ID, kind, value, rule)
+}
+
+// extensionHost returns the host an extension is downloaded from. For extensions
+// without host, e.g. in local plugin bundles, it returns false and the URL scheme followed
+// by a colon, or the extension itself if it is not a valid URL.
+func extensionHost(meta model.PluginMeta, extension string) (string, bool) {
+	if strings.HasPrefix(extension, "relative:extension/") {
+		extension = meta.Source
+	}
+	extensionURL, err := url.Parse(extension)
+	if err != nil {
+		return extension, false
+	}
+	if host := extensionURL.Hostname(); host != "" {
+		return host, true
+	}
+	return extensionURL.Scheme + ":", false
+}
@@ -0,0 +1,190 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `
+plugins:
+  deny: ["*/*/next"]
+publishers:
+  allow: [redhat, eclipse]
+registries:
+  allow: ["https://registry.internal.io"]
+extensionHosts:
+  allow: ["github.com", "*.internal.io"]
+images:
+  allow: ["quay.io/eclipse/"]
+  deny: ["quay.io/eclipse/untrusted"]
+`
+
+func generatePolicyTestMeta() model.PluginMeta {
+	return model.PluginMeta{
+		ID:        "redhat/java/1.0",
+		Publisher: "redhat",
+		Source:    "https://registry.internal.io/v3",
+		Spec: model.PluginMetaSpec{
+			Containers:     []model.Container{{Image: "quay.io/eclipse/java:1.0"}},
+			InitContainers: []model.Container{{Image: "quay.io/eclipse/init:1.0"}},
+			Extensions:     []string{"https://github.com/redhat/java.vsix", "relative:extension/java.vsix"},
+		},
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("ReadFile", "/policy.yaml").Return([]byte(testPolicy), nil)
+	policy, err := LoadPolicy("/policy.yaml", ioUtil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		modify   func(meta *model.PluginMeta)
+		wantRule string
+		wantErr  string
+	}{
+		{
+			name:   "Allows plugin that matches all rules",
+			modify: func(meta *model.PluginMeta) {},
+		},
+		{
+			name:     "Denies plugin ID",
+			modify:   func(meta *model.PluginMeta) { meta.ID = "redhat/java/next" },
+			wantRule: "plugins.deny[0]",
+			wantErr:  "plugin 'redhat/java/next' is not allowed by policy: plugin 'redhat/java/next' matches rule plugins.deny[0] '*/*/next'",
+		},
+		{
+			name:     "Denies publisher that is not allowed",
+			modify:   func(meta *model.PluginMeta) { meta.Publisher = "other" },
+			wantRule: "publishers.allow",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: publisher 'other' does not match any pattern of rule publishers.allow",
+		},
+		{
+			name:     "Denies registry that is not allowed",
+			modify:   func(meta *model.PluginMeta) { meta.Source = "https://registry.io/v3" },
+			wantRule: "registries.allow",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: registry 'https://registry.io/v3' does not match any pattern of rule registries.allow",
+		},
+		{
+			name:     "Denies image",
+			modify:   func(meta *model.PluginMeta) { meta.Spec.Containers[0].Image = "quay.io/eclipse/untrusted:1.0" },
+			wantRule: "images.deny[0]",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: image 'quay.io/eclipse/untrusted:1.0' matches rule images.deny[0] 'quay.io/eclipse/untrusted'",
+		},
+		{
+			name:     "Denies init container image that is not allowed",
+			modify:   func(meta *model.PluginMeta) { meta.Spec.InitContainers[0].Image = "docker.io/init:1.0" },
+			wantRule: "images.allow",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: image 'docker.io/init:1.0' does not match any pattern of rule images.allow",
+		},
+		{
+			name:     "Denies extension host that is not allowed",
+			modify:   func(meta *model.PluginMeta) { meta.Spec.Extensions[0] = "https://downloads.io/java.vsix" },
+			wantRule: "extensionHosts.allow",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: extension host 'downloads.io' does not match any pattern of rule extensionHosts.allow",
+		},
+		{
+			name:     "Denies registry with allowed host as prefix",
+			modify:   func(meta *model.PluginMeta) { meta.Source = "https://registry.internal.io.evil.com/v3" },
+			wantRule: "registries.allow",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: registry 'https://registry.internal.io.evil.com/v3' does not match any pattern of rule registries.allow",
+		},
+		{
+			name:     "Denies image in namespace with allowed namespace as prefix",
+			modify:   func(meta *model.PluginMeta) { meta.Spec.Containers[0].Image = "quay.io/eclipse-evil/java:1.0" },
+			wantRule: "images.allow",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: image 'quay.io/eclipse-evil/java:1.0' does not match any pattern of rule images.allow",
+		},
+		{
+			name:     "Denies extension without host that is not allowed explicitly",
+			modify:   func(meta *model.PluginMeta) { meta.Spec.Extensions[0] = "file:///bundles/java.vsix" },
+			wantRule: "extensionHosts.allow",
+			wantErr:  "plugin 'redhat/java/1.0' is not allowed by policy: extension host 'file:' does not match any pattern of rule extensionHosts.allow",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := generatePolicyTestMeta()
+			tt.modify(&meta)
+
+			err := policy.Check(meta)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, &model.ErrorDetails{
+				Code:     model.ErrorCodePolicyViolation,
+				PluginID: meta.ID,
+				Rule:     tt.wantRule,
+			}, GetErrorDetails(err))
+		})
+	}
+}
+
+func TestPolicyCheckRequiresExplicitRuleForExtensionsWithoutHost(t *testing.T) {
+	meta := generatePolicyTestMeta()
+	meta.Spec.Extensions = []string{"file:///bundles/java.vsix"}
+
+	err := (&Policy{}).Check(meta)
+	assert.EqualError(t, err, "plugin 'redhat/java/1.0' is not allowed by policy: "+
+		"extension host 'file:' does not match any pattern of rule extensionHosts.allow")
+
+	err = (&Policy{ExtensionHosts: PolicyRules{Allow: []string{"file:"}}}).Check(meta)
+	assert.NoError(t, err)
+}
+
+func TestLoadPolicyReportsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		readErr error
+		wantErr string
+	}{
+		{
+			name:    "Missing file",
+			readErr: fmt.Errorf("file not found"),
+			wantErr: "failed to read policy file '/policy.yaml': file not found",
+		},
+		{
+			name:    "Unknown rules",
+			data:    []byte("containers:\n  allow: [quay.io/]"),
+			wantErr: "failed to parse policy file '/policy.yaml': yaml: unmarshal errors:\n  line 1: field containers not found in type utils.Policy",
+		},
+		{
+			name:    "Malformed pattern",
+			data:    []byte("extensionHosts:\n  deny: ['[']"),
+			wantErr: "invalid pattern '[' in rules 'extensionHosts' of policy file '/policy.yaml'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ioUtil := &utilMock.IoUtil{}
+			ioUtil.On("ReadFile", "/policy.yaml").Return(tt.data, tt.readErr)
+
+			_, err := LoadPolicy("/policy.yaml", ioUtil)
+
+			assert.EqualError(t, err, tt.wantErr)
+			assert.Equal(t, model.ErrorCodeInvalidConfig, GetErrorDetails(err).Code)
+		})
+	}
+}
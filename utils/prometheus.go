@@ -0,0 +1,125 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+const metricsPrefix = "che_plugin_broker_"
+
+// fetchLatencyBuckets are upper bounds of registry fetch latency histogram buckets, in seconds
+var fetchLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Prometheus formats the report as metrics in Prometheus text exposition format. The
+// textfile is replaced by each broker run, so values of the run are exposed as gauges
+// rather than counters, labelled with the workspace of the run.
+func (r RunReport) Prometheus() []byte {
+	buf := &bytes.Buffer{}
+	labels := fmt.Sprintf(`broker="%s"`, r.Broker)
+	if r.Workspace != "" {
+		labels += fmt.Sprintf(`,workspace_id="%s"`, r.Workspace)
+	}
+
+	writeMetric(buf, "plugins_resolved", "gauge", "Number of plugins resolved from registries in the last broker run", labels, float64(r.PluginsResolved))
+
+	var fetches []float64
+	var downloadBytes int64
+	for _, timing := range r.Phases {
+		switch timing.Phase {
+		case PhaseFetch:
+			fetches = append(fetches, timing.DurationSeconds)
+		case PhaseDownload:
+			downloadBytes += timing.Bytes
+		}
+	}
+	writeHistogram(buf, "registry_fetch_duration_seconds", "Latency of requests to plugin registries", labels, fetchLatencyBuckets, fetches)
+
+	writeMetric(buf, "download_bytes", "gauge", "Number of bytes of plugin artifacts downloaded in the last broker run", labels, float64(downloadBytes))
+	writeMetric(buf, "cache_hits", "gauge", "Number of extensions found in plugins cache in the last broker run", labels, float64(r.CacheHits))
+	writeMetric(buf, "cache_misses", "gauge", "Number of extensions missing from plugins cache in the last broker run", labels, float64(r.CacheMisses))
+	writeMetric(buf, "merge_successes", "gauge", "Number of groups of plugins merged into a single container in the last broker run", labels, float64(r.MergeSuccesses))
+	writeMetric(buf, "merge_failures", "gauge", "Number of groups of plugins that could not be merged in the last broker run", labels, float64(r.MergeFailures))
+	writeMetric(buf, "run_duration_seconds", "gauge", "Duration of the broker run", labels, r.DurationSeconds)
+
+	name := metricsPrefix + "status"
+	fmt.Fprintf(buf, "# HELP %s Final status of the broker run\n# TYPE %s gauge\n", name, name)
+	for _, status := range []model.BrokerStatus{model.StatusDone, model.StatusFailed} {
+		value := 0
+		if r.Status == status {
+			value = 1
+		}
+		fmt.Fprintf(buf, "%s{%s,status=\"%s\"} %d\n", name, labels, status, value)
+	}
+	return buf.Bytes()
+}
+
+func writeMetric(buf *bytes.Buffer, name string, metricType string, help string, labels string, value float64) {
+	name = metricsPrefix + name
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	fmt.Fprintf(buf, "%s{%s} %s\n", name, labels, formatFloat(value))
+}
+
+func writeHistogram(buf *bytes.Buffer, name string, help string, labels string, buckets []float64, values []float64) {
+	name = metricsPrefix + name
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	for _, bound := range buckets {
+		count := 0
+		for _, value := range values {
+			if value <= bound {
+				count++
+			}
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), count)
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, len(values))
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, formatFloat(sum))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, len(values))
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WritePrometheusTextfile writes metrics of the report to the file at path. The file
+// is replaced atomically, so that collectors never read a partially written file.
+func WritePrometheusTextfile(path string, report RunReport) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(report.Prometheus()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
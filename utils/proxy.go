@@ -0,0 +1,237 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DirectProxy is the proxy of an override that disables proxying for matching hosts
+const DirectProxy = "direct"
+
+// ProxyOverride sets the proxy for requests to hosts matching Host, which is a pattern
+// as accepted by path.Match, e.g. '*.registry.io' or 'registry.io:8443'
+type ProxyOverride struct {
+	Host  string
+	Proxy string
+}
+
+// ProxyConfig determines the proxy used for each request of the brokers
+type ProxyConfig struct {
+	// HTTPProxy is the proxy for http:// and ws:// URLs
+	HTTPProxy string
+	// HTTPSProxy is the proxy for https:// and wss:// URLs
+	HTTPSProxy string
+	// NoProxy is a comma-separated list of hosts that are accessed directly. Entries can be
+	// host names, which also match their subdomains, domains starting with '.', IP addresses
+	// and CIDR ranges, optionally followed by a port, or '*' to disable proxying.
+	NoProxy string
+	// Overrides take precedence over other settings; the first matching override is used
+	Overrides []ProxyOverride
+	// logged holds hosts whose proxy has been logged, if logging of proxies is enabled
+	logged *sync.Map
+}
+
+// ParseProxyOverrides parses a comma-separated list of 'host=proxy' pairs, where proxy
+// is either a proxy URL or 'direct'
+func ParseProxyOverrides(value string) ([]ProxyOverride, error) {
+	var overrides []ProxyOverride
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("proxy override '%s' is not in format 'host=proxy'", pair)
+		}
+		overrides = append(overrides, ProxyOverride{Host: strings.TrimSpace(parts[0]), Proxy: strings.TrimSpace(parts[1])})
+	}
+	return overrides, nil
+}
+
+// Validate checks that proxies are valid URLs and host patterns are well-formed
+func (c ProxyConfig) Validate() error {
+	for _, proxy := range []string{c.HTTPProxy, c.HTTPSProxy} {
+		if _, err := parseProxyURL(proxy); err != nil {
+			return err
+		}
+	}
+	for _, override := range c.Overrides {
+		if _, err := path.Match(override.Host, ""); err != nil {
+			return fmt.Errorf("host pattern '%s' of proxy override is malformed", override.Host)
+		}
+		if override.Proxy == DirectProxy {
+			continue
+		}
+		if _, err := parseProxyURL(override.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProxyURL returns the proxy for requests to URL, or nil if URL is accessed directly.
+// Loopback addresses are accessed directly unless an override matches them.
+func (c ProxyConfig) ProxyURL(URL *url.URL) (*url.URL, error) {
+	for _, override := range c.Overrides {
+		if matchesHost(override.Host, URL) {
+			if override.Proxy == DirectProxy {
+				return nil, nil
+			}
+			return parseProxyURL(override.Proxy)
+		}
+	}
+	if isLoopback(URL.Hostname()) || c.isNoProxy(URL) {
+		return nil, nil
+	}
+	switch URL.Scheme {
+	case "https", "wss":
+		return parseProxyURL(c.HTTPSProxy)
+	case "http", "ws":
+		return parseProxyURL(c.HTTPProxy)
+	}
+	return nil, nil
+}
+
+// Proxy returns the proxy for req. It can be used as Proxy of http.Transport and
+// websocket.Dialer. If logging is enabled, the proxy is logged when a host is first used.
+func (c ProxyConfig) Proxy(req *http.Request) (*url.URL, error) {
+	c.LogProxy(req.URL)
+	return c.ProxyURL(req.URL)
+}
+
+// WithLogging returns a copy of the configuration that logs the proxy of each host once.
+// Copies of the returned configuration share the hosts that have been logged.
+func (c ProxyConfig) WithLogging() ProxyConfig {
+	c.logged = &sync.Map{}
+	return c
+}
+
+// LogProxy logs the proxy for the host of URL, if logging is enabled and the proxy of
+// the host has not been logged yet
+func (c ProxyConfig) LogProxy(URL *url.URL) {
+	if c.logged == nil {
+		return
+	}
+	host := URL.Scheme + "://" + URL.Host
+	if _, logged := c.logged.LoadOrStore(host, true); !logged {
+		log.Printf("Proxy for %s: %s", host, c.Describe(URL))
+	}
+}
+
+// Describe returns the proxy for URL for logging, with credentials of the proxy redacted
+func (c ProxyConfig) Describe(URL *url.URL) string {
+	proxy, err := c.ProxyURL(URL)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("invalid proxy: %s", err)
+	case proxy == nil:
+		return DirectProxy
+	default:
+		return proxy.Redacted()
+	}
+}
+
+func matchesHost(pattern string, URL *url.URL) bool {
+	if matched, _ := path.Match(pattern, URL.Host); matched {
+		return true
+	}
+	matched, _ := path.Match(pattern, URL.Hostname())
+	return matched
+}
+
+func (c ProxyConfig) isNoProxy(URL *url.URL) bool {
+	host, port := strings.ToLower(URL.Hostname()), URL.Port()
+	hostIP := net.ParseIP(host)
+	for _, entry := range strings.Split(c.NoProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if hostIP != nil && cidr.Contains(hostIP) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if entryIP.Equal(hostIP) {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(entryHost, "*.") {
+			entryHost = entryHost[1:]
+		}
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+		} else if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parseProxyURL parses proxy, which can omit the scheme, e.g. 'proxy.io:3128'. Empty
+// proxy means no proxy.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+		if proxyURL, err = url.Parse("http://" + proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy address '%s'", redactProxy(proxy))
+		}
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+		return proxyURL, nil
+	}
+	return nil, fmt.Errorf("invalid proxy address '%s': unsupported scheme '%s'", proxyURL.Redacted(), proxyURL.Scheme)
+}
+
+// redactProxy removes credentials from a proxy address that cannot be parsed
+func redactProxy(proxy string) string {
+	if idx := strings.LastIndex(proxy, "@"); idx >= 0 {
+		return "xxxxx" + proxy[idx:]
+	}
+	return proxy
+}
@@ -0,0 +1,256 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+// SignatureSuffix is appended to the URL of a meta.yaml or an extension archive to get
+// the URL of its detached signature
+const SignatureSuffix = ".sig"
+
+// SignaturePolicy determines how plugins without valid signatures are handled
+type SignaturePolicy string
+
+const (
+	// SignaturePolicyOff disables signature verification
+	SignaturePolicyOff SignaturePolicy = "off"
+	// SignaturePolicyWarn verifies signatures but only reports unsigned and badly signed plugins
+	SignaturePolicyWarn SignaturePolicy = "warn"
+	// SignaturePolicyEnforce fails brokering of unsigned and badly signed plugins
+	SignaturePolicyEnforce SignaturePolicy = "enforce"
+)
+
+// TrustedKey is a public key that plugin artifacts can be signed with
+type TrustedKey struct {
+	// ID is a short fingerprint of the key, used in logs
+	ID  string
+	Key ed25519.PublicKey
+}
+
+// LoadTrustedKeys reads PEM encoded ed25519 public keys ('PUBLIC KEY' blocks) from
+// the file at keysPath
+func LoadTrustedKeys(keysPath string, ioUtil IoUtil) ([]TrustedKey, error) {
+	data, err := ioUtil.ReadFile(keysPath)
+	if err != nil {
+		return nil, NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+			"failed to read trusted keys file '%s': %s", keysPath, err)
+	}
+	var keys []TrustedKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+				"failed to parse public key %d of trusted keys file '%s': %s", len(keys)+1, keysPath, err)
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+				"public key %d of trusted keys file '%s' is not an ed25519 key", len(keys)+1, keysPath)
+		}
+		keys = append(keys, TrustedKey{ID: keyID(edKey), Key: edKey})
+	}
+	if len(keys) == 0 {
+		return nil, NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeInvalidConfig},
+			"trusted keys file '%s' contains no public keys", keysPath)
+	}
+	return keys, nil
+}
+
+func keyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// SignatureResult is the outcome of verification of a single file
+type SignatureResult struct {
+	Status model.SignatureStatus
+	// KeyID is the ID of the trusted key that verified the signature
+	KeyID string
+	// Reason describes why the file is not verified
+	Reason string
+}
+
+func (r SignatureResult) String() string {
+	switch r.Status {
+	case model.SignatureVerified:
+		return fmt.Sprintf("signature verified with key %s", r.KeyID)
+	case model.SignatureUnsigned:
+		return fmt.Sprintf("unsigned: %s", r.Reason)
+	default:
+		return fmt.Sprintf("signature invalid: %s", r.Reason)
+	}
+}
+
+// SignatureReporter is implemented by IoUtil that verifies signatures of fetched and
+// downloaded files
+type SignatureReporter interface {
+	// SignatureResult returns the result of verification of the file fetched or
+	// downloaded from URL, if it was verified
+	SignatureResult(URL string) (SignatureResult, bool)
+}
+
+type verifyingIoUtil struct {
+	IoUtil
+	keys    []TrustedKey
+	policy  SignaturePolicy
+	mu      sync.Mutex
+	results map[string]SignatureResult
+}
+
+// NewVerifyingIoUtil wraps ioUtil so that files fetched and downloaded from URLs are
+// verified against detached ed25519 signatures published at the URL with SignatureSuffix,
+// as base64 encoded signature of the message returned by SignedMessage. The signature
+// thus binds the content of a file to its URL, so that a signed file cannot be served in
+// place of another one, e.g. an older version of a plugin. Plugin registry indexes are
+// not verified. With SignaturePolicyEnforce, fetches and downloads of files that are not
+// signed by any of keys fail; otherwise the result is only recorded.
+func NewVerifyingIoUtil(ioUtil IoUtil, keys []TrustedKey, policy SignaturePolicy) IoUtil {
+	return &verifyingIoUtil{
+		IoUtil:  ioUtil,
+		keys:    keys,
+		policy:  policy,
+		results: make(map[string]SignatureResult),
+	}
+}
+
+// SignedMessage returns the message that is signed for the file at URL with content
+// of the given SHA-256 digest: the URL and the hex encoded digest, each followed by a
+// newline, e.g. "https://registry.io/plugins/redhat/java/1.0/meta.yaml\nsha256:9f86d0...\n"
+func SignedMessage(URL string, digest []byte) []byte {
+	return []byte(fmt.Sprintf("%s\nsha256:%s\n", URL, hex.EncodeToString(digest)))
+}
+
+func (util *verifyingIoUtil) Fetch(ctx context.Context, URL string) ([]byte, error) {
+	data, err := util.IoUtil.Fetch(ctx, URL)
+	if err != nil || strings.HasSuffix(URL, "/index.json") {
+		return data, err
+	}
+	digest := sha256.Sum256(data)
+	if err := util.verify(ctx, URL, digest[:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (util *verifyingIoUtil) Download(ctx context.Context, URL string, destPath string, useContentDisposition bool, progress func(downloaded int64, total int64)) (string, error) {
+	path, err := util.IoUtil.Download(ctx, URL, destPath, useContentDisposition, progress)
+	if err != nil {
+		return path, err
+	}
+	digest, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err := util.verify(ctx, URL, digest); err != nil {
+		if removeErr := util.IoUtil.RemoveFile(path); removeErr != nil {
+			return "", fmt.Errorf("%s. Failed to remove '%s': %s", err, path, removeErr)
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+func (util *verifyingIoUtil) SignatureResult(URL string) (SignatureResult, bool) {
+	util.mu.Lock()
+	defer util.mu.Unlock()
+	result, ok := util.results[URL]
+	return result, ok
+}
+
+// hashFile returns the SHA-256 digest of the file at path, which is read in chunks so
+// that large extension archives are not loaded into memory
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer Close(file)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %s", path, err)
+	}
+	return hash.Sum(nil), nil
+}
+
+// verify records the result of verification of the file fetched from URL, whose content
+// has the given SHA-256 digest, and returns an error if it is not verified and the policy
+// is enforced
+func (util *verifyingIoUtil) verify(ctx context.Context, URL string, digest []byte) error {
+	result := util.check(ctx, URL, digest)
+	util.mu.Lock()
+	util.results[URL] = result
+	util.mu.Unlock()
+	if result.Status == model.SignatureVerified || util.policy != SignaturePolicyEnforce {
+		return nil
+	}
+	return NewBrokerError(model.ErrorDetails{Code: model.ErrorCodeSignatureInvalid, URL: URL},
+		"signature verification of '%s' failed: %s", URL, result.Reason)
+}
+
+func (util *verifyingIoUtil) check(ctx context.Context, URL string, digest []byte) SignatureResult {
+	signatureURL := URL + SignatureSuffix
+	encoded, err := util.IoUtil.Fetch(ctx, signatureURL)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+			return SignatureResult{Status: model.SignatureUnsigned, Reason: fmt.Sprintf("no signature at '%s'", signatureURL)}
+		}
+		return SignatureResult{Status: model.SignatureUnsigned, Reason: fmt.Sprintf("failed to fetch signature from '%s': %s", signatureURL, err)}
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return SignatureResult{Status: model.SignatureInvalid, Reason: fmt.Sprintf("'%s' is not a base64 encoded ed25519 signature", signatureURL)}
+	}
+	message := SignedMessage(URL, digest)
+	for _, key := range util.keys {
+		if ed25519.Verify(key.Key, message, signature) {
+			return SignatureResult{Status: model.SignatureVerified, KeyID: key.ID}
+		}
+	}
+	return SignatureResult{Status: model.SignatureInvalid, Reason: "signature does not match any trusted key"}
+}
+
+// GetSignatureResult returns the result of verification of the file fetched or downloaded
+// from URL by ioUtil, if ioUtil verifies signatures
+func GetSignatureResult(ioUtil IoUtil, URL string) (SignatureResult, bool) {
+	reporter, ok := ioUtil.(SignatureReporter)
+	if !ok {
+		return SignatureResult{}, false
+	}
+	return reporter.SignatureResult(URL)
+}
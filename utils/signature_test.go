@@ -0,0 +1,192 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func generateTrustedKey(t *testing.T) (TrustedKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TrustedKey{ID: keyID(public), Key: public}, private
+}
+
+func sign(private ed25519.PrivateKey, URL string, data string) string {
+	digest := sha256.Sum256([]byte(data))
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(private, SignedMessage(URL, digest[:])))
+}
+
+// newSignedFilesServer serves files by path; paths without a file respond with 404
+func newSignedFilesServer(files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+}
+
+func TestVerifyingIoUtilFetch(t *testing.T) {
+	trusted, trustedPrivate := generateTrustedKey(t)
+	_, otherPrivate := generateTrustedKey(t)
+	files := map[string]string{
+		"/signed/meta.yaml":      "signed",
+		"/unsigned/meta.yaml":    "unsigned",
+		"/untrusted/meta.yaml":   "untrusted",
+		"/tampered/meta.yaml":    "tampered",
+		"/substituted/meta.yaml": "signed",
+		"/malformed/meta.yaml":   "malformed",
+		"/index.json":            "[]",
+	}
+	server := newSignedFilesServer(files)
+	defer server.Close()
+	files["/signed/meta.yaml.sig"] = sign(trustedPrivate, server.URL+"/signed/meta.yaml", "signed")
+	files["/untrusted/meta.yaml.sig"] = sign(otherPrivate, server.URL+"/untrusted/meta.yaml", "untrusted")
+	files["/tampered/meta.yaml.sig"] = sign(trustedPrivate, server.URL+"/tampered/meta.yaml", "original")
+	// A signed file served in place of another one
+	files["/substituted/meta.yaml.sig"] = files["/signed/meta.yaml.sig"]
+	files["/malformed/meta.yaml.sig"] = "not a signature"
+	tests := []struct {
+		path       string
+		wantStatus model.SignatureStatus
+		wantErr    string
+	}{
+		{path: "/signed/meta.yaml", wantStatus: model.SignatureVerified},
+		{path: "/unsigned/meta.yaml", wantStatus: model.SignatureUnsigned,
+			wantErr: "signature verification of '%[1]s/unsigned/meta.yaml' failed: no signature at '%[1]s/unsigned/meta.yaml.sig'"},
+		{path: "/untrusted/meta.yaml", wantStatus: model.SignatureInvalid,
+			wantErr: "signature verification of '%[1]s/untrusted/meta.yaml' failed: signature does not match any trusted key"},
+		{path: "/tampered/meta.yaml", wantStatus: model.SignatureInvalid,
+			wantErr: "signature verification of '%[1]s/tampered/meta.yaml' failed: signature does not match any trusted key"},
+		{path: "/substituted/meta.yaml", wantStatus: model.SignatureInvalid,
+			wantErr: "signature verification of '%[1]s/substituted/meta.yaml' failed: signature does not match any trusted key"},
+		{path: "/malformed/meta.yaml", wantStatus: model.SignatureInvalid,
+			wantErr: "signature verification of '%[1]s/malformed/meta.yaml' failed: '%[1]s/malformed/meta.yaml.sig' is not a base64 encoded ed25519 signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			URL := server.URL + tt.path
+			warn := NewVerifyingIoUtil(New(0, nil, ""), []TrustedKey{trusted}, SignaturePolicyWarn)
+			enforce := NewVerifyingIoUtil(New(0, nil, ""), []TrustedKey{trusted}, SignaturePolicyEnforce)
+
+			_, err := warn.Fetch(context.Background(), URL)
+			assert.NoError(t, err)
+			result, ok := GetSignatureResult(warn, URL)
+			assert.True(t, ok)
+			assert.Equal(t, tt.wantStatus, result.Status)
+
+			_, err = enforce.Fetch(context.Background(), URL)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, fmt.Sprintf(tt.wantErr, server.URL))
+			assert.Equal(t, &model.ErrorDetails{Code: model.ErrorCodeSignatureInvalid, URL: URL}, GetErrorDetails(err))
+		})
+	}
+
+	t.Run("Registry index is not verified", func(t *testing.T) {
+		enforce := NewVerifyingIoUtil(New(0, nil, ""), []TrustedKey{trusted}, SignaturePolicyEnforce)
+
+		data, err := enforce.Fetch(context.Background(), server.URL+"/index.json")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "[]", string(data))
+		_, ok := GetSignatureResult(enforce, server.URL+"/index.json")
+		assert.False(t, ok)
+	})
+}
+
+func TestVerifyingIoUtilDownloadRemovesRejectedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trusted, trustedPrivate := generateTrustedKey(t)
+	files := map[string]string{
+		"/signed.vsix":   "signed",
+		"/unsigned.vsix": "unsigned",
+	}
+	server := newSignedFilesServer(files)
+	defer server.Close()
+	files["/signed.vsix.sig"] = sign(trustedPrivate, server.URL+"/signed.vsix", "signed")
+	ioUtil := NewVerifyingIoUtil(New(0, nil, ""), []TrustedKey{trusted}, SignaturePolicyEnforce)
+
+	path, err := ioUtil.Download(context.Background(), server.URL+"/signed.vsix", filepath.Join(dir, "signed.vsix"), false, nil)
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+	result, _ := GetSignatureResult(ioUtil, server.URL+"/signed.vsix")
+	assert.Equal(t, SignatureResult{Status: model.SignatureVerified, KeyID: trusted.ID}, result)
+
+	unsignedPath := filepath.Join(dir, "unsigned.vsix")
+	_, err = ioUtil.Download(context.Background(), server.URL+"/unsigned.vsix", unsignedPath, false, nil)
+	assert.Error(t, err)
+	_, statErr := os.Stat(unsignedPath)
+	assert.True(t, os.IsNotExist(statErr))
+}
+
+func TestLoadTrustedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broker-tests-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first, _ := generateTrustedKey(t)
+	second, _ := generateTrustedKey(t)
+	var keysPEM []byte
+	for _, key := range []TrustedKey{first, second} {
+		der, err := x509.MarshalPKIXPublicKey(key.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keysPEM = append(keysPEM, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	keysPath := filepath.Join(dir, "keys.pem")
+	if err := ioutil.WriteFile(keysPath, keysPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyPath := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(emptyPath, []byte("no keys"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadTrustedKeys(keysPath, New(0, nil, ""))
+	assert.NoError(t, err)
+	assert.Equal(t, []TrustedKey{first, second}, keys)
+	assert.Len(t, keys[0].ID, 16)
+
+	_, err = LoadTrustedKeys(emptyPath, New(0, nil, ""))
+	assert.EqualError(t, err, "trusted keys file '"+emptyPath+"' contains no public keys")
+}
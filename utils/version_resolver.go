@@ -0,0 +1,288 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eclipse/che-plugin-broker/model"
+)
+
+// RegistryIndexURLFormat specifies the format string for URLs of plugin
+// registry indexes
+const RegistryIndexURLFormat = "%s/index.json"
+
+// LatestVersion is the version of a plugin ID that resolves to the highest
+// version available in the plugin registry
+const LatestVersion = "latest"
+
+// registryIndexEntry is an entry of the plugin registry index
+type registryIndexEntry struct {
+	ID string `json:"id"`
+}
+
+// semver is a semantic version. Build metadata is ignored.
+type semver struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+// parseSemver parses a version of form 'major[.minor[.patch]][-prerelease][+build]'.
+// Missing minor and patch numbers are zero, e.g. '1.0' is parsed as 1.0.0.
+func parseSemver(version string) (semver, bool) {
+	version = strings.SplitN(version, "+", 2)[0]
+	var v semver
+	parts := strings.SplitN(version, "-", 2)
+	if len(parts) == 2 {
+		v.prerelease = parts[1]
+	}
+	numbers, ok := parseVersionNumbers(parts[0])
+	if !ok {
+		return semver{}, false
+	}
+	v.major, v.minor, v.patch = versionNumber(numbers, 0), versionNumber(numbers, 1), versionNumber(numbers, 2)
+	return v, true
+}
+
+// versionNumber returns the i-th number of a version, or 0 if the version has fewer numbers
+func versionNumber(numbers []int, i int) int {
+	if i < len(numbers) {
+		return numbers[i]
+	}
+	return 0
+}
+
+// parseVersionNumbers parses a dot-separated list of numbers, e.g. '0.50'
+func parseVersionNumbers(version string) ([]int, bool) {
+	var numbers []int
+	for _, part := range strings.Split(version, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return nil, false
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, len(numbers) <= 3
+}
+
+func (v semver) less(other semver) bool {
+	if v.major != other.major {
+		return v.major < other.major
+	}
+	if v.minor != other.minor {
+		return v.minor < other.minor
+	}
+	if v.patch != other.patch {
+		return v.patch < other.patch
+	}
+	// A pre-release version has lower precedence than the associated normal version
+	if v.prerelease == "" || other.prerelease == "" {
+		return v.prerelease != "" && other.prerelease == ""
+	}
+	return comparePrerelease(v.prerelease, other.prerelease) < 0
+}
+
+func comparePrerelease(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				return aNum - bNum
+			}
+		case aErr == nil:
+			// Numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(aParts) - len(bParts)
+}
+
+// versionRange is a range of semantic versions, from min to max exclusive
+type versionRange struct {
+	min, max     semver
+	minExclusive bool
+	// unbounded means that the range has no max
+	unbounded bool
+	// latest matches any version
+	latest bool
+}
+
+// IsVersionRange checks whether version is a caret (^), tilde (~) or comparison
+// (>, >=, <, <=) range, rather than an exact version
+func IsVersionRange(version string) bool {
+	return strings.HasPrefix(version, "^") || strings.HasPrefix(version, "~") ||
+		strings.HasPrefix(version, ">") || strings.HasPrefix(version, "<")
+}
+
+// parseVersionRange parses a caret, tilde or comparison range with npm semantics, e.g.
+// '^1.2.3' matches versions from 1.2.3 to 2.0.0, '^0.50' matches versions from 0.50.0
+// to 0.51.0, '~1.2.3' matches versions from 1.2.3 to 1.3.0 and '<=1.2' matches versions
+// lower than 1.3.0.
+func parseVersionRange(version string) (versionRange, bool) {
+	var operator string
+	for _, op := range []string{"^", "~", ">=", "<=", ">", "<"} {
+		if strings.HasPrefix(version, op) {
+			operator = op
+			break
+		}
+	}
+	if operator == "" {
+		return versionRange{}, false
+	}
+	numbers, ok := parseVersionNumbers(version[len(operator):])
+	if !ok {
+		return versionRange{}, false
+	}
+	min := semver{major: versionNumber(numbers, 0), minor: versionNumber(numbers, 1), patch: versionNumber(numbers, 2)}
+	// next is the lowest version that does not match the given, possibly partial, version
+	next := semver{major: min.major + 1}
+	if len(numbers) == 2 {
+		next = semver{major: min.major, minor: min.minor + 1}
+	} else if len(numbers) == 3 {
+		next = semver{major: min.major, minor: min.minor, patch: min.patch + 1}
+	}
+	switch operator {
+	case ">=":
+		return versionRange{min: min, unbounded: true}, true
+	case ">":
+		if len(numbers) == 3 {
+			return versionRange{min: min, minExclusive: true, unbounded: true}, true
+		}
+		return versionRange{min: next, unbounded: true}, true
+	case "<=":
+		return versionRange{max: next}, true
+	case "<":
+		return versionRange{max: min}, true
+	}
+	max := semver{major: min.major + 1}
+	if operator == "~" {
+		if len(numbers) > 1 {
+			max = semver{major: min.major, minor: min.minor + 1}
+		}
+	} else if min.major == 0 && len(numbers) > 1 {
+		// Caret ranges allow changes that do not modify the left-most non-zero number
+		max = semver{minor: min.minor + 1}
+		if min.minor == 0 && len(numbers) > 2 {
+			max = semver{patch: min.patch + 1}
+		}
+	}
+	return versionRange{min: min, max: max}, true
+}
+
+// matches checks whether v is in the range. Pre-release versions match only 'latest'.
+func (r versionRange) matches(v semver) bool {
+	if r.latest {
+		return true
+	}
+	if v.prerelease != "" || v.less(r.min) || (r.minExclusive && v == r.min) {
+		return false
+	}
+	return r.unbounded || v.less(r.max)
+}
+
+// ResolvePluginVersion resolves the version range in the ID of plugin against the
+// index of its plugin registry and returns plugin with the exact ID of the highest
+// matching version. 'latest' resolves to the highest version, preferring released
+// versions over pre-release ones, unless the registry has a version literally named
+// 'latest'. Plugins with exact versions or requested by reference are returned unchanged.
+func ResolvePluginVersion(ctx context.Context, plugin model.PluginFQN, defaultRegistry string, ioUtil IoUtil) (model.PluginFQN, error) {
+	idx := strings.LastIndex(plugin.ID, "/")
+	if plugin.Reference != "" || idx < 0 {
+		return plugin, nil
+	}
+	prefix, version := plugin.ID[:idx+1], plugin.ID[idx+1:]
+	var versions versionRange
+	if version == LatestVersion {
+		versions = versionRange{latest: true}
+	} else if IsVersionRange(version) {
+		var ok bool
+		if versions, ok = parseVersionRange(version); !ok {
+			return plugin, NewBrokerError(
+				model.ErrorDetails{Code: model.ErrorCodeInvalidConfig, PluginID: plugin.ID},
+				"invalid version range '%s' of plugin '%s'", version, plugin.ID)
+		}
+	} else {
+		return plugin, nil
+	}
+
+	registry, err := getRegistryURL(plugin, defaultRegistry)
+	if err != nil {
+		return plugin, err
+	}
+	indexURL := fmt.Sprintf(RegistryIndexURLFormat, registry)
+	indexRaw, err := ioUtil.Fetch(ctx, indexURL)
+	if err != nil {
+		if httpErr, ok := err.(*HTTPError); ok {
+			return plugin, NewRequestError(model.ErrorCodeRegistryError, plugin.ID, indexURL, err, fmt.Sprintf(
+				"failed to fetch plugin registry index from URL '%s': %s. Response body: %s",
+				indexURL, httpErr, httpErr.Body))
+		}
+		return plugin, NewRequestError(model.ErrorCodeRegistryUnreachable, plugin.ID, indexURL, err, fmt.Sprintf(
+			"failed to fetch plugin registry index from URL '%s': %s", indexURL, err))
+	}
+	var index []registryIndexEntry
+	if err := json.Unmarshal(indexRaw, &index); err != nil {
+		return plugin, NewBrokerError(
+			model.ErrorDetails{Code: model.ErrorCodeRegistryError, PluginID: plugin.ID, URL: indexURL, HTTPStatus: http.StatusOK},
+			"failed to unmarshal plugin registry index from URL '%s': %s", indexURL, err)
+	}
+
+	var best, bestPrerelease *semver
+	var bestVersion, bestPrereleaseVersion string
+	for _, entry := range index {
+		if entry.ID == plugin.ID {
+			// e.g. a version directory literally named 'latest'
+			return plugin, nil
+		}
+		if !strings.HasPrefix(entry.ID, prefix) || strings.Contains(entry.ID[len(prefix):], "/") {
+			continue
+		}
+		entryVersion := entry.ID[len(prefix):]
+		v, ok := parseSemver(entryVersion)
+		if !ok || !versions.matches(v) {
+			continue
+		}
+		if v.prerelease == "" {
+			if best == nil || best.less(v) {
+				best, bestVersion = &v, entryVersion
+			}
+		} else if bestPrerelease == nil || bestPrerelease.less(v) {
+			bestPrerelease, bestPrereleaseVersion = &v, entryVersion
+		}
+	}
+	if best == nil && bestPrerelease == nil {
+		return plugin, NewBrokerError(
+			model.ErrorDetails{Code: model.ErrorCodePluginNotFound, PluginID: plugin.ID, URL: indexURL},
+			"no version of plugin '%s' in registry '%s' matches '%s'", strings.TrimSuffix(prefix, "/"), registry, version)
+	}
+	if best == nil {
+		bestVersion = bestPrereleaseVersion
+	}
+	resolved := plugin
+	resolved.ID = prefix + bestVersion
+	return resolved, nil
+}
@@ -0,0 +1,137 @@
+//
+// Copyright (c) 2020 Red Hat, Inc.
+// This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License 2.0
+// which is available at https://www.eclipse.org/legal/epl-2.0/
+//
+// SPDX-License-Identifier: EPL-2.0
+//
+// Contributors:
+//   Red Hat, Inc. - initial API and implementation
+//
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eclipse/che-plugin-broker/model"
+	utilMock "github.com/eclipse/che-plugin-broker/utils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testRegistryIndex = `[
+  {"id": "redhat/java/0.49.0"},
+  {"id": "redhat/java/0.50.0"},
+  {"id": "redhat/java/0.50.2"},
+  {"id": "redhat/java/0.51.0-rc.1"},
+  {"id": "redhat/java/0.51.0-rc.10"},
+  {"id": "redhat/java/1.2.0"},
+  {"id": "redhat/java/1.10.0"},
+  {"id": "redhat/java/next"},
+  {"id": "redhat/java11/2.0.0"},
+  {"id": "eclipse/che-theia/next"}
+]`
+
+func TestResolvePluginVersion(t *testing.T) {
+	tests := []struct {
+		id      string
+		index   string
+		wantID  string
+		wantErr string
+	}{
+		{id: "redhat/java/latest", index: testRegistryIndex, wantID: "redhat/java/1.10.0"},
+		{id: "redhat/java/^0.50", index: testRegistryIndex, wantID: "redhat/java/0.50.2"},
+		{
+			id:      "redhat/java/^0.49.1",
+			index:   testRegistryIndex,
+			wantErr: "no version of plugin 'redhat/java' in registry 'registry.io/plugins' matches '^0.49.1'",
+		},
+		{id: "redhat/java/~0.50.1", index: testRegistryIndex, wantID: "redhat/java/0.50.2"},
+		{id: "redhat/java/^1", index: testRegistryIndex, wantID: "redhat/java/1.10.0"},
+		{id: "redhat/java/~1.2", index: testRegistryIndex, wantID: "redhat/java/1.2.0"},
+		{id: "redhat/java/^0", index: testRegistryIndex, wantID: "redhat/java/0.50.2"},
+		{id: "redhat/java/>=0.50", index: testRegistryIndex, wantID: "redhat/java/1.10.0"},
+		{id: "redhat/java/<1.2.0", index: testRegistryIndex, wantID: "redhat/java/0.50.2"},
+		{id: "redhat/java/<=1.2", index: testRegistryIndex, wantID: "redhat/java/1.2.0"},
+		{id: "redhat/java/>1.2", index: testRegistryIndex, wantID: "redhat/java/1.10.0"},
+		{
+			id:      "redhat/java/>1.10.0",
+			index:   testRegistryIndex,
+			wantErr: "no version of plugin 'redhat/java' in registry 'registry.io/plugins' matches '>1.10.0'",
+		},
+		{id: "redhat/java/^1.0", index: `[{"id": "redhat/java/1.0"}, {"id": "redhat/java/1.1"}]`, wantID: "redhat/java/1.1"},
+		{id: "redhat/java/latest", index: `[{"id": "redhat/java/1.0"}, {"id": "redhat/java/latest"}]`, wantID: "redhat/java/latest"},
+		{
+			id:     "redhat/java/latest",
+			index:  `[{"id": "redhat/java/0.51.0-rc.2"}, {"id": "redhat/java/0.51.0-rc.10"}, {"id": "redhat/java/0.51.0-beta"}]`,
+			wantID: "redhat/java/0.51.0-rc.10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			ioUtil := &utilMock.IoUtil{}
+			ioUtil.On("Fetch", mock.Anything, "registry.io/plugins/index.json").Return([]byte(tt.index), nil)
+
+			got, err := ResolvePluginVersion(context.Background(), generatePluginFQN("registry.io", tt.id, ""), "", ioUtil)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, model.ErrorCodePluginNotFound, GetErrorDetails(err).Code)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, generatePluginFQN("registry.io", tt.wantID, ""), got)
+		})
+	}
+}
+
+func TestResolvePluginVersionKeepsExactVersions(t *testing.T) {
+	ioUtil := &utilMock.IoUtil{}
+	plugins := []model.PluginFQN{
+		generatePluginFQN("registry.io", "redhat/java/0.50.0", ""),
+		generatePluginFQN("registry.io", "eclipse/che-theia/next", ""),
+		generatePluginFQN("registry.io", "redhat/java/1.0", ""),
+		generatePluginFQN("", "", "https://reference.io/latest"),
+	}
+	for _, plugin := range plugins {
+		got, err := ResolvePluginVersion(context.Background(), plugin, "", ioUtil)
+		assert.NoError(t, err)
+		assert.Equal(t, plugin, got)
+	}
+	ioUtil.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+}
+
+func TestResolvePluginVersionReportsErrors(t *testing.T) {
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "unreachable.io/plugins/index.json").Return(nil, errors.New("test error"))
+	ioUtil.On("Fetch", mock.Anything, "invalid.io/plugins/index.json").Return([]byte("{"), nil)
+
+	_, err := ResolvePluginVersion(context.Background(), generatePluginFQN("registry.io", "redhat/java/^x", ""), "", ioUtil)
+	assert.EqualError(t, err, "invalid version range '^x' of plugin 'redhat/java/^x'")
+	assert.Equal(t, model.ErrorCodeInvalidConfig, GetErrorDetails(err).Code)
+
+	_, err = ResolvePluginVersion(context.Background(), generatePluginFQN("unreachable.io", "redhat/java/latest", ""), "", ioUtil)
+	assert.EqualError(t, err, "failed to fetch plugin registry index from URL 'unreachable.io/plugins/index.json': test error")
+	assert.Equal(t, model.ErrorCodeRegistryUnreachable, GetErrorDetails(err).Code)
+
+	_, err = ResolvePluginVersion(context.Background(), generatePluginFQN("invalid.io", "redhat/java/latest", ""), "", ioUtil)
+	assert.Regexp(t, "^failed to unmarshal plugin registry index from URL 'invalid.io/plugins/index.json'", err.Error())
+	assert.Equal(t, model.ErrorCodeRegistryError, GetErrorDetails(err).Code)
+}
+
+func TestGetPluginMetaResolvesLatestVersion(t *testing.T) {
+	_, metaRaw := generatePluginMeta(t, "redhat/java/1.10.0")
+	ioUtil := &utilMock.IoUtil{}
+	ioUtil.On("Fetch", mock.Anything, "registry.io/plugins/index.json").Return([]byte(testRegistryIndex), nil)
+	ioUtil.On("Fetch", mock.Anything, "registry.io/plugins/redhat/java/1.10.0/meta.yaml").Return(metaRaw, nil)
+
+	got, err := GetPluginMeta(context.Background(), generatePluginFQN("registry.io", "redhat/java/latest", ""), nil, ioUtil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "redhat/java/1.10.0", got.ID)
+	assert.Equal(t, "redhat/java/latest", got.RequestedID)
+}